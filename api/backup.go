@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/rs/zerolog/log"
+)
+
+// BackupErrorTrailer carries the error of a backup that failed after streaming began.
+const BackupErrorTrailer = "X-Backup-Error"
+
+// BackupHandler streams a backup archive of the provider. The archive holds every stored file, so
+// it is only served by the admin API.
+func BackupHandler(f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		withBlocks := false
+		if b := req.URL.Query().Get("blocks"); b != "" {
+			var err error
+			withBlocks, err = strconv.ParseBool(b)
+			if err != nil {
+				handleErr(fmt.Errorf("cannot parse blocks: %w", err), w, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// a backup takes as long as it takes, the server write timeout is meant for everything else
+		err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Warn().Err(err).Msg("cannot lift write deadline for backup")
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Trailer", BackupErrorTrailer)
+
+		res, err := f.Backup(req.Context(), w, withBlocks)
+		if err != nil {
+			log.Error().Err(err).Msg("backup failed")
+			w.Header().Set(BackupErrorTrailer, err.Error())
+			return
+		}
+
+		log.Info().Int("cids", res.Cids).Int("blocks", res.Blocks).Msg("Served backup")
+	}
+}
@@ -2,16 +2,18 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/JackalLabs/sequoia/api/types"
+	"github.com/JackalLabs/sequoia/file_system"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/desmos-labs/cosmos-go-wallet/client"
 	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
 	"github.com/rs/zerolog/log"
 )
 
-func SpaceHandler(c *client.Client, address string) func(http.ResponseWriter, *http.Request) {
+func SpaceHandler(c *client.Client, address string, f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		queryClient := storageTypes.NewQueryClient(c.GRPCConn)
 
@@ -56,10 +58,23 @@ func SpaceHandler(c *client.Client, address string) func(http.ResponseWriter, *h
 			return
 		}
 
+		local, err := f.SpaceUsage()
+		if err != nil {
+			handleErr(fmt.Errorf("cannot read local space: %w", err), w, http.StatusInternalServerError)
+			return
+		}
+
 		v := types.SpaceResponse{
 			Total: ttint.Int64(),
 			Free:  freeSpace,
 			Used:  ttint.Int64() - freeSpace,
+			Local: types.LocalSpaceResponse{
+				Offered:     local.Offered,
+				Stored:      local.Stored,
+				Reserved:    local.Reserved,
+				DiskFree:    local.DiskFree,
+				DiskReserve: local.DiskReserve,
+			},
 		}
 
 		err = json.NewEncoder(w).Encode(v)
@@ -45,10 +45,26 @@ func handleErr(err error, w http.ResponseWriter, code int) {
 	}
 }
 
+// handleSpaceErr answers with 507 Insufficient Storage when the file doesn't fit on this provider.
+func handleSpaceErr(err error, w http.ResponseWriter) {
+	if errors.Is(err, file_system.ErrInsufficientSpace) {
+		handleErr(err, w, http.StatusInsufficientStorage)
+		return
+	}
+	handleErr(fmt.Errorf("cannot check storage space: %w", err), w, http.StatusInternalServerError)
+}
+
 func PostFileHandler(fio *file_system.FileSystem, prover *proofs.Prover, wl *wallet.Wallet, chunkSize int64) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		// refuse uploads that can't fit before reading the body, the request is a little larger than the file
+		err := fio.CheckSpace(max(req.ContentLength, 0))
+		if err != nil {
+			handleSpaceErr(err, w)
+			return
+		}
+
 		// Use streaming multipart parsing instead of loading entire form into memory
-		sender, merkleString, startBlockString, proofTypeString, file, _, err := parseMultipartFormStreaming(req)
+		sender, merkleString, startBlockString, proofTypeString, file, _, err := parseMultipartFormStreaming(req, true)
 		if err != nil {
 			handleErr(fmt.Errorf("cannot parse form %w", err), w, http.StatusBadRequest)
 			return
@@ -106,7 +122,14 @@ func PostFileHandler(fio *file_system.FileSystem, prover *proofs.Prover, wl *wal
 			}
 		}
 
-		size, c, err := fio.WriteFile(file, merkle, sender, startBlock, chunkSize, proofType, utils.GetIPFSParams(&f))
+		release, err := fio.ReserveSpace(f.FileSize)
+		if err != nil {
+			handleSpaceErr(err, w)
+			return
+		}
+		defer release()
+
+		size, c, err := fio.WriteFile(file, merkle, sender, startBlock, chunkSize, proofType, utils.GetIPFSParams(&f), file_system.SourceUpload)
 		if err != nil {
 			handleErr(fmt.Errorf("failed to write file to disk: %w", err), w, http.StatusInternalServerError)
 			return
@@ -135,8 +158,15 @@ func PostFileHandler(fio *file_system.FileSystem, prover *proofs.Prover, wl *wal
 
 func PostFileHandlerV2(fio *file_system.FileSystem, prover *proofs.Prover, wl *wallet.Wallet, chunkSize int64) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		// refuse uploads that can't fit before reading the body, the request is a little larger than the file
+		err := fio.CheckSpace(max(req.ContentLength, 0))
+		if err != nil {
+			handleSpaceErr(err, w)
+			return
+		}
+
 		// Use streaming multipart parsing instead of loading entire form into memory
-		sender, merkleString, startBlockString, _, file, _, err := parseMultipartFormStreaming(req)
+		sender, merkleString, startBlockString, _, file, _, err := parseMultipartFormStreaming(req, false)
 		if file != nil {
 			//nolint:errcheck
 			defer file.Close()
@@ -178,6 +208,9 @@ func PostFileHandlerV2(fio *file_system.FileSystem, prover *proofs.Prover, wl *w
 
 		JobMap.Store(jobId, &up)
 
+		// a streamed file is still being read from the request body after the response is written
+		_ = http.NewResponseController(w).EnableFullDuplex()
+
 		resp := types.AcceptedUploadResponse{ // send accepted response
 			JobID: jobId,
 		}
@@ -222,7 +255,15 @@ func PostFileHandlerV2(fio *file_system.FileSystem, prover *proofs.Prover, wl *w
 
 		log.Info().Msgf("file: %x | type: %d", f.Merkle, f.ProofType)
 
-		size, c, err := fio.WriteFileWithProgress(file, merkle, sender, startBlock, chunkSize, f.ProofType, utils.GetIPFSParams(&f), &up)
+		release, err := fio.ReserveSpace(f.FileSize)
+		if err != nil {
+			log.Error().Err(err).Hex("merkle", merkle).Msg("cannot accept upload")
+			up.Status = fmt.Sprintf("Error: %s", err.Error())
+			return
+		}
+		defer release()
+
+		size, c, err := fio.WriteFileWithProgress(file, merkle, sender, startBlock, chunkSize, f.ProofType, utils.GetIPFSParams(&f), file_system.SourceUpload, &up)
 		if err != nil {
 			log.Error().Err(fmt.Errorf("failed to write file to disk: %w", err))
 			up.Status = fmt.Sprintf("Error: Could not write file to disk %s", err.Error())
@@ -384,6 +425,11 @@ func DownloadFileHandler(f *file_system.FileSystem) func(http.ResponseWriter, *h
 			return
 		}
 
+		if req.URL.Query().Get("format") == "car" {
+			downloadCAR(f, w, req, merkle, fileName)
+			return
+		}
+
 		file, err := f.GetFileData(merkle)
 		if err != nil {
 			v := types.ErrorResponse{
@@ -394,11 +440,34 @@ func DownloadFileHandler(f *file_system.FileSystem) func(http.ResponseWriter, *h
 			return
 		}
 		defer func() { _ = file.Close() }()
+		f.FileServed(merkle)
 
 		http.ServeContent(w, req, fileName, time.Time{}, file)
 	}
 }
 
+// downloadCAR streams the IPFS DAG of a file as a CARv1 archive, a stream cannot carry the CARv2 index.
+func downloadCAR(f *file_system.FileSystem, w http.ResponseWriter, req *http.Request, merkle []byte, fileName string) {
+	_, err := f.GetCIDFromMerkle(merkle)
+	if err != nil {
+		v := types.ErrorResponse{
+			Error: err.Error(),
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.car\"", fileName))
+
+	err = f.ExportCAR(req.Context(), merkle, w, true)
+	if err != nil {
+		// the headers are already sent, the truncated archive fails to parse on the client
+		log.Error().Err(err).Str("merkle", hex.EncodeToString(merkle)).Msg("failed to export car")
+	}
+}
+
 // getFolderData attempts to unmarshal the provided data into a FolderData structure.
 // It returns the FolderData and true on success, or nil and false if unmarshaling fails.
 func getFolderData(data io.Reader) (*sequoiaTypes.FolderData, bool) {
@@ -416,6 +485,7 @@ func getFolderData(data io.Reader) (*sequoiaTypes.FolderData, bool) {
 func getMerkleData(merkle []byte, fileName string, f *file_system.FileSystem, wallet *wallet.Wallet, myIp string) (io.ReadSeekCloser, error) {
 	file, err := f.GetFileData(merkle)
 	if err == nil {
+		f.FileServed(merkle)
 		return file, nil
 	}
 
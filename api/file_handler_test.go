@@ -28,7 +28,7 @@ func writeFile(f *file_system.FileSystem, file []byte) ([]byte, uint, error) {
 		return root, 0, err
 	}
 
-	size, _, err := f.WriteFile(reader2, root, owner, 0, 1024, 0, nil)
+	size, _, err := f.WriteFile(reader2, root, owner, 0, 1024, 0, nil, file_system.SourceUpload)
 	return root, uint(size), err
 }
 
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/JackalLabs/sequoia/api/types"
 	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 )
 
@@ -75,3 +80,75 @@ func LegacyListFilesHandler(f *file_system.FileSystem) func(http.ResponseWriter,
 		}
 	}
 }
+
+func fileDetails(m *file_system.FileMetadata) types.FileDetails {
+	return types.FileDetails{
+		Merkle:    hex.EncodeToString(m.Merkle),
+		Owner:     m.Owner,
+		Start:     m.Start,
+		Size:      m.Size,
+		ProofType: m.ProofType,
+		ChunkSize: m.ChunkSize,
+		CID:       m.CID,
+		Source:    m.Source,
+		StoredAt:  m.StoredAt,
+	}
+}
+
+// ListFileDetailsHandler lists the stored metadata of every contract, contracts stored before
+// metadata was recorded are left out.
+func ListFileDetailsHandler(f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		metas, err := f.ListFileMetadata()
+		if err != nil {
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+
+		files := make([]types.FileDetails, len(metas))
+		for i := range metas {
+			files[i] = fileDetails(&metas[i])
+		}
+
+		err = json.NewEncoder(w).Encode(types.FileDetailsResponse{
+			Files: files,
+			Count: len(files),
+		})
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+}
+
+func FileDetailsHandler(f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		merkle, err := hex.DecodeString(vars["merkle"])
+		if err != nil {
+			handleErr(fmt.Errorf("cannot parse merkle: %w", err), w, http.StatusBadRequest)
+			return
+		}
+
+		start, err := strconv.ParseInt(vars["start"], 10, 64)
+		if err != nil {
+			handleErr(fmt.Errorf("cannot parse start block: %w", err), w, http.StatusBadRequest)
+			return
+		}
+
+		meta, err := f.GetFileMetadata(merkle, vars["owner"], start)
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				handleErr(fmt.Errorf("no metadata for %x", merkle), w, http.StatusNotFound)
+				return
+			}
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(fileDetails(meta))
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+}
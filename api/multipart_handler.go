@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -12,7 +13,7 @@ import (
 )
 
 const (
-	MaxMemoryFileSize = 10 << 20 // 10 MB - files larger than this go to disk
+	MaxMemoryFileSize = 10 << 20 // 10 MB - larger files are streamed, or go to disk when sent before the form fields
 	MaxFileSize       = 32 << 30 // 32 GiB - maximum allowed file size
 )
 
@@ -144,26 +145,62 @@ func processLargeFile(part *multipart.Part, peekBuffer []byte, n int) (sequoiaTy
 	return file, fh, nil
 }
 
-// processFilePart handles the file part of the multipart form using hybrid memory/disk approach
-func processFilePart(part *multipart.Part) (sequoiaTypes.FileReader, *multipart.FileHeader, error) {
+// partReader streams the rest of a file part straight into WriteFile, failing once the file is larger
+// than MaxFileSize.
+type partReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.read > MaxFileSize {
+		return n, fmt.Errorf("file size exceeds maximum allowed size %d", MaxFileSize)
+	}
+	return n, err
+}
+
+func (p *partReader) Close() error {
+	return nil
+}
+
+// processFilePart handles the file part of the multipart form. Small files are kept in memory. A
+// larger file is streamed as it is read when stream is set, which is only possible once every form
+// field has been read, otherwise it is spooled to a temporary file so the fields after it can be read.
+func processFilePart(part *multipart.Part, stream bool) (io.ReadCloser, *multipart.FileHeader, bool, error) {
 	// Read a small chunk to determine if we should use memory or disk
 	peekBuffer := make([]byte, MaxMemoryFileSize+1) // Read one extra byte to detect if file is larger
 	n, err := io.ReadFull(part, peekBuffer)
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return nil, nil, fmt.Errorf("error reading file data: %w", err)
+		return nil, nil, false, fmt.Errorf("error reading file data: %w", err)
 	}
 
 	// Check if file fits in memory (n <= MaxMemoryFileSize)
 	if n <= MaxMemoryFileSize {
-		return processSmallFile(part, peekBuffer, n)
-	} else {
-		return processLargeFile(part, peekBuffer, n)
+		file, fh, err := processSmallFile(part, peekBuffer, n)
+		return file, fh, false, err
 	}
+
+	if !stream {
+		file, fh, err := processLargeFile(part, peekBuffer, n)
+		return file, fh, false, err
+	}
+
+	log.Debug().Msg("Streaming large file straight into the blockstore")
+	file := &partReader{r: io.MultiReader(bytes.NewReader(peekBuffer[:n]), part)}
+	fh := &multipart.FileHeader{
+		Filename: part.FileName(),
+		Header:   part.Header,
+		Size:     -1, // unknown until the part is read
+	}
+	return file, fh, true, nil
 }
 
-// parseMultipartFormStreaming parses multipart form data using a streaming approach
-// to reduce memory usage. It extracts form fields and streams the file to a temporary file.
-func parseMultipartFormStreaming(req *http.Request) (sender, merkleString, startBlockString, proofTypeString string, file sequoiaTypes.FileReader, fh *multipart.FileHeader, err error) {
+// parseMultipartFormStreaming parses multipart form data using a streaming approach to reduce memory
+// usage. A large file sent after the sender, merkle and start fields, and the type field when typed is
+// set, is left in the request body for the caller to read while writing it.
+func parseMultipartFormStreaming(req *http.Request, typed bool) (sender, merkleString, startBlockString, proofTypeString string, file io.ReadCloser, fh *multipart.FileHeader, err error) {
 	// Parse the multipart form boundary
 	reader, err := req.MultipartReader()
 	if err != nil {
@@ -207,10 +244,15 @@ func parseMultipartFormStreaming(req *http.Request) (sender, merkleString, start
 				return "", "", "", "", nil, nil, fmt.Errorf("error reading type field: %w", err)
 			}
 		case "file":
-			file, fh, err = processFilePart(part)
+			stream := sender != "" && merkleString != "" && startBlockString != "" && (!typed || proofTypeString != "")
+			var streaming bool
+			file, fh, streaming, err = processFilePart(part, stream)
 			if err != nil {
 				return "", "", "", "", nil, nil, err
 			}
+			if streaming {
+				return sender, merkleString, startBlockString, proofTypeString, file, fh, nil
+			}
 		}
 		// nolint:errcheck
 		part.Close()
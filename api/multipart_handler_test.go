@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultipartFormStreaming(t *testing.T) {
+	r := require.New(t)
+
+	data := bytes.Repeat([]byte("sequoia!"), (MaxMemoryFileSize/8)+1024)
+
+	build := func(fileFirst bool) (*bytes.Buffer, string) {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		writeFile := func() {
+			fw, err := mw.CreateFormFile("file", "data.bin")
+			r.NoError(err)
+			_, err = fw.Write(data)
+			r.NoError(err)
+		}
+		if fileFirst {
+			writeFile()
+		}
+		r.NoError(mw.WriteField("sender", "owner"))
+		r.NoError(mw.WriteField("merkle", "abcd"))
+		r.NoError(mw.WriteField("start", "10"))
+		if !fileFirst {
+			writeFile()
+		}
+		r.NoError(mw.Close())
+		return body, mw.FormDataContentType()
+	}
+
+	// a large file after the fields is read straight from the request body
+	body, contentType := build(false)
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+
+	sender, merkle, start, proofType, file, _, err := parseMultipartFormStreaming(req, false)
+	r.NoError(err)
+	r.Equal("owner", sender)
+	r.Equal("abcd", merkle)
+	r.Equal("10", start)
+	r.Empty(proofType)
+	r.IsType(&partReader{}, file)
+
+	read, err := io.ReadAll(file)
+	r.NoError(err)
+	r.Equal(data, read)
+	r.NoError(file.Close())
+
+	// the proof type has to be read before streaming when the caller needs it
+	body, contentType = build(false)
+	req = httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+
+	_, _, _, _, file, _, err = parseMultipartFormStreaming(req, true)
+	r.NoError(err)
+	r.IsType(&tempFileReader{}, file)
+	r.NoError(file.Close())
+
+	// a file sent before the fields is spooled so the fields after it can be read
+	body, contentType = build(true)
+	req = httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+
+	sender, merkle, start, _, file, _, err = parseMultipartFormStreaming(req, false)
+	r.NoError(err)
+	r.Equal("owner", sender)
+	r.Equal("abcd", merkle)
+	r.Equal("10", start)
+	spooled, ok := file.(*tempFileReader)
+	r.True(ok)
+
+	read, err = io.ReadAll(file)
+	r.NoError(err)
+	r.Equal(data, read)
+	r.NoError(file.Close())
+
+	_, err = os.Stat(spooled.file.Name())
+	r.True(os.IsNotExist(err))
+}
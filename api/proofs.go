@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/JackalLabs/sequoia/api/types"
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/JackalLabs/sequoia/proofs"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+func proofContract(s *file_system.ProofStatus) types.ProofContract {
+	return types.ProofContract{
+		Merkle:      s.Merkle,
+		Owner:       s.Owner,
+		Start:       s.Start,
+		Window:      s.Window,
+		Attempts:    s.Attempts,
+		Failures:    s.Failures,
+		AtRisk:      s.AtRisk(),
+		LastAttempt: s.LastAttempt,
+		LastProven:  s.LastProven,
+	}
+}
+
+// ProofHistoryHandler lists the recorded proof attempts of a contract, newest first.
+func ProofHistoryHandler(f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		merkle, err := hex.DecodeString(vars["merkle"])
+		if err != nil {
+			handleErr(fmt.Errorf("cannot parse merkle: %w", err), w, http.StatusBadRequest)
+			return
+		}
+
+		start, err := strconv.ParseInt(vars["start"], 10, 64)
+		if err != nil {
+			handleErr(fmt.Errorf("cannot parse start block: %w", err), w, http.StatusBadRequest)
+			return
+		}
+
+		attempts, status, err := f.ProofHistory(merkle, vars["owner"], start)
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				handleErr(fmt.Errorf("no proof attempts for %x", merkle), w, http.StatusNotFound)
+				return
+			}
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+
+		resp := types.ProofHistoryResponse{
+			Status:   proofContract(status),
+			Attempts: attempts,
+		}
+
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+}
+
+// ProofSummaryHandler counts the contracts by the result of their last proof attempt and lists the
+// ones at risk of burning, the longest failing first.
+func ProofSummaryHandler(f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		statuses, err := f.ProofStatuses()
+		if err != nil {
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+
+		resp := types.ProofSummaryResponse{
+			Contracts: len(statuses),
+			Results:   make(map[string]int),
+			Files:     make([]types.ProofContract, 0),
+		}
+
+		for i := range statuses {
+			s := &statuses[i]
+			if s.LastAttempt != nil {
+				resp.Results[s.LastAttempt.Result]++
+			}
+			if s.Failures == 0 {
+				resp.Proven++
+				continue
+			}
+			resp.Failing++
+			if s.AtRisk() {
+				resp.AtRisk++
+				resp.Files = append(resp.Files, proofContract(s))
+			}
+		}
+
+		sort.SliceStable(resp.Files, func(i, j int) bool {
+			return resp.Files[i].Failures > resp.Files[j].Failures
+		})
+
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+}
+
+// auditing is set while an audit runs, each one reads every stored file so they are never run side by side.
+var auditing atomic.Bool
+
+// AuditResponse lists the outcome of an audit for the API.
+func AuditResponse(report *proofs.AuditReport) types.ProofAuditResponse {
+	resp := types.ProofAuditResponse{
+		Contracts: report.Contracts,
+		Passed:    report.Passed,
+		Skipped:   report.Skipped,
+		Failed:    make([]types.ProofAuditFailure, len(report.Failed)),
+	}
+	for i, failure := range report.Failed {
+		resp.Failed[i] = types.ProofAuditFailure{
+			Merkle:    hex.EncodeToString(failure.Merkle),
+			Owner:     failure.Owner,
+			Start:     failure.Start,
+			Chunk:     failure.Chunk,
+			ProofType: failure.ProofType,
+			Reason:    failure.Reason,
+			Error:     failure.Error,
+		}
+	}
+	return resp
+}
+
+// ProofAuditHandler proves every stored contract locally and reports the ones whose proof would
+// fail on chain. It reads every stored file, so it is only served by the admin API.
+func ProofAuditHandler(p *proofs.Prover) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !auditing.CompareAndSwap(false, true) {
+			handleErr(fmt.Errorf("an audit is already running"), w, http.StatusConflict)
+			return
+		}
+		defer auditing.Store(false)
+
+		// an audit takes as long as it takes, the server write timeout is meant for everything else
+		err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Warn().Err(err).Msg("cannot lift write deadline for audit")
+		}
+
+		report, err := p.Audit(req.Context())
+		if err != nil {
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(AuditResponse(report))
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+}
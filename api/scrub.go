@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/JackalLabs/sequoia/api/types"
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// ScrubHandler reports the last scrubbing pass and every contract it found damaged.
+func ScrubHandler(f *file_system.FileSystem) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		records, err := f.ListScrubRecords()
+		if err != nil {
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+
+		resp := types.ScrubResponse{
+			Files: make([]types.ScrubFile, len(records)),
+		}
+
+		status, err := f.GetScrubStatus()
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			handleErr(err, w, http.StatusInternalServerError)
+			return
+		}
+		if status != nil {
+			resp.LastStarted = status.Started
+			resp.LastFinished = status.Finished
+			resp.Checked = status.Checked
+			resp.Corrupt = status.Corrupt
+			resp.Missing = status.Missing
+		}
+
+		for i, r := range records {
+			resp.Files[i] = types.ScrubFile{
+				Merkle:    r.Merkle,
+				Owner:     r.Owner,
+				Start:     r.Start,
+				Status:    r.Status,
+				Reason:    r.Reason,
+				Repaired:  r.Repaired,
+				CheckedAt: r.CheckedAt,
+			}
+		}
+
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+}
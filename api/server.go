@@ -27,9 +27,10 @@ import (
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
 type API struct {
-	port int64
-	srv  *http.Server
-	cfg  *config.APIConfig
+	port     int64
+	srv      *http.Server
+	adminSrv *http.Server
+	cfg      *config.APIConfig
 }
 
 // NewAPI creates a new API instance using the provided API configuration.
@@ -44,9 +45,47 @@ func (a *API) Close() error {
 	if a.srv == nil {
 		return fmt.Errorf("no server available")
 	}
+	if a.adminSrv != nil {
+		_ = a.adminSrv.Close()
+	}
 	return a.srv.Close()
 }
 
+// adminServer serves the endpoints that hand out the provider's data or run expensive jobs on a
+// listener bound to localhost, so they are never reachable through the public API port. It is nil
+// when the admin API is turned off.
+func (a *API) adminServer(f *file_system.FileSystem, p *proofs.Prover) *http.Server {
+	port, ok := a.cfg.AdminListenPort()
+	if !ok {
+		return nil
+	}
+
+	r := mux.NewRouter()
+	outline := types.NewOutline()
+
+	outline.RegisterGetRoute(r, "/api/backup", BackupHandler(f))
+	outline.RegisterPostRoute(r, "/api/proofs/audit", ProofAuditHandler(p))
+	outline.RegisterGetRoute(r, "/api", outline.OutlineHandler())
+
+	r.Use(loggingMiddleware)
+
+	return &http.Server{
+		Handler:      r,
+		Addr:         fmt.Sprintf("127.0.0.1:%d", port),
+		WriteTimeout: 300 * time.Second,
+		ReadTimeout:  600 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+}
+
+func serveAdmin(srv *http.Server) {
+	log.Logger.Info().Msg(fmt.Sprintf("Sequoia admin API now listening on %s", srv.Addr))
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Warn().Err(err).Msg("admin API stopped")
+	}
+}
+
 func (a *API) Serve(f *file_system.FileSystem, p *proofs.Prover, wallet *wallet.Wallet, chunkSize int64, myIp string) {
 	defer log.Info().Msg("API module stopped")
 	r := mux.NewRouter()
@@ -69,7 +108,12 @@ func (a *API) Serve(f *file_system.FileSystem, p *proofs.Prover, wallet *wallet.
 	outline.RegisterGetRoute(r, "/list", ListFilesHandler(f))
 	outline.RegisterGetRoute(r, "/api/client/list", ListFilesHandler(f))
 	outline.RegisterGetRoute(r, "/api/data/fids", LegacyListFilesHandler(f))
-	outline.RegisterGetRoute(r, "/api/client/space", SpaceHandler(wallet.Client, wallet.AccAddress()))
+	outline.RegisterGetRoute(r, "/api/client/files", ListFileDetailsHandler(f))
+	outline.RegisterGetRoute(r, "/api/client/files/{merkle}/{owner}/{start}", FileDetailsHandler(f))
+	outline.RegisterGetRoute(r, "/api/client/space", SpaceHandler(wallet.Client, wallet.AccAddress(), f))
+	outline.RegisterGetRoute(r, "/api/scrub", ScrubHandler(f))
+	outline.RegisterGetRoute(r, "/api/proofs/summary", ProofSummaryHandler(f))
+	outline.RegisterGetRoute(r, "/api/proofs/{merkle}/{owner}/{start}", ProofHistoryHandler(f))
 
 	outline.RegisterGetRoute(r, "/ipfs/peers", IPFSListPeers(f))
 	outline.RegisterGetRoute(r, "/ipfs/hosts", IPFSListHosts(f))
@@ -89,6 +133,13 @@ func (a *API) Serve(f *file_system.FileSystem, p *proofs.Prover, wallet *wallet.
 
 	handler := cors.Default().Handler(r)
 
+	a.adminSrv = a.adminServer(f, p)
+	if a.adminSrv != nil {
+		go serveAdmin(a.adminSrv)
+	} else {
+		log.Info().Msg("Admin API is turned off")
+	}
+
 	a.srv = &http.Server{
 		Handler: handler,
 		Addr:    fmt.Sprintf("0.0.0.0:%d", a.port),
@@ -1,6 +1,9 @@
 package types
 
 import (
+	"time"
+
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
 	coretypes "github.com/tendermint/tendermint/rpc/core/types"
 )
 
@@ -35,9 +38,19 @@ type VersionResponse struct {
 }
 
 type SpaceResponse struct {
-	Total int64 `json:"total_space"`
-	Used  int64 `json:"used_space"`
-	Free  int64 `json:"free_space"`
+	Total int64              `json:"total_space"`
+	Used  int64              `json:"used_space"`
+	Free  int64              `json:"free_space"`
+	Local LocalSpaceResponse `json:"local"`
+}
+
+// LocalSpaceResponse is the space as this provider counts it, uploads are refused once it runs out.
+type LocalSpaceResponse struct {
+	Offered     int64 `json:"offered_space"`
+	Stored      int64 `json:"stored_space"`
+	Reserved    int64 `json:"reserved_space"`
+	DiskFree    int64 `json:"disk_free_space"`
+	DiskReserve int64 `json:"disk_reserve_space"`
 }
 
 type NetworkResponse struct {
@@ -84,3 +97,82 @@ type CidFolderResponse struct {
 type CidMapResponse struct {
 	CidMap map[string]string `json:"cid_map"`
 }
+
+type ScrubFile struct {
+	Merkle    string    `json:"merkle"`
+	Owner     string    `json:"owner"`
+	Start     int64     `json:"start"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	Repaired  bool      `json:"repaired"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type ScrubResponse struct {
+	LastStarted  time.Time   `json:"last_started"`
+	LastFinished time.Time   `json:"last_finished"`
+	Checked      int         `json:"checked"`
+	Corrupt      int         `json:"corrupt"`
+	Missing      int         `json:"missing"`
+	Files        []ScrubFile `json:"files"`
+}
+
+type ProofContract struct {
+	Merkle      string                     `json:"merkle"`
+	Owner       string                     `json:"owner"`
+	Start       int64                      `json:"start"`
+	Window      int64                      `json:"window"`
+	Attempts    int64                      `json:"attempts"`
+	Failures    int64                      `json:"failures"`
+	AtRisk      bool                       `json:"at_risk"`
+	LastAttempt *sequoiaTypes.ProofAttempt `json:"last_attempt"`
+	LastProven  *sequoiaTypes.ProofAttempt `json:"last_proven"`
+}
+
+type ProofHistoryResponse struct {
+	Status   ProofContract               `json:"status"`
+	Attempts []sequoiaTypes.ProofAttempt `json:"attempts"`
+}
+
+type ProofSummaryResponse struct {
+	Contracts int             `json:"contracts"`
+	Proven    int             `json:"proven"`
+	Failing   int             `json:"failing"`
+	AtRisk    int             `json:"at_risk"`
+	Results   map[string]int  `json:"results"`
+	Files     []ProofContract `json:"files"`
+}
+
+type ProofAuditFailure struct {
+	Merkle    string `json:"merkle"`
+	Owner     string `json:"owner"`
+	Start     int64  `json:"start"`
+	Chunk     int64  `json:"chunk"`
+	ProofType int64  `json:"proof_type"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ProofAuditResponse struct {
+	Contracts int                 `json:"contracts"`
+	Passed    int                 `json:"passed"`
+	Skipped   int                 `json:"skipped"`
+	Failed    []ProofAuditFailure `json:"failed"`
+}
+
+type FileDetails struct {
+	Merkle    string    `json:"merkle"`
+	Owner     string    `json:"owner"`
+	Start     int64     `json:"start"`
+	Size      int64     `json:"size"`
+	ProofType int64     `json:"proof_type"`
+	ChunkSize int64     `json:"chunk_size"`
+	CID       string    `json:"cid"`
+	Source    string    `json:"source"`
+	StoredAt  time.Time `json:"stored_at"`
+}
+
+type FileDetailsResponse struct {
+	Files []FileDetails `json:"files"`
+	Count int           `json:"count"`
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/JackalLabs/sequoia/api"
+	"github.com/JackalLabs/sequoia/api/types"
+	cmdTypes "github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/spf13/cobra"
+)
+
+func backupCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "backup [output]",
+		Short: "Back up the database and blockstore manifest of the provider",
+		Long: `Writes a consistent copy of the database and the list of blocks in the blockstore to one archive,
+which 'sequoia data restore' rebuilds a provider from. With --online the archive is streamed from the
+running provider through its API, otherwise the provider must be stopped. Blocks of a flatfs or s3
+blockstore are only included with --blocks, a badger blockstore keeps them in the database. Blocks of
+an encrypted blockstore are archived still encrypted, restoring them needs the same key. Writing to
+'-' streams the archive to stdout.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(cmdTypes.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			online, err := cmd.Flags().GetBool(cmdTypes.FlagOnline)
+			if err != nil {
+				return err
+			}
+
+			withBlocks, err := cmd.Flags().GetBool(cmdTypes.FlagBlocks)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Init(home)
+			if err != nil {
+				return err
+			}
+
+			if withBlocks && cfg.BlockStoreConfig.Type == config.OptBadgerDS {
+				_, _ = fmt.Fprintln(os.Stderr, "blocks are kept in the database with a badger blockstore, --blocks is ignored")
+				withBlocks = false
+			}
+
+			backup := backupOffline
+			if online {
+				backup = backupOnline
+			}
+
+			if args[0] == "-" {
+				return backup(cfg, os.Stdout, withBlocks)
+			}
+
+			out, err := os.Create(args[0])
+			if err != nil {
+				return err
+			}
+
+			err = backup(cfg, out, withBlocks)
+			if err != nil {
+				_ = out.Close()
+				_ = os.Remove(args[0])
+				return err
+			}
+
+			return out.Close()
+		},
+	}
+
+	c.Flags().Bool(cmdTypes.FlagOnline, false, "stream the backup from the running provider")
+	c.Flags().Bool(cmdTypes.FlagBlocks, false, "include the blocks of a flatfs or s3 blockstore")
+
+	return c
+}
+
+func backupOffline(cfg *config.Config, out io.Writer, withBlocks bool) error {
+	ctx := context.Background()
+
+	f, err := openFileSystemWithConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("%w, use --online while the provider is running", err)
+	}
+	defer f.Close()
+
+	res, err := f.Backup(ctx, out, withBlocks)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "backed up the database, %d block cids and %d blocks (%d bytes)\n", res.Cids, res.Blocks, res.Bytes)
+	return nil
+}
+
+// backupOnline streams the backup from the admin API of the running provider, which only listens on
+// localhost.
+func backupOnline(cfg *config.Config, out io.Writer, withBlocks bool) error {
+	port, ok := cfg.APICfg.AdminListenPort()
+	if !ok {
+		return fmt.Errorf("the admin API is turned off, set api_config.admin_port to take online backups")
+	}
+
+	res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/backup?blocks=%t", port, withBlocks))
+	if err != nil {
+		return fmt.Errorf("cannot reach the provider, is it running? | %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e types.ErrorResponse
+		err = json.NewDecoder(res.Body).Decode(&e)
+		if err != nil || e.Error == "" {
+			return fmt.Errorf("provider answered with %s", res.Status)
+		}
+		return fmt.Errorf("provider answered with %s: %s", res.Status, e.Error)
+	}
+
+	n, err := io.Copy(out, res.Body)
+	if err != nil {
+		return err
+	}
+
+	// trailers are only read once the body is
+	if msg := res.Trailer.Get(api.BackupErrorTrailer); msg != "" {
+		return fmt.Errorf("backup failed on the provider: %s", msg)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "backed up %d bytes from the provider\n", n)
+	return nil
+}
+
+func restoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore [archive]",
+		Short: "Rebuild a provider from a backup",
+		Long: `Loads a backup written by 'sequoia data backup' into the data directory of this home, which has to
+be empty, and writes the blocks the archive carries to the configured blockstore. Encrypted blocks
+are only restored into a blockstore encrypted with the key they were written with. Every stored file
+is then checked against the restored blockstore. Files missing blocks can be fetched again from the
+network by the scrubber with repair enabled. Reading '-' takes the archive from stdin.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(cmdTypes.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Init(home)
+			if err != nil {
+				return err
+			}
+
+			var in io.Reader = os.Stdin
+			if args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				in = file
+			}
+
+			ctx := context.Background()
+
+			db, ds, bs, err := openStores(ctx, cfg)
+			if err != nil {
+				return err
+			}
+
+			manifest, listed, err := file_system.Restore(ctx, in, db, bs)
+			if err != nil {
+				_ = db.Close()
+				return err
+			}
+			fmt.Printf("restored backup from %s at schema version %d\n", manifest.Created.Format("2006-01-02 15:04:05 MST"), manifest.SchemaVersion)
+
+			f, err := file_system.NewFileSystem(ctx, db, cfg.BlockStoreConfig.Key, ds, bs, cfg.APICfg.IPFSPort, cfg.APICfg.IPFSDomain)
+			if err != nil {
+				_ = db.Close()
+				return err
+			}
+			defer f.Close()
+
+			check, err := f.CheckRestore(ctx, listed)
+			if err != nil {
+				return err
+			}
+
+			for _, root := range check.Incomplete {
+				fmt.Printf("incomplete: %s\n", root)
+			}
+			fmt.Printf("%d of %d roots complete, %d of %d listed blocks missing\n",
+				check.Roots-len(check.Incomplete), check.Roots, check.MissingBlocks, check.Listed)
+
+			if manifest.SchemaVersion < file_system.LatestSchemaVersion() {
+				fmt.Println("the backup predates this version, the database is migrated on the next start")
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/ipfs"
+	"github.com/JackalLabs/sequoia/utils"
+	"github.com/spf13/cobra"
+)
+
+func migrateBlockstoreCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "migrate-blockstore",
+		Short: "Move every block to another blockstore backend",
+		Long: `Copies every block from the configured blockstore into another backend, verifies the copy and
+points block_store_config at the new backend. The provider must be stopped first. An interrupted
+migration can be resumed by running the same command again, blocks that were already copied are skipped.
+The old blocks are left in place and can be removed once the provider runs from the new backend.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			to, err := cmd.Flags().GetString(types.FlagTo)
+			if err != nil {
+				return err
+			}
+
+			dir, err := cmd.Flags().GetString(types.FlagDir)
+			if err != nil {
+				return err
+			}
+
+			sample, err := cmd.Flags().GetInt(types.FlagSample)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Init(home)
+			if err != nil {
+				return err
+			}
+
+			from := cfg.BlockStoreConfig
+			if from.Cold != nil {
+				return fmt.Errorf("tiered blockstores cannot be migrated, remove the cold tier first")
+			}
+			if from.Type == to {
+				return fmt.Errorf("blockstore is already %s", to)
+			}
+
+			switch to {
+			case config.OptBadgerDS:
+				// badgerds blocks live in the data directory, see config.Validate
+				dir = cfg.DataDirectory
+			case config.OptFlatFS:
+				if dir == "" {
+					dir = path.Join(home, "blockstore")
+				}
+			default:
+				return fmt.Errorf("cannot migrate to '%s', use %s or %s", to, config.OptFlatFS, config.OptBadgerDS)
+			}
+
+			dataDir := os.ExpandEnv(cfg.DataDirectory)
+
+			db, err := utils.OpenBadger(dataDir)
+			if err != nil {
+				return err
+			}
+			//nolint:errcheck
+			defer db.Close()
+
+			ds, err := ipfs.NewBadgerDataStore(db)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+
+			src, err := ipfs.OpenBlockStore(ctx, from, ds)
+			if err != nil {
+				return err
+			}
+
+			dst, err := ipfs.OpenBlockStore(ctx, config.BlockStoreConfig{
+				Directory:         dir,
+				Type:              to,
+				EncryptionKeyFile: from.EncryptionKeyFile,
+				Cipher:            from.Cipher,
+			}, ds)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("migrating blocks from %s (%s) to %s (%s)\n", from.Type, from.Directory, to, dir)
+
+			res, err := ipfs.MigrateBlocks(ctx, src, dst, sample)
+			if err != nil {
+				return fmt.Errorf("migration stopped, run the command again to resume | %w", err)
+			}
+
+			fmt.Printf("%d blocks in source, %d copied, %d already present, %d verified by hash\n", res.Total, res.Copied, res.Skipped, res.Verified)
+
+			err = config.WriteBlockStoreConfig(home, config.BlockStoreConfig{
+				Directory:         dir,
+				Type:              to,
+				Key:               from.Key,
+				EncryptionKeyFile: from.EncryptionKeyFile,
+				Cipher:            from.Cipher,
+			})
+			if err != nil {
+				return fmt.Errorf("blocks were migrated but the config could not be updated | %w", err)
+			}
+
+			fmt.Printf("block_store_config now uses %s, the old blocks in %s were left in place\n", to, from.Directory)
+
+			return nil
+		},
+	}
+
+	c.Flags().String(types.FlagTo, "", fmt.Sprintf("backend to migrate to (%s|%s)", config.OptFlatFS, config.OptBadgerDS))
+	c.Flags().String(types.FlagDir, "", "directory of the new flatfs blockstore, defaults to <home>/blockstore")
+	c.Flags().Int(types.FlagSample, 1000, "number of copied blocks to read back and verify by hash")
+	_ = c.MarkFlagRequired(types.FlagTo)
+
+	return c
+}
+
+func rebalanceCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "rebalance",
+		Short: "Move files between the disks of a multi-disk blockstore",
+		Long: `Moves files off draining disks and from fuller disks to emptier ones, weighing free space the
+same way new files are placed. Disks passed with --drain are emptied even if they are still listed in
+block_store_config, directories that are not listed there are opened only to move their files away.
+The provider must be stopped first.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			dryRun, err := cmd.Flags().GetBool(types.FlagDryRun)
+			if err != nil {
+				return err
+			}
+
+			drain, err := cmd.Flags().GetStringSlice(types.FlagDrain)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Init(home)
+			if err != nil {
+				return err
+			}
+			if len(cfg.BlockStoreConfig.Disks) == 0 {
+				return fmt.Errorf("block_store_config has no disks to rebalance")
+			}
+
+			for _, dir := range drain {
+				found := false
+				for i, d := range cfg.BlockStoreConfig.Disks {
+					if d.Directory == dir {
+						cfg.BlockStoreConfig.Disks[i].Drain = true
+						found = true
+					}
+				}
+				if !found {
+					cfg.BlockStoreConfig.Disks = append(cfg.BlockStoreConfig.Disks, config.DiskConfig{Directory: dir, Drain: true})
+				}
+			}
+
+			ctx := context.Background()
+
+			f, err := openFileSystemWithConfig(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			res, err := f.Rebalance(ctx, dryRun)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				fmt.Printf("%d files (%d bytes) would be moved, %d files without a recorded disk\n", res.Moved, res.Bytes, res.Located)
+			} else {
+				fmt.Printf("moved %d files (%d bytes, %d blocks), recorded the disk of %d files\n", res.Moved, res.Bytes, res.Blocks, res.Located)
+			}
+
+			return nil
+		},
+	}
+
+	c.Flags().Bool(types.FlagDryRun, false, "only count the files that would be moved")
+	c.Flags().StringSlice(types.FlagDrain, nil, "disk directory to move every file off")
+
+	return c
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/JackalLabs/sequoia/utils"
+	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/spf13/cobra"
+)
+
+func exportCarCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export-car [merkle] [output]",
+		Short: "Export the IPFS DAG of a stored file as a CAR archive",
+		Long:  "Export the IPFS DAG of a stored file as a CAR archive. Writing to '-' streams a CARv1 archive to stdout.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			v1, err := cmd.Flags().GetBool(types.FlagCarV1)
+			if err != nil {
+				return err
+			}
+
+			merkle, err := hex.DecodeString(args[0])
+			if err != nil {
+				return fmt.Errorf("cannot parse merkle: %w", err)
+			}
+
+			ctx := context.Background()
+
+			f, err := OpenFileSystem(ctx, home)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if args[1] == "-" {
+				return f.ExportCAR(ctx, merkle, os.Stdout, true)
+			}
+
+			out, err := os.Create(args[1])
+			if err != nil {
+				return err
+			}
+
+			err = f.ExportCAR(ctx, merkle, out, v1)
+			if err != nil {
+				_ = out.Close()
+				return err
+			}
+
+			return out.Close()
+		},
+	}
+
+	c.Flags().Bool(types.FlagCarV1, false, "write a CARv1 archive instead of CARv2")
+
+	return c
+}
+
+func importCarCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-car [car-file] [merkle] [owner] [start]",
+		Short: "Import a file for a contract from a CAR archive",
+		Long:  "Import a file for a contract from a CARv1 or CARv2 archive. The contract is looked up on chain and the merkle root is rebuilt from the archive before anything is recorded. Reading '-' takes the archive from stdin.",
+		Args:  cobra.ExactArgs(4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			merkle, err := hex.DecodeString(args[1])
+			if err != nil {
+				return fmt.Errorf("cannot parse merkle: %w", err)
+			}
+			owner := args[2]
+			start, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil {
+				return fmt.Errorf("cannot parse start block: %w", err)
+			}
+
+			ctx := context.Background()
+
+			w, err := config.InitWallet(home)
+			if err != nil {
+				return err
+			}
+
+			cl := storageTypes.NewQueryClient(w.Client.GRPCConn)
+			res, err := cl.File(ctx, &storageTypes.QueryFile{
+				Merkle: merkle,
+				Owner:  owner,
+				Start:  start,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to find file on chain with merkle: %x, owner: %s, start: %d | %w", merkle, owner, start, err)
+			}
+			file := res.File
+
+			params, err := cl.Params(ctx, &storageTypes.QueryParams{})
+			if err != nil {
+				return err
+			}
+
+			var in io.Reader = os.Stdin
+			if args[0] != "-" {
+				car, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				//nolint:errcheck
+				defer car.Close()
+				in = car
+			}
+
+			f, err := OpenFileSystem(ctx, home)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			size, c, err := f.ImportCAR(ctx, in, merkle, owner, start, params.Params.ChunkSize, file.ProofType, utils.GetIPFSParams(&file), file_system.SourceImport)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("imported %x as %s (%d bytes)\n", merkle, c, size)
+
+			return nil
+		},
+	}
+}
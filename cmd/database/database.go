@@ -13,6 +13,7 @@ import (
 	"github.com/JackalLabs/sequoia/utils"
 	"github.com/dgraph-io/badger/v4"
 	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -23,7 +24,7 @@ func DataCmd() *cobra.Command {
 		Short: "Data subcommands",
 	}
 
-	c.AddCommand(keysCmd(), getObjectCmd(), garbageCmd(), unusedCidsCmd())
+	c.AddCommand(keysCmd(), getObjectCmd(), garbageCmd(), unusedCidsCmd(), gcCmd(), exportCarCmd(), importCarCmd(), migrateBlockstoreCmd(), rebalanceCmd(), migrateCmd(), backupCmd(), restoreCmd(), rebuildIndexCmd())
 
 	return c
 }
@@ -174,65 +175,134 @@ func unusedCidsCmd() *cobra.Command {
 				return err
 			}
 
-			cfg, err := config.Init(home)
+			ctx := context.Background()
+
+			f, err := OpenFileSystem(ctx, home)
 			if err != nil {
 				return err
 			}
+			defer f.Close()
 
-			ctx := context.Background()
-
-			dataDir := os.ExpandEnv(cfg.DataDirectory)
-
-			err = os.MkdirAll(dataDir, os.ModePerm)
+			unusedCids, err := f.ListUnusedCids(ctx)
 			if err != nil {
 				return err
 			}
 
-			db, err := utils.OpenBadger(dataDir)
+			for _, cid := range unusedCids {
+				fmt.Println(cid)
+			}
+
+			return nil
+		},
+	}
+}
+
+func gcCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove blocks that are not used by any stored file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
 			if err != nil {
 				return err
 			}
 
-			ds, err := ipfs.NewBadgerDataStore(db)
+			dryRun, err := cmd.Flags().GetBool(types.FlagDryRun)
 			if err != nil {
 				return err
 			}
-			log.Info().Msg("Data store initialized")
 
-			bsDir := os.ExpandEnv(cfg.BlockStoreConfig.Directory)
-			var bs blockstore.Blockstore
-			bs = nil
-			switch cfg.BlockStoreConfig.Type {
-			case config.OptBadgerDS:
-			case config.OptFlatFS:
-				bs, err = ipfs.NewFlatfsBlockStore(bsDir)
-				if err != nil {
-					return err
-				}
-			}
-			log.Info().Msg("Blockstore initialized")
+			ctx := context.Background()
 
-			w, err := config.InitWallet(home)
+			f, err := OpenFileSystem(ctx, home)
 			if err != nil {
 				return err
 			}
-			log.Info().Str("provider_address", w.AccAddress()).Send()
+			defer f.Close()
 
-			f, err := file_system.NewFileSystem(ctx, db, cfg.BlockStoreConfig.Key, ds, bs, cfg.APICfg.IPFSPort, cfg.APICfg.IPFSDomain)
+			res, err := f.CollectGarbage(ctx, dryRun)
 			if err != nil {
 				return err
 			}
 
-			unusedCids, err := f.ListUnusedCids(context.Background())
-			if err != nil {
-				return err
+			for _, c := range res.Unused {
+				fmt.Println(c)
 			}
 
-			for _, cid := range unusedCids {
-				fmt.Println(cid)
+			if dryRun {
+				fmt.Printf("%d of %d blocks unused, %d bytes would be freed\n", len(res.Unused), res.Total, res.Bytes)
+			} else {
+				fmt.Printf("removed %d of %d blocks, %d bytes freed\n", res.Removed, res.Total, res.Bytes)
 			}
 
 			return nil
 		},
 	}
+
+	c.Flags().Bool(types.FlagDryRun, false, "only list the blocks that would be removed")
+
+	return c
+}
+
+// OpenFileSystem opens the data directory and blockstore of a node the same way the provider does.
+func OpenFileSystem(ctx context.Context, home string) (*file_system.FileSystem, error) {
+	cfg, err := config.Init(home)
+	if err != nil {
+		return nil, err
+	}
+
+	return openFileSystemWithConfig(ctx, cfg)
+}
+
+// openFileSystemWithConfig opens the file system of cfg, refusing databases with migrations pending.
+func openFileSystemWithConfig(ctx context.Context, cfg *config.Config) (*file_system.FileSystem, error) {
+	f, err := openAnySchema(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.CheckSchema()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w, run `sequoia data migrate` first", err)
+	}
+	return f, nil
+}
+
+func openAnySchema(ctx context.Context, cfg *config.Config) (*file_system.FileSystem, error) {
+	db, ds, bs, err := openStores(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return file_system.NewFileSystem(ctx, db, cfg.BlockStoreConfig.Key, ds, bs, cfg.APICfg.IPFSPort, cfg.APICfg.IPFSDomain)
+}
+
+// openStores opens the database and blockstore of cfg without building a file system on them.
+func openStores(ctx context.Context, cfg *config.Config) (*badger.DB, datastore.Batching, blockstore.Blockstore, error) {
+	dataDir := os.ExpandEnv(cfg.DataDirectory)
+
+	err := os.MkdirAll(dataDir, os.ModePerm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	db, err := utils.OpenBadger(dataDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	log.Info().Msg("Data store initialized")
+
+	bs, err := ipfs.OpenBlockStore(ctx, cfg.BlockStoreConfig, ds)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	log.Info().Msg("Blockstore initialized")
+
+	return db, ds, bs, nil
 }
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/spf13/cobra"
+)
+
+func migrateCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the database to the schema of this version",
+		Long: `Runs the pending database migrations in order. Migrations can't be undone, back up the data
+directory first. The provider must be stopped. With --status nothing is changed and the current and
+pending schema versions are listed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			status, err := cmd.Flags().GetBool(types.FlagStatus)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Init(home)
+			if err != nil {
+				return err
+			}
+
+			f, err := openAnySchema(context.Background(), cfg)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			version, err := f.SchemaVersion()
+			if err != nil {
+				return err
+			}
+			pending, err := f.PendingMigrations()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("schema version %d, latest %d\n", version, file_system.LatestSchemaVersion())
+			for _, m := range pending {
+				fmt.Printf("  pending %d: %s\n", m.Version, m.Name)
+			}
+
+			if status || len(pending) == 0 {
+				return nil
+			}
+
+			err = f.Migrate()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("migrated to schema version %d\n", file_system.LatestSchemaVersion())
+			return nil
+		},
+	}
+
+	c.Flags().Bool(types.FlagStatus, false, "only show the current and pending schema versions")
+
+	return c
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/JackalLabs/sequoia/utils"
+	"github.com/cosmos/cosmos-sdk/types/query"
+	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/spf13/cobra"
+)
+
+// proverContracts lists every contract on chain that address has to prove, with the proof type and
+// IPFS params of its file. Contracts whose file cannot be read from the chain, such as ones removed
+// while listing, are returned as failures.
+func proverContracts(ctx context.Context, cl storageTypes.QueryClient, address string) ([]file_system.RebuildContract, []file_system.RebuildFailure, error) {
+	contracts := make([]file_system.RebuildContract, 0)
+	failures := make([]file_system.RebuildFailure, 0)
+
+	var key []byte
+	for {
+		res, err := cl.ProofsByAddress(ctx, &storageTypes.QueryProofsByAddress{
+			ProviderAddress: address,
+			Pagination:      &query.PageRequest{Key: key, Limit: 500},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot list contracts of %s | %w", address, err)
+		}
+
+		for _, p := range res.Proofs {
+			fileRes, err := cl.File(ctx, &storageTypes.QueryFile{
+				Merkle: p.Merkle,
+				Owner:  p.Owner,
+				Start:  p.Start,
+			})
+			if err != nil {
+				failures = append(failures, file_system.RebuildFailure{
+					Contract: file_system.RebuildContract{
+						Merkle: p.Merkle,
+						Owner:  p.Owner,
+						Start:  p.Start,
+					},
+					Reason: fmt.Sprintf("cannot find file on chain: %s", err),
+				})
+				continue
+			}
+			file := fileRes.File
+
+			contracts = append(contracts, file_system.RebuildContract{
+				Merkle:     file.Merkle,
+				Owner:      file.Owner,
+				Start:      file.Start,
+				ProofType:  file.ProofType,
+				IPFSParams: utils.GetIPFSParams(&file),
+			})
+		}
+
+		if res.Pagination == nil || len(res.Pagination.NextKey) == 0 {
+			return contracts, failures, nil
+		}
+		key = res.Pagination.NextKey
+	}
+}
+
+func rebuildIndexCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rebuild-index",
+		Short: "Recover tree and cid records from the blockstore and the chain",
+		Long: `Recovers the records of every contract this provider proves on chain from the files left in the
+blockstore, for when the database was lost but the blocks were not. Each file is found by hashing
+the files in the blockstore and added again with the IPFS params from its note, which rebuilds its
+tree, cid and metadata. Contracts that still have a tree are left alone and the contracts that
+could not be recovered are listed. The provider must be stopped.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(types.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+
+			w, err := config.InitWallet(home)
+			if err != nil {
+				return err
+			}
+
+			cl := storageTypes.NewQueryClient(w.Client.GRPCConn)
+			params, err := cl.Params(ctx, &storageTypes.QueryParams{})
+			if err != nil {
+				return err
+			}
+
+			contracts, failures, err := proverContracts(ctx, cl, w.AccAddress())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("found %d contracts on chain\n", len(contracts)+len(failures))
+
+			f, err := OpenFileSystem(ctx, home)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			res, err := f.RebuildIndex(ctx, contracts, params.Params.ChunkSize)
+			if err != nil {
+				return err
+			}
+			res.Contracts += len(failures)
+			res.Failed = append(failures, res.Failed...)
+
+			for _, failure := range res.Failed {
+				c := failure.Contract
+				fmt.Printf("not recovered: %x %s %d: %s\n", c.Merkle, c.Owner, c.Start, failure.Reason)
+			}
+			fmt.Printf("recovered %d of %d contracts, %d already indexed, %d not recovered\n",
+				res.Recovered, res.Contracts, res.Indexed, len(res.Failed))
+			if res.Relaid > 0 {
+				fmt.Printf("%d files were added again with the layout from their note, run `sequoia data gc` to remove the old blocks\n", res.Relaid)
+			}
+
+			return nil
+		},
+	}
+}
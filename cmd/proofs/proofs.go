@@ -0,0 +1,143 @@
+package proofs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/JackalLabs/sequoia/api"
+	"github.com/JackalLabs/sequoia/api/types"
+	"github.com/JackalLabs/sequoia/cmd/database"
+	cmdTypes "github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/proofs"
+	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/spf13/cobra"
+)
+
+func ProofsCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "proofs",
+		Short: "Proof subcommands",
+	}
+
+	c.AddCommand(auditCmd(), verifyCmd())
+
+	return c
+}
+
+func auditCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "audit",
+		Short: "Check that every stored contract can be proven without posting anything",
+		Long: `Builds the proof every stored contract is due for at the chunk the chain currently asks for and
+verifies it against the merkle root on chain, without broadcasting anything. Contracts whose proof
+would fail are listed with the reason: a missing tree or cid, a chunk that cannot be read or does
+not hash into the stored tree, or a stored tree whose root is not the one on chain. With --online
+the audit is run by the running provider through its API, otherwise the provider must be stopped.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := cmd.Flags().GetString(cmdTypes.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			online, err := cmd.Flags().GetBool(cmdTypes.FlagOnline)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Init(home)
+			if err != nil {
+				return err
+			}
+
+			var report *types.ProofAuditResponse
+			if online {
+				report, err = auditOnline(cfg)
+			} else {
+				report, err = auditOffline(home)
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, failure := range report.Failed {
+				if failure.Error == "" {
+					fmt.Printf("%s %s %d chunk %d: %s\n", failure.Merkle, failure.Owner, failure.Start, failure.Chunk, failure.Reason)
+					continue
+				}
+				fmt.Printf("%s %s %d chunk %d: %s: %s\n", failure.Merkle, failure.Owner, failure.Start, failure.Chunk, failure.Reason, failure.Error)
+			}
+			fmt.Printf("%d of %d contracts would fail their next proof, %d passed, %d skipped\n",
+				len(report.Failed), report.Contracts, report.Passed, report.Skipped)
+
+			return nil
+		},
+	}
+
+	c.Flags().Bool(cmdTypes.FlagOnline, false, "run the audit on the running provider")
+
+	return c
+}
+
+func auditOffline(home string) (*types.ProofAuditResponse, error) {
+	ctx := context.Background()
+
+	w, err := config.InitWallet(home)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := storageTypes.NewQueryClient(w.Client.GRPCConn)
+	params, err := cl.Params(ctx, &storageTypes.QueryParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := database.OpenFileSystem(ctx, home)
+	if err != nil {
+		return nil, fmt.Errorf("%w, use --online while the provider is running", err)
+	}
+	defer f.Close()
+
+	report, err := proofs.Audit(ctx, cl, f, w.AccAddress(), int(params.Params.ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := api.AuditResponse(report)
+	return &resp, nil
+}
+
+// auditOnline has the running provider audit itself through its admin API, which only listens on
+// localhost.
+func auditOnline(cfg *config.Config) (*types.ProofAuditResponse, error) {
+	port, ok := cfg.APICfg.AdminListenPort()
+	if !ok {
+		return nil, fmt.Errorf("the admin API is turned off, set api_config.admin_port to audit online")
+	}
+
+	res, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/api/proofs/audit", port), "application/json", bytes.NewReader(nil))
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach the provider, is it running? | %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e types.ErrorResponse
+		err = json.NewDecoder(res.Body).Decode(&e)
+		if err != nil || e.Error == "" {
+			return nil, fmt.Errorf("provider answered with %s", res.Status)
+		}
+		return nil, fmt.Errorf("provider answered with %s: %s", res.Status, e.Error)
+	}
+
+	var report types.ProofAuditResponse
+	err = json.NewDecoder(res.Body).Decode(&report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
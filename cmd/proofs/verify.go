@@ -0,0 +1,195 @@
+package proofs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	cmdTypes "github.com/JackalLabs/sequoia/cmd/types"
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/JackalLabs/sequoia/proofs"
+	canine "github.com/jackalLabs/canine-chain/v5/app"
+	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/spf13/cobra"
+)
+
+func verifyCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "verify [merkle] [chunk] [item-file] [hash-list-file]",
+		Short: "Check a proof payload offline and explain which step fails",
+		Long: `Checks the payload of a MsgPostProof against the merkle root of its contract the way the chain does
+and prints every step: parsing the JSON hash list made by GenProof, the sizes of the root and its
+sibling hashes, the chunk index the hash list was made for, the leaf hash of the chunk and the root
+the hash list leads to. The item and hash list are read from files, '-' reads the item from stdin.
+With --tx the proofs posted in a transaction are checked instead, --merkle picks one of them.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			tx, err := cmd.Flags().GetString(cmdTypes.FlagTx)
+			if err != nil {
+				return err
+			}
+			if tx != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(4)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			proofType, err := cmd.Flags().GetInt64(cmdTypes.FlagProofType)
+			if err != nil {
+				return err
+			}
+
+			tx, err := cmd.Flags().GetString(cmdTypes.FlagTx)
+			if err != nil {
+				return err
+			}
+
+			if tx == "" {
+				merkle, err := hex.DecodeString(args[0])
+				if err != nil {
+					return fmt.Errorf("cannot parse merkle: %w", err)
+				}
+
+				index, err := strconv.ParseInt(args[1], 10, 64)
+				if err != nil {
+					return fmt.Errorf("cannot parse chunk: %w", err)
+				}
+
+				item, err := readInput(args[2])
+				if err != nil {
+					return err
+				}
+
+				hashList, err := os.ReadFile(args[3])
+				if err != nil {
+					return err
+				}
+
+				printCheck(proofs.CheckProof(merkle, index, item, hashList, proofType))
+				return nil
+			}
+
+			home, err := cmd.Flags().GetString(cmdTypes.FlagHome)
+			if err != nil {
+				return err
+			}
+
+			m, err := cmd.Flags().GetString(cmdTypes.FlagMerkle)
+			if err != nil {
+				return err
+			}
+
+			var merkle []byte
+			if m != "" {
+				merkle, err = hex.DecodeString(m)
+				if err != nil {
+					return fmt.Errorf("cannot parse merkle: %w", err)
+				}
+			}
+
+			return verifyTx(cmd, home, tx, merkle, proofType)
+		},
+	}
+
+	c.Flags().Int64(cmdTypes.FlagProofType, 0, "proof type of the file, looked up on chain for --tx")
+	c.Flags().String(cmdTypes.FlagTx, "", "hash of a transaction to check the posted proofs of")
+	c.Flags().String(cmdTypes.FlagMerkle, "", "only check the proof for this merkle with --tx")
+
+	return c
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// verifyTx checks every proof posted in a transaction, looking up the proof type of each file on
+// chain unless one was given.
+func verifyTx(cmd *cobra.Command, home string, txHash string, merkle []byte, proofType int64) error {
+	hash, err := hex.DecodeString(txHash)
+	if err != nil {
+		return fmt.Errorf("cannot parse tx hash: %w", err)
+	}
+
+	_, err = config.Init(home)
+	if err != nil {
+		return err
+	}
+
+	w, err := config.InitWallet(home)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	res, err := w.Client.RPCClient.Tx(ctx, hash, false)
+	if err != nil {
+		return fmt.Errorf("cannot find tx %s | %w", txHash, err)
+	}
+
+	tx, err := canine.MakeEncodingConfig().TxConfig.TxDecoder()(res.Tx)
+	if err != nil {
+		return fmt.Errorf("cannot decode tx %s | %w", txHash, err)
+	}
+
+	cl := storageTypes.NewQueryClient(w.Client.GRPCConn)
+	lookup := !cmd.Flags().Changed(cmdTypes.FlagProofType)
+
+	checked := 0
+	for _, msg := range tx.GetMsgs() {
+		post, ok := msg.(*storageTypes.MsgPostProof)
+		if !ok {
+			continue
+		}
+		if merkle != nil && !bytes.Equal(post.Merkle, merkle) {
+			continue
+		}
+
+		pt := proofType
+		if lookup {
+			fileRes, err := cl.File(ctx, &storageTypes.QueryFile{Merkle: post.Merkle, Owner: post.Owner, Start: post.Start})
+			if err != nil {
+				fmt.Printf("cannot find the file on chain, assuming proof type %d: %s\n", pt, err)
+			} else {
+				pt = fileRes.File.ProofType
+			}
+		}
+
+		if checked > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("proof of %x %s %d chunk %d, proof type %d\n", post.Merkle, post.Owner, post.Start, post.ToProve, pt)
+		printCheck(proofs.CheckProof(post.Merkle, post.ToProve, post.Item, post.HashList, pt))
+		checked++
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("tx %s posts no matching proofs", txHash)
+	}
+	return nil
+}
+
+func printCheck(check *proofs.ProofCheck) {
+	for _, step := range check.Steps {
+		status := "ok  "
+		if !step.OK {
+			status = "FAIL"
+		}
+		fmt.Printf("%s %s: %s\n", status, step.Name, step.Detail)
+	}
+	for _, hint := range check.Hints {
+		fmt.Printf("hint: %s\n", hint)
+	}
+
+	if check.Valid {
+		fmt.Println("the proof is valid")
+	} else {
+		fmt.Println("the proof does not verify")
+	}
+}
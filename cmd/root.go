@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/JackalLabs/sequoia/cmd/database"
+	"github.com/JackalLabs/sequoia/cmd/proofs"
 
 	walletTypes "github.com/desmos-labs/cosmos-go-wallet/types"
 
@@ -175,7 +176,7 @@ func RootCmd() *cobra.Command {
 		panic(err)
 	}
 
-	r.AddCommand(StartCmd(), wallet.WalletCmd(), InitCmd(), VersionCmd(), IPFSCmd(), ShutdownCmd(), database.DataCmd())
+	r.AddCommand(StartCmd(), wallet.WalletCmd(), InitCmd(), VersionCmd(), IPFSCmd(), ShutdownCmd(), database.DataCmd(), proofs.ProofsCmd())
 
 	return r
 }
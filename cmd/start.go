@@ -46,7 +46,12 @@ func StartCmd() *cobra.Command {
 				log.Logger = log.Logger.Level(zerolog.ErrorLevel)
 			}
 
-			app, err := core.NewApp(home)
+			migrate, err := cmd.Flags().GetBool(types.FlagMigrate)
+			if err != nil {
+				return err
+			}
+
+			app, err := core.NewApp(home, migrate)
 			if err != nil {
 				return err
 			}
@@ -62,5 +67,7 @@ func StartCmd() *cobra.Command {
 		},
 	}
 
+	cmd.Flags().Bool(types.FlagMigrate, false, "run pending database migrations without asking for a backup first")
+
 	return cmd
 }
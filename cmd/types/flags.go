@@ -1,8 +1,21 @@
 package types
 
 const (
-	FlagHome     = "home"
-	FlagLogLevel = "log-level"
+	FlagHome      = "home"
+	FlagLogLevel  = "log-level"
+	FlagDryRun    = "dry-run"
+	FlagCarV1     = "v1"
+	FlagTo        = "to"
+	FlagDir       = "dir"
+	FlagSample    = "sample"
+	FlagDrain     = "drain"
+	FlagMigrate   = "migrate"
+	FlagStatus    = "status"
+	FlagOnline    = "online"
+	FlagBlocks    = "blocks"
+	FlagTx        = "tx"
+	FlagMerkle    = "merkle"
+	FlagProofType = "proof-type"
 
 	DefaultHome     = "$HOME/.sequoia"
 	DefaultLogLevel = "info"
@@ -30,12 +30,72 @@ func (c Config) Validate() error {
 	case OptFlatFS:
 	case OptBadgerDS:
 		if c.BlockStoreConfig.Directory != c.DataDirectory {
-			return errors.New("badger ds directory must be the same as data directory")
+			return errors.New("badger ds directory must be the same as data directory, use `sequoia data migrate-blockstore` to move blocks to another backend")
+		}
+	case OptS3:
+		if c.BlockStoreConfig.S3.Endpoint == "" {
+			return errors.New("s3 blockstore needs an endpoint")
+		}
+		if c.BlockStoreConfig.S3.Bucket == "" {
+			return errors.New("s3 blockstore needs a bucket")
 		}
 	default:
 		return errors.New("invalid data store backend")
 	}
 
+	if len(c.BlockStoreConfig.Disks) > 0 {
+		if c.BlockStoreConfig.Type != OptFlatFS {
+			return errors.New("multiple disks are only supported by the flatfs backend")
+		}
+		seen := make(map[string]bool)
+		writable := false
+		for _, d := range c.BlockStoreConfig.Disks {
+			if d.Directory == "" {
+				return errors.New("disk directory cannot be empty")
+			}
+			if seen[d.Directory] {
+				return errors.New("disk directories must be unique")
+			}
+			seen[d.Directory] = true
+			writable = writable || !d.Drain
+		}
+		if !writable {
+			return errors.New("at least one disk must not be draining")
+		}
+	}
+
+	if cold := c.BlockStoreConfig.Cold; cold != nil {
+		switch cold.Type {
+		case OptFlatFS:
+			if cold.Directory == "" || cold.Directory == c.BlockStoreConfig.Directory {
+				return errors.New("cold tier needs its own directory")
+			}
+		case OptS3:
+			if cold.S3.Endpoint == "" || cold.S3.Bucket == "" {
+				return errors.New("s3 cold tier needs an endpoint and a bucket")
+			}
+		default:
+			return errors.New("invalid cold tier backend, use flatfs or s3")
+		}
+	}
+
+	if port, ok := c.APICfg.AdminListenPort(); ok && port == c.APICfg.Port {
+		return errors.New("admin port must differ from the api port")
+	}
+
+	if r := c.MaintenanceCfg.DiscardRatio; r < 0 || r >= 1 {
+		return errors.New("maintenance discard ratio must be at least 0 and below 1")
+	}
+
+	switch c.BlockStoreConfig.Cipher {
+	case "", CipherAESGCM, CipherXChaCha20Poly1305:
+	default:
+		return errors.New("invalid blockstore cipher, use aes-gcm or xchacha20-poly1305")
+	}
+	if c.BlockStoreConfig.Cipher != "" && c.BlockStoreConfig.EncryptionKeyFile == "" {
+		return errors.New("blockstore cipher is set without an encryption key file")
+	}
+
 	return nil
 }
 
@@ -7,6 +7,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v3"
 )
 
 const (
@@ -105,3 +106,56 @@ func Init(home string) (*Config, error) {
 
 	return &config, nil
 }
+
+// WriteBlockStoreConfig replaces block_store_config in the config file of home. The rest of the
+// file, comments included, is left as it was.
+func WriteBlockStoreConfig(home string, bs BlockStoreConfig) error {
+	directory := os.ExpandEnv(home)
+
+	data, err := readFile(directory, ConfigFileName)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return errors.New("config file is not a yaml mapping")
+	}
+
+	var value yaml.Node
+	err = value.Encode(bs)
+	if err != nil {
+		return err
+	}
+
+	root := doc.Content[0]
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "block_store_config" {
+			root.Content[i+1] = &value
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "block_store_config"}, &value)
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+
+	// write next to the config and rename so an interruption can't leave a partial file
+	filePath := path.Join(directory, ConfigFileName)
+	err = os.WriteFile(filePath+".tmp", out, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(filePath+".tmp", filePath)
+}
@@ -23,6 +23,7 @@ type Config struct {
 	QueueInterval    uint64             `yaml:"queue_interval" mapstructure:"queue_interval"`
 	MaxSizeBytes     int64              `yaml:"max_size_bytes" mapstructure:"max_size_bytes"`
 	ProofInterval    uint64             `yaml:"proof_interval" mapstructure:"proof_interval"`
+	ProofRescan      uint64             `yaml:"proof_rescan_interval" mapstructure:"proof_rescan_interval"`
 	StrayManagerCfg  StrayManagerConfig `yaml:"stray_manager" mapstructure:"stray_manager"`
 	ChainCfg         ChainConfig        `yaml:"chain_config" mapstructure:"chain_config"`
 	Ip               string             `yaml:"domain" mapstructure:"domain"`
@@ -32,6 +33,12 @@ type Config struct {
 	ProofThreads     int16              `yaml:"proof_threads" mapstructure:"proof_threads"`
 	BlockStoreConfig BlockStoreConfig   `yaml:"block_store_config" mapstructure:"block_store_config"`
 	QueueRateLimit   RateLimitConfig    `yaml:"queue_rate_limit" mapstructure:"queue_rate_limit"`
+	ScrubberCfg      ScrubberConfig     `yaml:"scrubber" mapstructure:"scrubber"`
+	GCInterval       uint64             `yaml:"gc_interval" mapstructure:"gc_interval"`
+	SpaceReserve     int64              `yaml:"space_reserve_bytes" mapstructure:"space_reserve_bytes"`
+	MaintenanceCfg   MaintenanceConfig  `yaml:"maintenance" mapstructure:"maintenance"`
+	ProofCacheSize   int64              `yaml:"proof_cache_bytes" mapstructure:"proof_cache_bytes"`
+	ProofHistory     int                `yaml:"proof_history_entries" mapstructure:"proof_history_entries"`
 }
 
 func DefaultQueueInterval() uint64 {
@@ -46,6 +53,36 @@ func DefaultProofInterval() uint64 {
 	return 700
 }
 
+// DefaultProofRescan returns how many seconds pass between full scans of every stored
+// contract that reconcile the proof schedule with the chain.
+func DefaultProofRescan() uint64 {
+	return 86400
+}
+
+// DefaultGCInterval returns how many seconds pass between garbage collection sweeps of
+// blocks no longer used by any stored file.
+func DefaultGCInterval() uint64 {
+	return 3600
+}
+
+// DefaultSpaceReserve returns how many bytes of the data disks are kept free, uploads and strays
+// that would eat into them are refused.
+func DefaultSpaceReserve() int64 {
+	return 1024 * 1024 * 1024
+}
+
+// DefaultProofCacheSize returns how many bytes of merkle trees and chunks are kept in memory for
+// proofs. A negative size disables the cache.
+func DefaultProofCacheSize() int64 {
+	return 64 * 1024 * 1024
+}
+
+// DefaultProofHistory returns how many proof attempts are kept for each contract. A negative
+// count keeps every attempt.
+func DefaultProofHistory() int {
+	return 100
+}
+
 func DefaultIP() string {
 	return "https://example.com"
 }
@@ -86,12 +123,75 @@ func DefaultStrayManagerConfig() StrayManagerConfig {
 	}
 }
 
+type ScrubberConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// seconds between the start of two full passes over every stored file
+	Interval int64 `yaml:"interval" mapstructure:"interval"`
+	// milliseconds to wait between files so scrubbing doesn't starve proofs and uploads of disk bandwidth
+	Delay int64 `yaml:"delay_ms" mapstructure:"delay_ms"`
+	// download a fresh copy from other providers when a file is found damaged, damaged blocks are only
+	// removed from the blockstore when this is on
+	Repair bool `yaml:"repair" mapstructure:"repair"`
+}
+
+// DefaultScrubberConfig returns the default scrubber configuration, a weekly pass with repairs disabled.
+func DefaultScrubberConfig() ScrubberConfig {
+	return ScrubberConfig{
+		Enabled:  true,
+		Interval: 604800,
+		Delay:    500,
+		Repair:   false,
+	}
+}
+
+// MaintenanceConfig schedules the upkeep of the badger database, which never shrinks on its own.
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// seconds between value log garbage collections
+	Interval int64 `yaml:"interval" mapstructure:"interval"`
+	// share of a value log file that must be stale before it is rewritten, between 0 and 1
+	DiscardRatio float64 `yaml:"discard_ratio" mapstructure:"discard_ratio"`
+	// seconds between compactions of the LSM tree
+	CompactInterval int64 `yaml:"compact_interval" mapstructure:"compact_interval"`
+	// seconds without a file being written or removed before a due compaction starts
+	IdleAfter int64 `yaml:"idle_after" mapstructure:"idle_after"`
+}
+
+// DefaultMaintenanceConfig returns the default maintenance configuration, value log garbage collection
+// every ten minutes and a daily compaction.
+func DefaultMaintenanceConfig() MaintenanceConfig {
+	return MaintenanceConfig{
+		Enabled:         true,
+		Interval:        600,
+		DiscardRatio:    0.5,
+		CompactInterval: 86400,
+		IdleAfter:       300,
+	}
+}
+
 type APIConfig struct {
 	Port        int64  `yaml:"port" mapstructure:"port"`
 	IPFSPort    int    `yaml:"ipfs_port" mapstructure:"ipfs_port"`
 	IPFSDomain  string `yaml:"ipfs_domain" mapstructure:"ipfs_domain"`
 	IPFSSearch  bool   `yaml:"ipfs_search" mapstructure:"ipfs_search"`
 	OpenGateway bool   `yaml:"open_gateway" mapstructure:"open_gateway"`
+	AdminPort   int64  `yaml:"admin_port" mapstructure:"admin_port"` // backups and audits, served on localhost only
+}
+
+func DefaultAdminPort() int64 {
+	return 3334
+}
+
+// AdminListenPort returns the port the admin API listens on at localhost, false when a negative
+// admin_port turns it off. Configs written before the admin API existed get the default port.
+func (c APIConfig) AdminListenPort() (int64, bool) {
+	if c.AdminPort < 0 {
+		return 0, false
+	}
+	if c.AdminPort == 0 {
+		return DefaultAdminPort(), true
+	}
+	return c.AdminPort, true
 }
 
 // DefaultAPIConfig returns the default APIConfig with preset ports, IPFS domain, search enabled, and an open gateway.
@@ -102,22 +202,90 @@ func DefaultAPIConfig() APIConfig {
 		IPFSDomain:  "dns4/ipfs.example.com/tcp/4001",
 		IPFSSearch:  true,
 		OpenGateway: true,
+		AdminPort:   DefaultAdminPort(),
 	}
 }
 
 const (
 	OptBadgerDS = "badgerds"
 	OptFlatFS   = "flatfs"
+	OptS3       = "s3"
+)
+
+const (
+	CipherAESGCM            = "aes-gcm"
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
 )
 
 type BlockStoreConfig struct {
 	// *choosing badgerdb as block store will need to use the same directory
 	// for data directory
 	Directory string `yaml:"directory" mapstructure:"directory"`
-	// data store options: flatfs, badgerdb
+	// data store options: flatfs, badgerdb, s3
 	Type string `yaml:"type" mapstructure:"type"`
 
 	Key string `yaml:"key" mapstructure:"key"`
+
+	// only used by the s3 backend
+	S3 S3Config `yaml:"s3,omitempty" mapstructure:"s3"`
+
+	// flatfs only, spreads files over several directories instead of Directory
+	Disks []DiskConfig `yaml:"disks,omitempty" mapstructure:"disks"`
+
+	// optional slower tier that blocks of idle files are moved to, the store above becomes the hot tier
+	Cold *ColdTierConfig `yaml:"cold,omitempty" mapstructure:"cold"`
+
+	// file holding a 32 byte key, hex encoded or raw, blocks are encrypted at rest when it is set
+	EncryptionKeyFile string `yaml:"encryption_key_file,omitempty" mapstructure:"encryption_key_file"`
+	// aes-gcm or xchacha20-poly1305, empty uses aes-gcm
+	Cipher string `yaml:"cipher,omitempty" mapstructure:"cipher"`
+}
+
+// DiskConfig is one directory of a multi-disk flatfs blockstore. New files are placed on the disk
+// with the most free space after weighing it, a weight of 0 counts as 1. Draining disks are still read
+// but get no new files, `sequoia data rebalance` moves their files to the other disks.
+type DiskConfig struct {
+	Directory string `yaml:"directory" mapstructure:"directory"`
+	Weight    uint64 `yaml:"weight" mapstructure:"weight"`
+	Drain     bool   `yaml:"drain,omitempty" mapstructure:"drain"`
+}
+
+// ColdTierConfig describes the cold tier of a tiered blockstore.
+type ColdTierConfig struct {
+	// cold tier options: flatfs, s3
+	Type      string   `yaml:"type" mapstructure:"type"`
+	Directory string   `yaml:"directory" mapstructure:"directory"`
+	S3        S3Config `yaml:"s3,omitempty" mapstructure:"s3"`
+	// seconds a file can go without being served before its blocks move to the cold tier
+	DemoteAfter uint64 `yaml:"demote_after" mapstructure:"demote_after"`
+	// seconds between checks for idle files
+	Interval uint64 `yaml:"interval" mapstructure:"interval"`
+}
+
+func DefaultColdDemoteAfter() uint64 {
+	return 60 * 60 * 24 * 30 // 30 days
+}
+
+func DefaultColdInterval() uint64 {
+	return 60 * 60 * 6
+}
+
+// S3Config points the blockstore at a bucket of an S3 compatible object store such as MinIO.
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" mapstructure:"endpoint"`
+	Bucket    string `yaml:"bucket" mapstructure:"bucket"`
+	Region    string `yaml:"region" mapstructure:"region"`
+	AccessKey string `yaml:"access_key" mapstructure:"access_key"`
+	SecretKey string `yaml:"secret_key" mapstructure:"secret_key"`
+	// blocks are stored under this prefix so one bucket can be shared
+	Prefix string `yaml:"prefix" mapstructure:"prefix"`
+	UseSSL bool   `yaml:"use_ssl" mapstructure:"use_ssl"`
+	// size of the in memory cache of recently read blocks, 0 uses the default
+	CacheSizeBytes int64 `yaml:"cache_size_bytes" mapstructure:"cache_size_bytes"`
+}
+
+func DefaultS3CacheSizeBytes() int64 {
+	return 256 * 1024 * 1024
 }
 
 func DefaultBlockStoreConfig() BlockStoreConfig {
@@ -152,6 +320,7 @@ func DefaultConfig() *Config {
 		QueueInterval:    DefaultQueueInterval(),
 		MaxSizeBytes:     DefaultMaxSizeBytes(),
 		ProofInterval:    DefaultProofInterval(),
+		ProofRescan:      DefaultProofRescan(),
 		StrayManagerCfg:  DefaultStrayManagerConfig(),
 		ChainCfg:         DefaultChainConfig(),
 		Ip:               DefaultIP(),
@@ -161,6 +330,12 @@ func DefaultConfig() *Config {
 		ProofThreads:     DefaultProofThreads(),
 		BlockStoreConfig: DefaultBlockStoreConfig(),
 		QueueRateLimit:   DefaultRateLimitConfig(),
+		ScrubberCfg:      DefaultScrubberConfig(),
+		GCInterval:       DefaultGCInterval(),
+		SpaceReserve:     DefaultSpaceReserve(),
+		MaintenanceCfg:   DefaultMaintenanceConfig(),
+		ProofCacheSize:   DefaultProofCacheSize(),
+		ProofHistory:     DefaultProofHistory(),
 	}
 }
 
@@ -168,6 +343,7 @@ func (c Config) MarshalZerologObject(e *zerolog.Event) {
 	e.Uint64("QueueInterval", c.QueueInterval).
 		Int64("MaxSizeBytes", c.MaxSizeBytes).
 		Uint64("ProofInterval", c.ProofInterval).
+		Uint64("ProofRescan", c.ProofRescan).
 		Int64("StrayCheckInterval", c.StrayManagerCfg.CheckInterval).
 		Int64("StrayRefreshInterval", c.StrayManagerCfg.RefreshInterval).
 		Int("StrayHandCount", c.StrayManagerCfg.HandCount).
@@ -181,16 +357,31 @@ func (c Config) MarshalZerologObject(e *zerolog.Event) {
 		Int64("APIPort", c.APICfg.Port).
 		Int("APIIPFSPort", c.APICfg.IPFSPort).
 		Str("APIIPFSDomain", c.APICfg.IPFSDomain).
+		Int64("APIAdminPort", c.APICfg.AdminPort).
 		Int16("ProofThreads", c.ProofThreads).
 		Str("BlockstoreBackend", c.BlockStoreConfig.Type).
+		Bool("BlockstoreTiered", c.BlockStoreConfig.Cold != nil).
+		Bool("BlockstoreEncrypted", c.BlockStoreConfig.EncryptionKeyFile != "").
 		Int64("RateLimitPerTokenMs", c.QueueRateLimit.PerTokenMs).
-		Int("RateLimitBurst", c.QueueRateLimit.Burst)
+		Int("RateLimitBurst", c.QueueRateLimit.Burst).
+		Bool("ScrubberEnabled", c.ScrubberCfg.Enabled).
+		Int64("ScrubberInterval", c.ScrubberCfg.Interval).
+		Bool("ScrubberRepair", c.ScrubberCfg.Repair).
+		Uint64("GCInterval", c.GCInterval).
+		Int64("SpaceReserve", c.SpaceReserve).
+		Bool("MaintenanceEnabled", c.MaintenanceCfg.Enabled).
+		Int64("MaintenanceInterval", c.MaintenanceCfg.Interval).
+		Float64("MaintenanceDiscardRatio", c.MaintenanceCfg.DiscardRatio).
+		Int64("MaintenanceCompactInterval", c.MaintenanceCfg.CompactInterval).
+		Int64("ProofCacheSize", c.ProofCacheSize).
+		Int("ProofHistory", c.ProofHistory)
 }
 
 func init() {
 	viper.SetDefault("QueueInterval", DefaultQueueInterval())
 	viper.SetDefault("MaxSizeBytes", DefaultMaxSizeBytes())
 	viper.SetDefault("ProofInterval", DefaultProofInterval())
+	viper.SetDefault("ProofRescan", DefaultProofRescan())
 	viper.SetDefault("StrayManagerCfg", DefaultStrayManagerConfig())
 	viper.SetDefault("ChainCfg", DefaultChainConfig())
 	viper.SetDefault("Ip", DefaultIP())
@@ -200,4 +391,10 @@ func init() {
 	viper.SetDefault("ProofThreads", DefaultProofThreads())
 	viper.SetDefault("BlockStoreConfig", DefaultBlockStoreConfig())
 	viper.SetDefault("QueueRateLimit", DefaultRateLimitConfig())
+	viper.SetDefault("ScrubberCfg", DefaultScrubberConfig())
+	viper.SetDefault("GCInterval", DefaultGCInterval())
+	viper.SetDefault("SpaceReserve", DefaultSpaceReserve())
+	viper.SetDefault("MaintenanceCfg", DefaultMaintenanceConfig())
+	viper.SetDefault("ProofCacheSize", DefaultProofCacheSize())
+	viper.SetDefault("ProofHistory", DefaultProofHistory())
 }
@@ -16,15 +16,16 @@ import (
 	"time"
 
 	"github.com/JackalLabs/sequoia/utils"
+	"github.com/dgraph-io/badger/v4"
 
 	apiTypes "github.com/JackalLabs/sequoia/api/types"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	"github.com/JackalLabs/sequoia/file_system"
 	"github.com/JackalLabs/sequoia/ipfs"
-	"github.com/ipfs/boxo/blockstore"
 
 	"github.com/JackalLabs/sequoia/monitoring"
+	"github.com/JackalLabs/sequoia/network"
 
 	"github.com/cosmos/gogoproto/grpc"
 
@@ -45,16 +46,23 @@ type App struct {
 	q            *queue.Queue
 	prover       *proofs.Prover
 	strayManager *strays.StrayManager
+	scrubber     *file_system.Scrubber
+	gc           *file_system.GarbageCollector
+	maintenance  *file_system.Maintenance
+	tiers        *file_system.TierManager
 	home         string
 	monitor      *monitoring.Monitor
 	fileSystem   *file_system.FileSystem
 	wallet       *wallet.Wallet
+	db           *badger.DB
 }
 
 // NewApp initializes and returns a new App instance using the provided home directory.
 // It sets up configuration, data directories, database, IPFS datastore and blockstore, API server, wallet, and file system.
 // Returns the initialized App or an error if any component fails to initialize.
-func NewApp(home string) (*App, error) {
+// Pending database migrations run before it returns, after the operator confirmed a backup exists
+// unless migrate is set.
+func NewApp(home string, migrate bool) (*App, error) {
 	cfg, err := config.Init(home)
 	if err != nil {
 		return nil, err
@@ -80,16 +88,9 @@ func NewApp(home string) (*App, error) {
 	}
 	log.Info().Msg("Data store initialized")
 
-	bsDir := os.ExpandEnv(cfg.BlockStoreConfig.Directory)
-	var bs blockstore.Blockstore
-	bs = nil
-	switch cfg.BlockStoreConfig.Type {
-	case config.OptBadgerDS:
-	case config.OptFlatFS:
-		bs, err = ipfs.NewFlatfsBlockStore(bsDir)
-		if err != nil {
-			return nil, err
-		}
+	bs, err := ipfs.OpenBlockStore(ctx, cfg.BlockStoreConfig, ds)
+	if err != nil {
+		return nil, err
 	}
 	log.Info().Msg("Blockstore initialized")
 
@@ -109,12 +110,36 @@ func NewApp(home string) (*App, error) {
 	}
 	log.Info().Msg("File system initialized")
 
+	err = migrateDatabase(f, dataDir, migrate)
+	if err != nil {
+		return nil, err
+	}
+
+	spaceReserve := cfg.SpaceReserve
+	if spaceReserve == 0 {
+		spaceReserve = config.DefaultSpaceReserve()
+	}
+	f.SetSpaceLimits(cfg.TotalSpace, spaceReserve, dataDisks(cfg))
+
+	proofCacheSize := cfg.ProofCacheSize
+	if proofCacheSize == 0 {
+		proofCacheSize = config.DefaultProofCacheSize()
+	}
+	f.SetProofCacheSize(proofCacheSize)
+
+	proofHistory := cfg.ProofHistory
+	if proofHistory == 0 {
+		proofHistory = config.DefaultProofHistory()
+	}
+	f.SetProofHistoryLimit(proofHistory)
+
 	return &App{
 		fileSystem:  f,
 		api:         apiServer,
 		pprofServer: pprofServer,
 		home:        home,
 		wallet:      w,
+		db:          db,
 	}, nil
 }
 
@@ -256,10 +281,10 @@ func (a *App) Start() error {
 		return err
 	}
 
-	a.q = queue.NewQueue(a.wallet, cfg.QueueInterval, cfg.MaxSizeBytes, cfg.Ip, cfg.QueueRateLimit)
+	a.q = queue.NewQueue(a.wallet, a.db, cfg.QueueInterval, cfg.MaxSizeBytes, cfg.Ip, cfg.QueueRateLimit)
 	go a.q.Listen()
 
-	prover := proofs.NewProver(a.wallet, a.q, a.fileSystem, cfg.ProofInterval, cfg.ProofThreads, int(params.ChunkSize))
+	prover := proofs.NewProver(a.wallet, a.q, a.fileSystem, cfg.ProofInterval, cfg.ProofRescan, cfg.ProofThreads, int(params.ChunkSize))
 
 	myUrl := cfg.Ip
 
@@ -269,6 +294,42 @@ func (a *App) Start() error {
 	a.strayManager = strays.NewStrayManager(a.wallet, a.q, cfg.StrayManagerCfg.CheckInterval, cfg.StrayManagerCfg.RefreshInterval, cfg.StrayManagerCfg.HandCount, claimers)
 	a.monitor = monitoring.NewMonitor(a.wallet)
 
+	var repair file_system.RepairFunc
+	if cfg.ScrubberCfg.Repair {
+		repair = a.repairFile(myUrl, params.ChunkSize)
+	}
+	a.scrubber = file_system.NewScrubber(a.fileSystem, params.ChunkSize, time.Second*time.Duration(cfg.ScrubberCfg.Interval), time.Millisecond*time.Duration(cfg.ScrubberCfg.Delay), repair)
+
+	gcInterval := cfg.GCInterval
+	if gcInterval == 0 {
+		gcInterval = config.DefaultGCInterval()
+	}
+	a.gc = file_system.NewGarbageCollector(a.fileSystem, time.Second*time.Duration(gcInterval))
+
+	if m := cfg.MaintenanceCfg; m.Enabled {
+		interval := m.Interval
+		if interval == 0 {
+			interval = config.DefaultMaintenanceConfig().Interval
+		}
+		discardRatio := m.DiscardRatio
+		if discardRatio == 0 {
+			discardRatio = config.DefaultMaintenanceConfig().DiscardRatio
+		}
+		a.maintenance = file_system.NewMaintenance(a.fileSystem, time.Second*time.Duration(interval), discardRatio, time.Second*time.Duration(m.CompactInterval), time.Second*time.Duration(m.IdleAfter))
+	}
+
+	if cold := cfg.BlockStoreConfig.Cold; cold != nil && a.fileSystem.Tiered() {
+		demoteAfter := cold.DemoteAfter
+		if demoteAfter == 0 {
+			demoteAfter = config.DefaultColdDemoteAfter()
+		}
+		tierInterval := cold.Interval
+		if tierInterval == 0 {
+			tierInterval = config.DefaultColdInterval()
+		}
+		a.tiers = file_system.NewTierManager(a.fileSystem, time.Second*time.Duration(demoteAfter), time.Second*time.Duration(tierInterval))
+	}
+
 	// Starting the 4 concurrent services
 	if cfg.APICfg.IPFSSearch {
 		// nolint:all
@@ -279,6 +340,16 @@ func (a *App) Start() error {
 	go a.strayManager.Start(a.fileSystem, a.q, myUrl, params.ChunkSize)
 	go a.monitor.Start()
 	go a.pprofServer.Start()
+	if cfg.ScrubberCfg.Enabled {
+		go a.scrubber.Start()
+	}
+	go a.gc.Start()
+	if a.maintenance != nil {
+		go a.maintenance.Start()
+	}
+	if a.tiers != nil {
+		go a.tiers.Start()
+	}
 
 	done := make(chan os.Signal, 1)
 	defer signal.Stop(done) // undo signal.Notify effect
@@ -293,6 +364,14 @@ func (a *App) Start() error {
 	a.prover.Stop()
 	a.strayManager.Stop()
 	a.monitor.Stop()
+	a.scrubber.Stop()
+	a.gc.Stop()
+	if a.maintenance != nil {
+		a.maintenance.Stop()
+	}
+	if a.tiers != nil {
+		a.tiers.Stop()
+	}
 
 	time.Sleep(time.Second * 30) // give the program some time to shut down
 	a.fileSystem.Close()
@@ -301,6 +380,24 @@ func (a *App) Start() error {
 	return nil
 }
 
+// repairFile returns a file_system.RepairFunc that downloads a fresh copy of a damaged file from the other providers storing it.
+func (a *App) repairFile(myUrl string, chunkSize int64) file_system.RepairFunc {
+	return func(merkle []byte, owner string, start int64) error {
+		cl := storageTypes.NewQueryClient(a.wallet.Client.GRPCConn)
+		res, err := cl.File(context.Background(), &storageTypes.QueryFile{
+			Merkle: merkle,
+			Owner:  owner,
+			Start:  start,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot find file on chain | %w", err)
+		}
+
+		f := res.File
+		return network.DownloadFile(a.fileSystem, merkle, owner, start, a.wallet, f.FileSize, myUrl, chunkSize, f.ProofType, utils.GetIPFSParams(&f), file_system.SourceRepair)
+	}
+}
+
 func (a *App) ConnectPeers() {
 	log.Info().Msg("Starting IPFS Peering cycle...")
 	ctx := context.Background()
@@ -378,3 +475,14 @@ func (a *App) ConnectPeers() {
 		}
 	}
 }
+
+// dataDisks lists the local directories that fill up as files are stored. Blockstores spread over
+// several disks are checked by the file system itself.
+func dataDisks(cfg *config.Config) []string {
+	dirs := []string{cfg.DataDirectory}
+	bs := cfg.BlockStoreConfig
+	if bs.Type == config.OptFlatFS && len(bs.Disks) == 0 && bs.Directory != cfg.DataDirectory {
+		dirs = append(dirs, bs.Directory)
+	}
+	return dirs
+}
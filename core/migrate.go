@@ -0,0 +1,53 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/JackalLabs/sequoia/file_system"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+)
+
+// migrateDatabase runs pending migrations. Without migrate the operator is asked to confirm that the
+// data directory is backed up, which needs a terminal, so unattended starts have to pass the flag.
+func migrateDatabase(f *file_system.FileSystem, dataDir string, migrate bool) error {
+	pending, err := f.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if !migrate {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("%w, back up %s and start again with --migrate or run `sequoia data migrate`", file_system.ErrSchemaOutdated, dataDir)
+		}
+
+		fmt.Printf("The database needs %d migrations:\n", len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %d: %s\n", m.Version, m.Name)
+		}
+		fmt.Printf("Migrations can't be undone, make sure %s is backed up. Continue? [y/N] ", dataDir)
+
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			return fmt.Errorf("%w, migration declined", file_system.ErrSchemaOutdated)
+		}
+	}
+
+	err = f.Migrate()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("version", file_system.LatestSchemaVersion()).Msg("Database migrated")
+	return nil
+}
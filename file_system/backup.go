@@ -0,0 +1,592 @@
+package file_system
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	ipfs2 "github.com/JackalLabs/sequoia/ipfs"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipldFormat "github.com/ipfs/go-ipld-format"
+	"github.com/rs/zerolog/log"
+)
+
+// backupMagic starts every backup archive. An archive is a list of named sections, each streamed
+// as length prefixed chunks ending in an empty chunk, so nothing has to be sized up front. An
+// empty section name ends the archive, which tells a finished archive apart from a cut off one.
+const (
+	backupMagic  = "SEQUOIA-BACKUP\n"
+	backupFormat = 1
+
+	sectionManifest = "manifest"
+	sectionBadger   = "badger"
+	sectionCids     = "cids"
+	sectionBlocks   = "blocks"
+
+	maxSectionName = 64
+	maxBackupChunk = 1 << 30
+)
+
+// ErrInvalidBackup is returned for archives that are cut off or not backups at all.
+var ErrInvalidBackup = errors.New("invalid backup archive")
+
+// BackupManifest describes a backup archive.
+type BackupManifest struct {
+	Format        int       `json:"format"`
+	Created       time.Time `json:"created"`
+	SchemaVersion int       `json:"schema_version"`
+	Blocks        bool      `json:"blocks"`    // whether block contents follow the CID list
+	Encrypted     bool      `json:"encrypted"` // whether those blocks are still encrypted with the blockstore key
+}
+
+// BackupResult describes a finished backup.
+type BackupResult struct {
+	Manifest BackupManifest
+	Cids     int   // blocks listed in the blockstore manifest
+	Blocks   int   // blocks written to the archive
+	Bytes    int64 // bytes of block data written to the archive
+}
+
+type sectionWriter struct {
+	w *bufio.Writer
+}
+
+func (s *sectionWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	_, err := s.w.Write(binary.AppendUvarint(nil, uint64(len(p))))
+	if err != nil {
+		return 0, err
+	}
+	return s.w.Write(p)
+}
+
+type backupWriter struct {
+	w *bufio.Writer
+}
+
+// section streams one section, write is done with it once it returns.
+func (b *backupWriter) section(name string, write func(w io.Writer) error) error {
+	_, err := b.w.Write(binary.AppendUvarint(nil, uint64(len(name))))
+	if err != nil {
+		return err
+	}
+	_, err = b.w.WriteString(name)
+	if err != nil {
+		return err
+	}
+
+	err = write(&sectionWriter{w: b.w})
+	if err != nil {
+		return fmt.Errorf("cannot write %s section | %w", name, err)
+	}
+
+	_, err = b.w.Write(binary.AppendUvarint(nil, 0))
+	return err
+}
+
+func (b *backupWriter) end() error {
+	_, err := b.w.Write(binary.AppendUvarint(nil, 0))
+	if err != nil {
+		return err
+	}
+	return b.w.Flush()
+}
+
+type sectionReader struct {
+	r         *bufio.Reader
+	remaining uint64
+	done      bool
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	if s.remaining == 0 {
+		n, err := binary.ReadUvarint(s.r)
+		if err != nil {
+			return 0, fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+		}
+		if n == 0 {
+			s.done = true
+			return 0, io.EOF
+		}
+		if n > maxBackupChunk {
+			return 0, fmt.Errorf("%w, chunk of %d bytes", ErrInvalidBackup, n)
+		}
+		s.remaining = n
+	}
+
+	if uint64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= uint64(n)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+type backupReader struct {
+	r       *bufio.Reader
+	current *sectionReader
+}
+
+// next skips the rest of the current section and returns the name of the next one, an empty name
+// at the end of the archive.
+func (b *backupReader) next() (string, io.Reader, error) {
+	if b.current != nil {
+		_, err := io.Copy(io.Discard, b.current)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	n, err := binary.ReadUvarint(b.r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+	}
+	if n == 0 {
+		return "", nil, nil
+	}
+	if n > maxSectionName {
+		return "", nil, fmt.Errorf("%w, section name of %d bytes", ErrInvalidBackup, n)
+	}
+	name := make([]byte, n)
+	_, err = io.ReadFull(b.r, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+	}
+
+	b.current = &sectionReader{r: b.r}
+	return string(name), b.current, nil
+}
+
+// expect reads the next section and fails unless it is called name.
+func (b *backupReader) expect(name string) (io.Reader, error) {
+	got, r, err := b.next()
+	if err != nil {
+		return nil, err
+	}
+	if got != name {
+		return nil, fmt.Errorf("%w, expected %s section but found %q", ErrInvalidBackup, name, got)
+	}
+	return r, nil
+}
+
+// Backup streams a consistent copy of the database to w, followed by the CIDs of every block in
+// the blockstore and, with withBlocks, the blocks themselves. The database is read from a single
+// snapshot so files keep being written meanwhile. Garbage collection waits for the backup, so no
+// block of a file in the snapshot is removed before it is copied.
+//
+// Blocks of a badger blockstore are part of the database and don't need withBlocks. Blocks of an
+// encrypted blockstore are written as they are stored, so the archive doesn't hold them in the clear
+// and restoring them takes the same key.
+func (f *FileSystem) Backup(ctx context.Context, w io.Writer, withBlocks bool) (*BackupResult, error) {
+	f.backupLock.RLock()
+	defer f.backupLock.RUnlock()
+
+	started := time.Now()
+
+	schemaVersion, err := f.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &BackupResult{
+		Manifest: BackupManifest{
+			Format:        backupFormat,
+			Created:       started.UTC(),
+			SchemaVersion: schemaVersion,
+			Blocks:        withBlocks,
+			Encrypted:     withBlocks && f.encrypted != nil,
+		},
+	}
+
+	bw := &backupWriter{w: bufio.NewWriterSize(w, 1<<20)}
+	_, err = bw.w.WriteString(backupMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bw.section(sectionManifest, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(res.Manifest)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = bw.section(sectionBadger, func(w io.Writer) error {
+		_, err := f.db.Backup(w, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// listed after the snapshot, so every block of a file in it is listed
+	cids, err := f.listBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res.Cids = len(cids)
+
+	err = bw.section(sectionCids, func(w io.Writer) error {
+		for _, c := range cids {
+			_, err := io.WriteString(w, c.String()+"\n")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if withBlocks {
+		bs := f.ipfs.BlockStore()
+		if f.encrypted != nil {
+			bs = f.encrypted.Inner()
+		}
+		err = bw.section(sectionBlocks, func(w io.Writer) error {
+			for _, c := range cids {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				blk, err := bs.Get(ctx, c)
+				if err != nil {
+					if ipldFormat.IsNotFound(err) {
+						continue // removed since the listing by a file that isn't in the snapshot
+					}
+					return fmt.Errorf("cannot read block %s | %w", c.String(), err)
+				}
+
+				record := binary.AppendUvarint(nil, uint64(c.ByteLen()))
+				record = append(record, c.Bytes()...)
+				record = binary.AppendUvarint(record, uint64(len(blk.RawData())))
+				_, err = w.Write(append(record, blk.RawData()...))
+				if err != nil {
+					return err
+				}
+
+				res.Blocks++
+				res.Bytes += int64(len(blk.RawData()))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = bw.end()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Int("cids", res.Cids).
+		Int("blocks", res.Blocks).
+		Int64("bytes", res.Bytes).
+		Dur("took", time.Since(started)).
+		Msg("Backup finished")
+
+	return res, nil
+}
+
+// Restore loads a backup archive into db, which has to be empty, and writes the blocks it carries
+// to bs. Encrypted blocks are written as they are and need bs to be encrypted with the same key. It returns the manifest and the CIDs the blockstore held when the backup was taken, which
+// CheckRestore compares the restored provider against. Restore runs before NewFileSystem, which
+// would otherwise stamp the empty database with a schema version of its own.
+func Restore(ctx context.Context, r io.Reader, db *badger.DB, bs blockstore.Blockstore) (*BackupManifest, []cid.Cid, error) {
+	empty := true
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !empty {
+		return nil, nil, fmt.Errorf("cannot restore into a database that already holds data")
+	}
+
+	br := &backupReader{r: bufio.NewReaderSize(r, 1<<20)}
+
+	magic := make([]byte, len(backupMagic))
+	_, err = io.ReadFull(br.r, magic)
+	if err != nil || string(magic) != backupMagic {
+		return nil, nil, fmt.Errorf("%w, not a sequoia backup", ErrInvalidBackup)
+	}
+
+	sr, err := br.expect(sectionManifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest BackupManifest
+	err = json.NewDecoder(sr).Decode(&manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w, cannot decode manifest | %w", ErrInvalidBackup, err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, nil, fmt.Errorf("unsupported backup format %d", manifest.Format)
+	}
+	if manifest.SchemaVersion > LatestSchemaVersion() {
+		return nil, nil, fmt.Errorf("backup schema version %d is newer than this build supports (%d)", manifest.SchemaVersion, LatestSchemaVersion())
+	}
+	enc, encrypted := bs.(*ipfs2.EncryptedBlockStore)
+	if manifest.Encrypted && !encrypted {
+		return nil, nil, errors.New("backup holds encrypted blocks, configure the blockstore key it was taken with")
+	}
+
+	sr, err = br.expect(sectionBadger)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = db.Load(sr, 256)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load database | %w", err)
+	}
+
+	sr, err = br.expect(sectionCids)
+	if err != nil {
+		return nil, nil, err
+	}
+	cids := make([]cid.Cid, 0)
+	scanner := bufio.NewScanner(sr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		c, err := cid.Decode(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w, cannot decode cid %q | %w", ErrInvalidBackup, line, err)
+		}
+		cids = append(cids, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	name, sr, err := br.next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == sectionBlocks {
+		put := func(ctx context.Context, blk blocks.Block) error {
+			sum, err := blk.Cid().Prefix().Sum(blk.RawData())
+			if err != nil {
+				return err
+			}
+			if !sum.Equals(blk.Cid()) {
+				return fmt.Errorf("%w, block %s does not match its cid", ErrInvalidBackup, blk.Cid().String())
+			}
+			return bs.Put(ctx, blk)
+		}
+		if manifest.Encrypted {
+			put = enc.PutSealed // opening the block checks it against its cid
+		}
+		err = restoreBlocks(ctx, sr, put)
+		if err != nil {
+			return nil, nil, err
+		}
+		name, _, err = br.next()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if name != "" {
+		return nil, nil, fmt.Errorf("%w, unexpected %q section", ErrInvalidBackup, name)
+	}
+
+	return &manifest, cids, nil
+}
+
+// restoreBlocks writes the blocks of a blocks section with put, which checks each against its CID.
+func restoreBlocks(ctx context.Context, r io.Reader, put func(context.Context, blocks.Block) error) error {
+	br := bufio.NewReader(r)
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if n > maxBackupChunk {
+			return fmt.Errorf("%w, cid of %d bytes", ErrInvalidBackup, n)
+		}
+		key := make([]byte, n)
+		_, err = io.ReadFull(br, key)
+		if err != nil {
+			return fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+		}
+		c, err := cid.Cast(key)
+		if err != nil {
+			return fmt.Errorf("%w, cannot decode cid | %w", ErrInvalidBackup, err)
+		}
+
+		n, err = binary.ReadUvarint(br)
+		if err != nil {
+			return fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+		}
+		if n > maxBackupChunk {
+			return fmt.Errorf("%w, block of %d bytes", ErrInvalidBackup, n)
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(br, data)
+		if err != nil {
+			return fmt.Errorf("%w, %w", ErrInvalidBackup, io.ErrUnexpectedEOF)
+		}
+
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return err
+		}
+		err = put(ctx, blk)
+		if errors.Is(err, ErrInvalidBackup) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot write block %s | %w", c.String(), err)
+		}
+	}
+}
+
+// RestoreCheck compares a restored provider against its backup.
+type RestoreCheck struct {
+	Roots         int      // cid/ roots in the database
+	Incomplete    []string // roots missing at least one block
+	Listed        int      // blocks the blockstore held when the backup was taken
+	MissingBlocks int      // listed blocks that aren't in the blockstore
+}
+
+// CheckRestore walks every cid/ root of a restored provider and reports the roots that miss
+// blocks, as well as how many of the blocks listed in the backup are missing.
+func (f *FileSystem) CheckRestore(ctx context.Context, listed []cid.Cid) (*RestoreCheck, error) {
+	roots, err := f.ListCids()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RestoreCheck{
+		Roots:      len(roots),
+		Incomplete: make([]string, 0),
+		Listed:     len(listed),
+	}
+
+	bs := f.ipfs.BlockStore()
+	present := make(markSet)
+	missing := make(markSet)
+	for _, s := range roots {
+		root, err := cid.Decode(s)
+		if err != nil {
+			res.Incomplete = append(res.Incomplete, s)
+			continue
+		}
+
+		complete, err := f.rootComplete(ctx, root, present, missing)
+		if err != nil {
+			return nil, err
+		}
+		if !complete {
+			res.Incomplete = append(res.Incomplete, s)
+		}
+	}
+
+	for _, c := range listed {
+		if present.has(c) {
+			continue
+		}
+		if missing.has(c) {
+			res.MissingBlocks++
+			continue
+		}
+		has, err := bs.Has(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			res.MissingBlocks++
+		}
+	}
+
+	return res, nil
+}
+
+// rootComplete reports whether every block reachable from root is in the blockstore. Blocks are
+// recorded in present or missing, so leaves shared between files are only looked up once.
+func (f *FileSystem) rootComplete(ctx context.Context, root cid.Cid, present markSet, missing markSet) (bool, error) {
+	bs := f.ipfs.BlockStore()
+	complete := true
+	visited := make(markSet)
+	toVisit := []cid.Cid{root}
+
+	for len(toVisit) > 0 {
+		c := toVisit[0]
+		toVisit = toVisit[1:]
+
+		if !visited.add(c) {
+			continue
+		}
+		if missing.has(c) {
+			complete = false
+			continue
+		}
+
+		if c.Type() == cid.Raw {
+			if present.has(c) {
+				continue
+			}
+			has, err := bs.Has(ctx, c)
+			if err != nil {
+				return false, err
+			}
+			if has {
+				present.add(c)
+			} else {
+				missing.add(c)
+				complete = false
+			}
+			continue
+		}
+
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			if ipldFormat.IsNotFound(err) {
+				missing.add(c)
+				complete = false
+				continue
+			}
+			return false, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+		present.add(c)
+
+		links, err := blockLinks(blk)
+		if err != nil {
+			return false, fmt.Errorf("cannot decode block %s | %w", c.String(), err)
+		}
+		for _, l := range links {
+			toVisit = append(toVisit, l.Cid)
+		}
+	}
+
+	return complete, nil
+}
@@ -0,0 +1,141 @@
+package file_system
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Kinds of entries in the proof cache.
+const (
+	cacheTree  = "tree"
+	cachePage  = "page"
+	cacheChunk = "chunk"
+
+	cacheEntryOverhead = 128 // rough bookkeeping cost of an entry on top of its key and value
+)
+
+type cacheEntry struct {
+	key    string
+	merkle string
+	value  any
+	size   int64
+}
+
+// proofCache keeps the tree headers, tree pages and chunks of recent proofs, evicting the least
+// recently used entries once they add up to more than limit bytes. A limit of 0 or a nil cache
+// disables it. Values are shared between callers and must not be modified.
+type proofCache struct {
+	lock     sync.Mutex
+	limit    int64
+	size     int64
+	items    map[string]*list.Element
+	byMerkle map[string]map[string]struct{} // keys of the entries of each file
+	order    *list.List                     // front is the most recently used
+}
+
+func newProofCache(limit int64) *proofCache {
+	return &proofCache{
+		limit:    max(limit, 0),
+		items:    make(map[string]*list.Element),
+		byMerkle: make(map[string]map[string]struct{}),
+		order:    list.New(),
+	}
+}
+
+func (c *proofCache) get(kind string, key string) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.limit == 0 {
+		return nil, false
+	}
+
+	e, ok := c.items[key]
+	if !ok {
+		cacheMisses.WithLabelValues(kind).Inc()
+		return nil, false
+	}
+	cacheHits.WithLabelValues(kind).Inc()
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// add caches value under key, size being the bytes it holds. Entries are tagged with their merkle
+// so every entry of a file can be dropped when its records change.
+func (c *proofCache) add(key string, merkle []byte, value any, size int64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	size += int64(len(key)+len(merkle)) + cacheEntryOverhead
+	if size > c.limit {
+		return
+	}
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	m := string(merkle)
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, merkle: m, value: value, size: size})
+	if c.byMerkle[m] == nil {
+		c.byMerkle[m] = make(map[string]struct{})
+	}
+	c.byMerkle[m][key] = struct{}{}
+	c.size += size
+
+	for c.size > c.limit {
+		c.remove(c.order.Back())
+	}
+	cacheBytes.Set(float64(c.size))
+}
+
+func (c *proofCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	delete(c.byMerkle[entry.merkle], entry.key)
+	if len(c.byMerkle[entry.merkle]) == 0 {
+		delete(c.byMerkle, entry.merkle)
+	}
+	c.size -= entry.size
+}
+
+// dropMerkle removes every entry of a file.
+func (c *proofCache) dropMerkle(merkle []byte) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key := range c.byMerkle[string(merkle)] {
+		c.remove(c.items[key])
+	}
+	cacheBytes.Set(float64(c.size))
+}
+
+// resize changes the memory budget, evicting entries until the cache fits.
+func (c *proofCache) resize(limit int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.limit = max(limit, 0)
+	for c.size > c.limit {
+		c.remove(c.order.Back())
+	}
+	cacheBytes.Set(float64(c.size))
+}
+
+// SetProofCacheSize sets how many bytes of trees and chunks are kept in memory for proofs, 0
+// disables the cache.
+func (f *FileSystem) SetProofCacheSize(limit int64) {
+	if f.cache == nil {
+		f.cache = newProofCache(limit)
+		return
+	}
+	f.cache.resize(limit)
+}
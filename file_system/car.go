@@ -0,0 +1,178 @@
+package file_system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	ipfslite "github.com/hsanjuan/ipfs-lite"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const carImportBatchSize = 256
+
+// ExportCAR writes the DAG stored for merkle to w as a CAR archive rooted at its cid. Only the local
+// blockstore is read, a missing block fails the export instead of being fetched from the network.
+// CARv2 archives need w to be an io.WriterAt, so streams have to be written as CARv1.
+func (f *FileSystem) ExportCAR(ctx context.Context, merkle []byte, w io.Writer, v1 bool) error {
+	fcid, err := f.GetCIDFromMerkle(merkle)
+	if err != nil {
+		return fmt.Errorf("cannot get cid of %x | %w", merkle, err)
+	}
+
+	root, err := cid.Decode(fcid)
+	if err != nil {
+		return fmt.Errorf("cannot decode cid '%s' | %w", fcid, err)
+	}
+
+	car, err := storage.NewWritable(w, []cid.Cid{root}, carv2.WriteAsCarV1(v1))
+	if err != nil {
+		return fmt.Errorf("cannot create car writer | %w", err)
+	}
+
+	ctx = f.fileContext(ctx, merkle)
+	bs := f.ipfs.BlockStore()
+
+	toVisit := []cid.Cid{root}
+	visited := make(map[cid.Cid]struct{})
+
+	for len(toVisit) > 0 {
+		c := toVisit[0]
+		toVisit = toVisit[1:]
+
+		if _, ok := visited[c]; ok {
+			continue
+		}
+		visited[c] = struct{}{}
+
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+
+		err = car.Put(ctx, c.KeyString(), blk.RawData())
+		if err != nil {
+			return fmt.Errorf("cannot write block %s | %w", c.String(), err)
+		}
+
+		links, err := blockLinks(blk)
+		if err != nil {
+			return fmt.Errorf("cannot decode block %s | %w", c.String(), err)
+		}
+		for _, l := range links {
+			toVisit = append(toVisit, l.Cid)
+		}
+	}
+
+	return car.Finalize()
+}
+
+// ImportCAR stores the blocks of a CARv1 or CARv2 archive and records the contract the same way WriteFile
+// would. The archive's first root is taken as the file, its merkle tree is rebuilt from the imported DAG
+// and has to match merkle before the tree and cid are saved. Rejected imports are left to the garbage collector.
+func (f *FileSystem) ImportCAR(ctx context.Context, r io.Reader, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, source string) (size int, fcid string, err error) {
+	log.Info().Msg(fmt.Sprintf("Importing %x from car", merkle))
+
+	reader, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot read car | %w", err)
+	}
+	if len(reader.Roots) == 0 {
+		return 0, "", errors.New("car has no roots")
+	}
+	root := reader.Roots[0]
+
+	ctx, err = f.placeFile(ctx, merkle)
+	if err != nil {
+		return 0, "", err
+	}
+
+	// keep garbage collection from sweeping the new blocks before the cid is recorded
+	done := f.startWrite()
+	defer done()
+
+	err = f.putCARBlocks(ctx, reader)
+	if err != nil {
+		f.discardBlocks(fmt.Sprintf("incomplete/%x", merkle))
+		return 0, "", err
+	}
+
+	missing, corrupt, err := f.checkBlocks(ctx, root)
+	if err != nil {
+		f.discardBlocks(root.String())
+		return 0, "", err
+	}
+	if len(missing) > 0 || len(corrupt) > 0 {
+		f.discardBlocks(root.String())
+		return 0, "", fmt.Errorf("car is incomplete, %d blocks missing and %d corrupt", len(missing), len(corrupt))
+	}
+
+	data, err := f.getRootData(ctx, root)
+	if err != nil {
+		f.discardBlocks(root.String())
+		return 0, "", err
+	}
+	//nolint:errcheck
+	defer data.Close()
+
+	rebuilt, tree, size, err := BuildTree(data, chunkSize, proofType)
+	if err != nil {
+		f.discardBlocks(root.String())
+		return 0, "", fmt.Errorf("cannot build tree | %w", err)
+	}
+
+	if string(rebuilt) != string(merkle) {
+		f.discardBlocks(root.String())
+		return 0, "", fmt.Errorf("merkle does not match %x != %x", merkle, rebuilt)
+	}
+
+	err = f.recordFile(ctx, root, tree, size, merkle, owner, start, chunkSize, proofType, ipfsParams, source)
+	if err != nil {
+		return 0, "", err
+	}
+
+	fileCount.Inc()
+	return size, root.String(), nil
+}
+
+// putCARBlocks copies every block of a car into the blockstore in batches.
+func (f *FileSystem) putCARBlocks(ctx context.Context, reader *carv2.BlockReader) error {
+	bs := f.ipfs.BlockStore()
+
+	batch := make([]blocks.Block, 0, carImportBatchSize)
+	for {
+		blk, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read block from car | %w", err)
+		}
+
+		batch = append(batch, blk)
+		if len(batch) < carImportBatchSize {
+			continue
+		}
+
+		err = bs.PutMany(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("cannot store blocks | %w", err)
+		}
+		batch = batch[:0]
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := bs.PutMany(ctx, batch)
+	if err != nil {
+		return fmt.Errorf("cannot store blocks | %w", err)
+	}
+	return nil
+}
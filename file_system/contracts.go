@@ -0,0 +1,189 @@
+package file_system
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// The contract index maps a merkle to every contract stored for it as contract/<merkle>/<owner>/<start>.
+// It is written in the same transaction as the tree record, so deciding whether a file is still needed
+// never has to guess from other key layouts.
+const (
+	contractPrefix       = "contract/"
+	contractMigrationKey = "migration/contract_index"
+)
+
+func indexContract(txn *badger.Txn, merkle []byte, owner string, start int64) error {
+	return txn.Set(contractKey(merkle, owner, start), nil)
+}
+
+func unindexContract(txn *badger.Txn, merkle []byte, owner string, start int64) error {
+	return txn.Delete(contractKey(merkle, owner, start))
+}
+
+// firstContract returns any contract still indexed for merkle.
+func firstContract(txn *badger.Txn, merkle []byte) (owner string, start int64, found bool, err error) {
+	prefix := contractsKey(merkle)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	it.Rewind()
+	if !it.Valid() {
+		return "", 0, false, nil
+	}
+
+	_, owner, start, err = SplitMerkle(it.Item().Key()[len(contractPrefix):])
+	if err != nil {
+		return "", 0, false, err
+	}
+	return owner, start, true, nil
+}
+
+// ListContracts returns the owner and start of every contract stored for merkle.
+func (f *FileSystem) ListContracts(merkle []byte) ([]string, []int64, error) {
+	owners := make([]string, 0)
+	starts := make([]int64, 0)
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = contractsKey(merkle)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			_, owner, start, err := SplitMerkle(it.Item().Key()[len(contractPrefix):])
+			if err != nil {
+				return err
+			}
+			owners = append(owners, owner)
+			starts = append(starts, start)
+		}
+		return nil
+	})
+
+	return owners, starts, err
+}
+
+// CopyContract stores a new contract for a file that is already on disk under another contract by
+// reusing that contract's tree, so the file doesn't have to be downloaded again.
+// Returns false when no other contract for merkle is stored.
+func (f *FileSystem) CopyContract(merkle []byte, owner string, start int64) (bool, error) {
+	copied := false
+	var delta int64
+	err := f.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(fmt.Appendf(nil, "cid/%x", merkle))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		o, s, found, err := firstContract(txn, merkle)
+		if err != nil || !found {
+			return err
+		}
+
+		item, err := txn.Get(treeKey(merkle, o, s))
+		if err != nil {
+			return fmt.Errorf("contract index points to missing tree %x/%s/%d | %w", merkle, o, s, err)
+		}
+		tree, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Set(treeKey(merkle, owner, start), tree)
+		if err != nil {
+			return err
+		}
+		err = indexContract(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
+
+		old, err := contractSize(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
+
+		meta, err := getMetadata(txn, merkle, o, s)
+		if err == nil {
+			delta = meta.Size - old
+			meta.Owner = owner
+			meta.Start = start
+			meta.Source = SourceCopy
+			meta.StoredAt = time.Now()
+			err = setMetadata(txn, meta)
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		copied = true
+		return setSchedule(txn, merkle, owner, start, 0)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	f.addStoredBytes(delta)
+	return copied, nil
+}
+
+// migrateContractIndex builds the contract index from the tree records of databases written before it existed.
+// Databases that built the index before schema versions were recorded have a marker key and are skipped.
+func (f *FileSystem) migrateContractIndex() error {
+	done := false
+	err := f.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(contractMigrationKey))
+		if err == nil {
+			done = true
+			return nil
+		}
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil || done {
+		return err
+	}
+
+	log.Info().Msg("Building contract index...")
+
+	merkles, owners, starts, err := f.ListFiles()
+	if err != nil {
+		return fmt.Errorf("cannot list contracts to index | %w", err)
+	}
+
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i, merkle := range merkles {
+		err := wb.Set(contractKey(merkle, owners[i], starts[i]), nil)
+		if err != nil {
+			return err
+		}
+	}
+	err = wb.Set([]byte(contractMigrationKey), nil)
+	if err != nil {
+		return err
+	}
+	err = wb.Flush()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("contracts", len(merkles)).Msg("Contract index built")
+	return nil
+}
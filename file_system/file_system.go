@@ -2,19 +2,12 @@ package file_system
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"strings"
 
-	"github.com/wealdtech/go-merkletree/v2/sha3"
-
 	sequoiaTypes "github.com/JackalLabs/sequoia/types"
-	treeblake3 "github.com/wealdtech/go-merkletree/v2/blake3"
-	"github.com/zeebo/blake3"
 
 	"github.com/JackalLabs/sequoia/api/types"
 
@@ -27,7 +20,6 @@ import (
 	"github.com/dgraph-io/badger/v4"
 	"github.com/ipfs/go-cid"
 	ipldFormat "github.com/ipfs/go-ipld-format"
-	"github.com/wealdtech/go-merkletree/v2"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/rs/zerolog/log"
@@ -35,132 +27,40 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-func BuildTree(buf io.Reader, chunkSize int64, proofType int64) ([]byte, []byte, int, error) {
-	size := 0
-
-	data := make([][]byte, 0)
-
-	index := 0
-
-	for {
-		b := make([]byte, chunkSize)
-		read, _ := buf.Read(b)
-
-		if read == 0 {
-			break
-		}
-
-		b = b[:read]
-
-		size += read
-
-		var h hash.Hash
-		switch proofType {
-		case sequoiaTypes.ProofTypeBlake3:
-			h = blake3.New()
-		default:
-			h = sha256.New()
-		}
-
-		_, err := fmt.Fprintf(h, "%d%x", index, b) // appending the index and the data
-		if err != nil {
-			log.Warn().Msg("failed to write to hash")
-			break
-		}
-		hashName := h.Sum(nil)
-
-		data = append(data, hashName)
-
-		index++
-	}
-
-	var h merkletree.HashType
-	switch proofType {
-	case sequoiaTypes.ProofTypeBlake3:
-		h = treeblake3.New256()
-	default:
-		h = sha3.New512()
-	}
-
-	tree, err := merkletree.NewTree(
-		merkletree.WithData(data),
-		merkletree.WithHashType(h),
-		merkletree.WithSalt(false),
-	)
+// BuildTree hashes every chunk of a file into a merkle tree and returns its root, the tree and the file size.
+func BuildTree(buf io.Reader, chunkSize int64, proofType int64) ([]byte, *Tree, int, error) {
+	builder, err := newTreeBuilder(chunkSize, proofType)
 	if err != nil {
 		return nil, nil, 0, err
 	}
 
-	r := tree.Root()
-
-	exportedTree, err := json.Marshal(tree)
+	_, err = io.Copy(builder, buf)
 	if err != nil {
 		return nil, nil, 0, err
 	}
 
-	return r, exportedTree, size, nil
+	return builder.finish()
 }
 
-func (f *FileSystem) WriteFile(reader sequoiaTypes.FileReader, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams) (size int, cid string, err error) {
+// WriteFile stores a file for a contract, the reader is only read once.
+func (f *FileSystem) WriteFile(reader io.Reader, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, source string) (size int, cid string, err error) {
 	log.Info().Msg(fmt.Sprintf("Writing %x to disk", merkle))
-	root, exportedTree, s, err := BuildTree(reader, chunkSize, proofType)
+
+	// keep garbage collection from sweeping the new blocks before the cid is recorded
+	done := f.startWrite()
+	defer done()
+
+	ctx, err := f.placeFile(context.Background(), merkle)
 	if err != nil {
-		log.Error().Err(fmt.Errorf("cannot build tree | %w", err))
 		return 0, "", err
 	}
-	size = s
-	if hex.EncodeToString(merkle) != hex.EncodeToString(root) {
-		return 0, "", fmt.Errorf("merkle does not match %x != %x", merkle, root)
-	}
 
-	// Seek back to the beginning of the file so it can be read again
-	_, err = reader.Seek(0, io.SeekStart)
+	n, tree, size, err := f.ingest(ctx, reader, merkle, chunkSize, proofType, ipfsParams)
 	if err != nil {
-		return 0, "", fmt.Errorf("cannot seek to beginning of file | %w", err)
-	}
-
-	var n ipldFormat.Node
-	if proofType == sequoiaTypes.ProofTypeIPFSFolder {
-		folderNode := unixfs.EmptyDirNode()
-		// Read entire reader into a single byte slice called data
-		data, err := io.ReadAll(reader)
-		if err != nil {
-			return 0, "", err
-		}
-		err = folderNode.UnmarshalJSON(data)
-		if err != nil {
-			return 0, "", err
-		}
-
-		err = f.ipfs.Add(context.Background(), folderNode)
-		if err != nil {
-			return 0, "", err
-		}
-		n = folderNode
-	} else {
-		n, err = f.ipfs.AddFile(context.Background(), reader, ipfsParams)
-		if err != nil {
-			return 0, "", err
-		}
+		return 0, "", err
 	}
 
-	err = f.db.Update(func(txn *badger.Txn) error {
-		err = txn.Set(treeKey(merkle, owner, start), exportedTree)
-		if err != nil {
-			e := fmt.Errorf("cannot set tree %x | %w", merkle, err)
-			log.Error().Err(e)
-			return e
-		}
-
-		err = txn.Set(fmt.Appendf(nil, "cid/%x", merkle), []byte(n.Cid().String()))
-		if err != nil {
-			e := fmt.Errorf("cannot set cid %x | %w", merkle, err)
-			log.Error().Err(e)
-			return e
-		}
-
-		return nil
-	})
+	err = f.recordFile(ctx, n.Cid(), tree, size, merkle, owner, start, chunkSize, proofType, ipfsParams, source)
 	if err != nil {
 		return 0, "", err
 	}
@@ -169,63 +69,26 @@ func (f *FileSystem) WriteFile(reader sequoiaTypes.FileReader, merkle []byte, ow
 	return size, n.Cid().String(), nil
 }
 
-func (f *FileSystem) WriteFileWithProgress(reader sequoiaTypes.FileReader, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, tracker *types.UploadResponseV2) (size int, cid string, err error) {
+func (f *FileSystem) WriteFileWithProgress(reader io.Reader, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, source string, tracker *types.UploadResponseV2) (size int, cid string, err error) {
 	log.Info().Msg(fmt.Sprintf("Writing %x to disk", merkle))
-	root, exportedTree, s, err := BuildTree(reader, chunkSize, proofType)
+	tracker.Progress = 50
+
+	// keep garbage collection from sweeping the new blocks before the cid is recorded
+	done := f.startWrite()
+	defer done()
+
+	ctx, err := f.placeFile(context.Background(), merkle)
 	if err != nil {
-		log.Error().Err(fmt.Errorf("cannot build tree | %w", err))
 		return 0, "", err
 	}
-	size = s
-	if hex.EncodeToString(merkle) != hex.EncodeToString(root) {
-		return 0, "", fmt.Errorf("merkle does not match %x != %x", merkle, root)
-	}
-	tracker.Progress = 60
 
-	// Seek back to the beginning of the file so it can be read again
-	_, err = reader.Seek(0, io.SeekStart)
+	n, tree, size, err := f.ingest(ctx, reader, merkle, chunkSize, proofType, ipfsParams)
 	if err != nil {
-		return 0, "", fmt.Errorf("cannot seek to beginning of file | %w", err)
-	}
-
-	tracker.Progress = 70
-	var n ipldFormat.Node
-	if proofType == sequoiaTypes.ProofTypeIPFSFolder {
-		data, err := io.ReadAll(reader)
-		if err != nil {
-			return 0, "", err
-		}
-
-		folderNode := unixfs.EmptyDirNode()
-		err = folderNode.UnmarshalJSON(data)
-		if err != nil {
-			return 0, "", err
-		}
-
-		err = f.ipfs.Add(context.Background(), folderNode)
-		if err != nil {
-			return 0, "", err
-		}
-		n = folderNode
-	} else {
-		n, err = f.ipfs.AddFile(context.Background(), reader, ipfsParams)
-		if err != nil {
-			return 0, "", err
-		}
+		return 0, "", err
 	}
 	tracker.Progress = 90
-	err = f.db.Update(func(txn *badger.Txn) error {
-		err = txn.Set(treeKey(merkle, owner, start), exportedTree)
-		if err != nil {
-			e := fmt.Errorf("cannot set tree %x | %w", merkle, err)
-			log.Error().Err(e)
-			return e
-		}
-
-		err = txn.Set(fmt.Appendf(nil, "cid/%x", merkle), []byte(n.Cid().String()))
 
-		return nil
-	})
+	err = f.recordFile(ctx, n.Cid(), tree, size, merkle, owner, start, chunkSize, proofType, ipfsParams, source)
 	if err != nil {
 		return 0, "", err
 	}
@@ -237,6 +100,34 @@ func (f *FileSystem) WriteFileWithProgress(reader sequoiaTypes.FileReader, merkl
 	return size, n.Cid().String(), nil
 }
 
+// setCid records the root CID of a file. If the file was stored under a different root before,
+// that root is flagged for garbage collection since nothing points to it anymore.
+func setCid(txn *badger.Txn, merkle []byte, root string) error {
+	key := fmt.Appendf(nil, "cid/%x", merkle)
+
+	item, err := txn.Get(key)
+	if err == nil {
+		var old string
+		err = item.Value(func(val []byte) error {
+			old = string(val)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if old != root {
+			err = markPendingGC(txn, old)
+			if err != nil {
+				return err
+			}
+		}
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+
+	return txn.Set(key, []byte(root))
+}
+
 func (f *FileSystem) CreateIPFSFolder(childCIDs map[string]cid.Cid) (node ipldFormat.Node, err error) {
 	n, err := f.GenIPFSFolderData(childCIDs)
 	if err != nil {
@@ -280,127 +171,113 @@ func (f *FileSystem) DeleteFile(merkle []byte, owner string, start int64) error
 	return nil
 }
 
+// removeContract drops a single contract and, in the same transaction, the file's cid record once
+// the contract index shows no other contract still needs it.
 func (f *FileSystem) removeContract(merkle []byte, owner string, start int64) error {
+	deleted := false
+	orphaned := false
+	var size int64
 	err := f.db.Update(func(txn *badger.Txn) error {
-		err := txn.Delete(treeKey(merkle, owner, start))
+		var err error
+		size, err = contractSize(txn, merkle, owner, start)
 		if err != nil {
 			return err
 		}
-		return nil
-	})
-	if err != nil {
-		log.Warn().Err(err)
-	}
 
-	found := false
-	// check for other contracts with same file
-	err = f.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.IteratorOptions{Prefix: merkle})
-		defer it.Close()
-		it.Rewind()
+		err = txn.Delete(treeKey(merkle, owner, start))
+		if err != nil {
+			return err
+		}
+		err = unindexContract(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
+		err = txn.Delete(metaKey(merkle, owner, start))
+		if err != nil {
+			return err
+		}
+		err = txn.Delete(scrubKey(merkle, owner, start))
+		if err != nil {
+			return err
+		}
+		err = removeSchedule(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
+		err = deleteProofHistory(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
 
-		found = it.Valid()
-		return nil
+		// check for other contracts with same file
+		_, _, found, err := firstContract(txn, merkle)
+		if err != nil || found {
+			return err
+		}
+
+		log.Debug().Hex("merkle", merkle).Msg("zero contracts tied to the file")
+		orphaned = true
+		deleted, err = deleteFile(txn, merkle)
+		return err
 	})
 	if err != nil {
-		log.Warn().Err(err)
+		return err
 	}
 
-	if !found {
-		log.Debug().Hex("merkle", merkle).Msg("zero contracts tied to the file")
-		return f.deleteFile(merkle)
+	if orphaned {
+		err = f.deleteTreeNodes(merkle)
+		if err != nil {
+			log.Warn().Err(err).Hex("merkle", merkle).Msg("could not remove tree of deleted file")
+		}
 	}
 
+	f.cache.dropMerkle(merkle)
+	f.addStoredBytes(-size)
+	if deleted {
+		fileCount.Dec()
+	}
 	return nil
 }
 
-func (f *FileSystem) deleteFile(merkle []byte) error {
-	ctx := context.Background()
-	log.Info().Msg(fmt.Sprintf("Removing %x from disk...", merkle))
-	fileCount.Dec()
-
-	// find ipfs cid
-	fcid := ""
-	err := f.db.View(func(txn *badger.Txn) error {
-		b, err := txn.Get(fmt.Appendf(nil, "cid/%x", merkle))
-		if err != nil {
-			return err
-		}
-
-		return b.Value(func(val []byte) error {
-			fcid = string(val)
-			return nil
-		})
-	})
+// deleteFile removes the cid record of a file. Its blocks are left for the garbage collector,
+// which only removes the ones that no other stored file still links to.
+func deleteFile(txn *badger.Txn, merkle []byte) (bool, error) {
+	key := fmt.Appendf(nil, "cid/%x", merkle)
+	item, err := txn.Get(key)
 	if err != nil {
-		log.Warn().Err(err)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
 	}
 
-	err = f.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(fmt.Appendf(nil, "cid/%x", merkle))
+	var fcid string
+	err = item.Value(func(val []byte) error {
+		fcid = string(val)
+		return nil
 	})
 	if err != nil {
-		log.Warn().Err(err)
-	}
-
-	c, err := cid.Decode(fcid)
-	if err != nil {
-		return err
+		return false, err
 	}
 
-	return removeDAGNode(ctx, f.ipfs, c)
-}
-
-func collectDAGNodes(ctx context.Context, dagService *ipfslite.Peer, rootCid cid.Cid) (map[cid.Cid]struct{}, error) {
-	nodesToVisit := []cid.Cid{rootCid}
-	visitedNodes := make(map[cid.Cid]struct{})
-
-	for len(nodesToVisit) > 0 {
-		// Pop the next CID to process
-		currentCid := nodesToVisit[0]
-		nodesToVisit = nodesToVisit[1:]
-
-		// Skip if already visited
-		if _, alreadyVisited := visitedNodes[currentCid]; alreadyVisited {
-			continue
-		}
-
-		// Mark as visited
-		visitedNodes[currentCid] = struct{}{}
-
-		// Get the node
-		node, err := dagService.Get(ctx, currentCid)
-		if err != nil {
-			return nil, err
-		}
+	log.Info().Msg(fmt.Sprintf("Removing %x from disk...", merkle))
 
-		// Add all links to the queue
-		for _, link := range node.Links() {
-			if _, alreadyVisited := visitedNodes[link.Cid]; !alreadyVisited {
-				nodesToVisit = append(nodesToVisit, link.Cid)
-			}
-		}
+	err = txn.Delete(key)
+	if err != nil {
+		return false, err
 	}
 
-	return visitedNodes, nil
-}
-
-func removeDAGNode(ctx context.Context, dagService *ipfslite.Peer, c cid.Cid) error {
-	// Collect all nodes in the DAG using the non-recursive function
-	nodesToRemove, err := collectDAGNodes(ctx, dagService, c)
+	err = clearTier(txn, merkle)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	bstore := dagService.BlockStore()
-
-	for cidToRemove := range nodesToRemove {
-		if err := bstore.DeleteBlock(ctx, cidToRemove); err != nil {
-			return err
-		}
+	err = txn.Delete(diskKey(merkle))
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	return true, markPendingGC(txn, fcid)
 }
 
 func (f *FileSystem) ListFiles() ([][]byte, []string, []int64, error) {
@@ -498,33 +375,21 @@ func (f *FileSystem) Dump() (map[string]string, error) {
 	return files, err
 }
 
-func (f *FileSystem) GetFileTreeByChunk(merkle []byte, owner string, start int64, chunkToLoad int, chunkSize int, proofType int64) (*merkletree.MerkleTree, []byte, error) {
-	tree := treeKey(merkle, owner, start)
-
-	var newTree merkletree.MerkleTree
-
-	err := f.db.View(func(txn *badger.Txn) error {
-		t, err := txn.Get(tree)
-		if err != nil {
-			return fmt.Errorf("cannot find tree structure | %w", err)
-		}
-		err = t.Value(func(val []byte) error {
-			err := json.Unmarshal(val, &newTree)
-			if err != nil {
-				return fmt.Errorf("can't unmarshal tree | %w", err)
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+func (f *FileSystem) GetFileTreeByChunk(merkle []byte, owner string, start int64, chunkToLoad int, chunkSize int, proofType int64) (sequoiaTypes.ProofTree, []byte, error) {
+	newTree, err := f.loadTree(merkle, owner, start)
 	if err != nil {
 		return nil, nil, fmt.Errorf("cannot get tree | %w", err)
 	}
 
+	// the file was stored with these, they win over whatever the caller looked up
+	meta, err := f.GetFileMetadata(merkle, owner, start)
+	if err == nil {
+		proofType = meta.ProofType
+		if meta.ChunkSize > 0 {
+			chunkSize = int(meta.ChunkSize)
+		}
+	}
+
 	fcid := ""
 	err = f.db.View(func(txn *badger.Txn) error {
 		b, err := txn.Get(fmt.Appendf(nil, "cid/%x", merkle))
@@ -547,9 +412,16 @@ func (f *FileSystem) GetFileTreeByChunk(merkle []byte, owner string, start int64
 		return nil, nil, fmt.Errorf("failed to decode cid: %s | %w", fcid, err)
 	}
 
+	chunkKey := fmt.Sprintf("%s/%x/%d/%d/%d", cacheChunk, merkle, proofType, chunkSize, chunkToLoad)
+	if v, ok := f.cache.get(cacheChunk, chunkKey); ok {
+		return newTree, v.([]byte), nil
+	}
+
+	ctx := f.fileContext(context.Background(), merkle)
+
 	var chunkOut []byte
 	if proofType == sequoiaTypes.ProofTypeIPFSFolder {
-		n, err := f.ipfs.Get(context.Background(), c)
+		n, err := f.ipfs.Get(ctx, c)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to get node chunk for cid: %s | %w", fcid, err)
 		}
@@ -559,7 +431,7 @@ func (f *FileSystem) GetFileTreeByChunk(merkle []byte, owner string, start int64
 		}
 		chunkOut = data
 	} else {
-		chunkOut, err = f.ipfs.GetFileChunk(context.Background(), c, chunkToLoad, chunkSize)
+		chunkOut, err = f.ipfs.GetFileChunk(ctx, c, chunkToLoad, chunkSize)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to get chunk from unixfs %w", err)
 		}
@@ -569,7 +441,13 @@ func (f *FileSystem) GetFileTreeByChunk(merkle []byte, owner string, start int64
 		return nil, nil, errors.New("chunk is nil, something is wrong")
 	}
 
-	return &newTree, chunkOut, nil
+	// a chunk read from damaged blocks must not outlive the repair, only cache what proves
+	valid, err := newTree.proves(chunkToLoad, chunkOut, proofType)
+	if err == nil && valid {
+		f.cache.add(chunkKey, merkle, chunkOut, int64(len(chunkOut)))
+	}
+
+	return newTree, chunkOut, nil
 }
 
 func (f *FileSystem) CheckTree(merkle []byte, owner string, start int64) (bool, error) {
@@ -618,10 +496,15 @@ func (f *FileSystem) GetFileData(merkle []byte) (io.ReadSeekCloser, error) {
 		return nil, fmt.Errorf("cannot decode cid '%s': %w", fcid, err)
 	}
 
-	rsc, err := f.ipfs.GetFile(context.Background(), c)
+	return f.getRootData(f.fileContext(context.Background(), merkle), c)
+}
+
+// getRootData reads the file a root CID points to, folders are returned as their JSON encoding.
+func (f *FileSystem) getRootData(ctx context.Context, c cid.Cid) (io.ReadSeekCloser, error) {
+	rsc, err := f.ipfs.GetFile(ctx, c)
 	if err != nil {
 		if strings.Contains(err.Error(), "is a directory") {
-			node, err := f.ipfs.Get(context.Background(), c)
+			node, err := f.ipfs.Get(ctx, c)
 			if err != nil {
 				return nil, fmt.Errorf("cannot get folder for cid '%s': %w", c.String(), err)
 			}
@@ -2,14 +2,17 @@ package file_system
 
 //nolint:all
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"math/rand"
 	"net/http"
 	"testing"
+	"time"
 
 	sequoiaTypes "github.com/JackalLabs/sequoia/types"
 
@@ -17,15 +20,22 @@ import (
 	ipfslite "github.com/hsanjuan/ipfs-lite"
 	"github.com/jackalLabs/canine-chain/v5/x/storage/types"
 
+	"github.com/JackalLabs/sequoia/config"
 	"github.com/JackalLabs/sequoia/ipfs"
 	"github.com/JackalLabs/sequoia/logger"
 	"github.com/JackalLabs/sequoia/proofs"
 	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/require"
 	"github.com/wealdtech/go-merkletree/v2"
+	treeblake3 "github.com/wealdtech/go-merkletree/v2/blake3"
 	"github.com/wealdtech/go-merkletree/v2/sha3"
+	"github.com/zeebo/blake3"
 
 	_ "net/http/pprof"
 )
@@ -80,7 +90,7 @@ func BenchmarkFileWrites(b *testing.B) {
 				root, _, _, err := BuildTree(buf2, 10240, 0)
 				require.NoError(b, err)
 
-				_, _, err = f.WriteFile(buf, root, "file_owner", 0, 10240, 0, nil)
+				_, _, err = f.WriteFile(buf, root, "file_owner", 0, 10240, 0, nil, SourceUpload)
 				require.NoError(b, err)
 
 			}
@@ -115,7 +125,7 @@ func TestWriteFile(t *testing.T) {
 	m, err := hex.DecodeString(merkle)
 	require.NoError(t, err)
 
-	size, _, err := f.WriteFile(buf, m, "file_owner", 0, 1024, 0, nil)
+	size, _, err := f.WriteFile(buf, m, "file_owner", 0, 1024, 0, nil, SourceUpload)
 
 	require.NoError(t, err)
 
@@ -139,6 +149,14 @@ func TestWriteFile(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, 1, len(ms))
+
+	meta, err := f.GetFileMetadata(m, "file_owner", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), meta.Size)
+	require.Equal(t, int64(1024), meta.ChunkSize)
+	require.Equal(t, int64(0), meta.ProofType)
+	require.Equal(t, SourceUpload, meta.Source)
+	require.NotEmpty(t, meta.CID)
 }
 
 func TestWriteFileWithDomain(t *testing.T) {
@@ -166,7 +184,7 @@ func TestWriteFileWithDomain(t *testing.T) {
 	m, err := hex.DecodeString(merkle)
 	require.NoError(t, err)
 
-	size, _, err := f.WriteFile(buf, m, "file_owner", 0, 1024, 0, nil)
+	size, _, err := f.WriteFile(buf, m, "file_owner", 0, 1024, 0, nil, SourceUpload)
 
 	require.NoError(t, err)
 
@@ -230,7 +248,7 @@ func TestWriteAndProveFiles(t *testing.T) {
 	owner := "file_owner"
 	var start int64 = 0
 
-	_, _, err = f.WriteFile(b2, root, owner, start, chunkSize, 0, nil)
+	_, _, err = f.WriteFile(b2, root, owner, start, chunkSize, 0, nil, SourceUpload)
 	require.NoError(t, err)
 
 	ms, _, _, err := f.ListFiles()
@@ -289,7 +307,7 @@ func TestWriteFileWithParams(t *testing.T) {
 	m, err := hex.DecodeString(merkle)
 	require.NoError(t, err)
 
-	size, cid1, err := f.WriteFile(buf, m, "file_owner", 0, 1024, 0, nil)
+	size, cid1, err := f.WriteFile(buf, m, "file_owner", 0, 1024, 0, nil, SourceUpload)
 	require.NoError(t, err)
 	require.Equal(t, len(data), size)
 
@@ -313,7 +331,7 @@ func TestWriteFileWithParams(t *testing.T) {
 		Note: string(js),
 	}
 
-	size, cid2, err := f.WriteFile(buf2, m, "file_owner", 20, 1024, 0, utils.GetIPFSParams(&uf))
+	size, cid2, err := f.WriteFile(buf2, m, "file_owner", 20, 1024, 0, utils.GetIPFSParams(&uf), SourceUpload)
 	require.NoError(t, err)
 	require.Equal(t, len(data), size)
 
@@ -342,7 +360,7 @@ func TestWriteFileWithParams(t *testing.T) {
 		Note: string(js),
 	}
 
-	size, cid3, err := f.WriteFile(buf3, m, "file_owner", 20, 1024, 0, utils.GetIPFSParams(&uf))
+	size, cid3, err := f.WriteFile(buf3, m, "file_owner", 20, 1024, 0, utils.GetIPFSParams(&uf), SourceUpload)
 	require.NoError(t, err)
 	require.Equal(t, len(data), size)
 
@@ -380,3 +398,1450 @@ func TestBuildTree(t *testing.T) {
 
 	require.Equal(t, "469a83c529d5aeebf15dc90c1bdacda1b77fd17b2c0a63f698d5f6381efd1c6a", hexRoot)
 }
+
+func TestCompactTreeMatchesMerkletree(t *testing.T) {
+	r := require.New(t)
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	f := &FileSystem{db: db}
+
+	var chunkSize int64 = 16
+
+	for _, proofType := range []int64{sequoiaTypes.ProofTypeDefault, sequoiaTypes.ProofTypeBlake3} {
+		for _, leaves := range []int{1, 2, 3, 5, 8, 300} {
+			data := make([]byte, leaves*int(chunkSize)-3)
+			//nolint:all
+			rand.Read(data)
+
+			hashes := make([][]byte, 0, leaves)
+			for i := 0; i < leaves; i++ {
+				chunk := data[i*int(chunkSize) : min((i+1)*int(chunkSize), len(data))]
+				var h hash.Hash = sha256.New()
+				if proofType == sequoiaTypes.ProofTypeBlake3 {
+					h = blake3.New()
+				}
+				_, err := fmt.Fprintf(h, "%d%x", i, chunk)
+				r.NoError(err)
+				hashes = append(hashes, h.Sum(nil))
+			}
+
+			var treeHash merkletree.HashType = sha3.New512()
+			if proofType == sequoiaTypes.ProofTypeBlake3 {
+				treeHash = treeblake3.New256()
+			}
+			expected, err := merkletree.NewTree(
+				merkletree.WithData(hashes),
+				merkletree.WithHashType(treeHash),
+				merkletree.WithSalt(false),
+			)
+			r.NoError(err)
+
+			root, tree, _, err := BuildTree(sequoiaTypes.NewBytesSeeker(data), chunkSize, proofType)
+			r.NoError(err)
+			r.Equal(expected.Root(), root)
+
+			// the compact tree read back from its pages
+			merkle := root
+			r.NoError(f.saveTreeNodes(merkle, tree))
+			r.NoError(db.Update(func(txn *badger.Txn) error {
+				return txn.Set(treeKey(merkle, "compact", 0), tree.header())
+			}))
+			stored, err := f.loadTree(merkle, "compact", 0)
+			r.NoError(err)
+			r.Nil(stored.nodes)
+
+			// a JSON tree written before the compact format, converted on first load
+			legacy, err := json.Marshal(expected)
+			r.NoError(err)
+			r.NoError(db.Update(func(txn *badger.Txn) error {
+				return txn.Set(treeKey(merkle, "legacy", 0), legacy)
+			}))
+			migrated, err := f.loadTree(merkle, "legacy", 0)
+			r.NoError(err)
+			r.Equal(expected.Root(), migrated.Root())
+
+			for i := 0; i < leaves; i++ {
+				want, err := expected.GenerateProofWithIndex(uint64(i), 0)
+				r.NoError(err)
+
+				for _, pt := range []sequoiaTypes.ProofTree{tree, stored, migrated} {
+					got, err := pt.GenerateProofWithIndex(uint64(i), 0)
+					r.NoError(err)
+					r.Equal(want.Index, got.Index)
+					r.Equal(len(want.Hashes), len(got.Hashes))
+					for j := range want.Hashes {
+						r.Equal(want.Hashes[j], got.Hashes[j], "leaves %d index %d level %d", leaves, i, j)
+					}
+				}
+			}
+
+			_, err = stored.GenerateProofWithIndex(uint64(leaves), 0)
+			r.Error(err)
+
+			err = db.View(func(txn *badger.Txn) error {
+				item, err := txn.Get(treeKey(merkle, "legacy", 0))
+				if err != nil {
+					return err
+				}
+				return item.Value(func(val []byte) error {
+					r.False(isLegacyTree(val))
+					return nil
+				})
+			})
+			r.NoError(err)
+		}
+	}
+}
+
+func TestRemoveLargeTree(t *testing.T) {
+	r := require.New(t)
+
+	// a small memtable keeps transactions small enough for a test sized tree to overflow one
+	opts := badger.DefaultOptions("/tmp/badger/s").WithMemTableSize(1 << 18).WithValueThreshold(1 << 10)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	f := &FileSystem{db: db}
+
+	data := make([]byte, 1024*1024)
+	//nolint:all
+	rand.Read(data)
+	merkle, tree, _, err := BuildTree(bytes.NewReader(data), 16, 0)
+	r.NoError(err)
+
+	r.NoError(f.saveTreeNodes(merkle, tree))
+	err = db.Update(func(txn *badger.Txn) error {
+		err := txn.Set(treeKey(merkle, "owner", 0), tree.header())
+		if err != nil {
+			return err
+		}
+		return indexContract(txn, merkle, "owner", 0)
+	})
+	r.NoError(err)
+
+	r.NoError(f.removeContract(merkle, "owner", 0))
+
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: treeNodesKey(merkle)})
+		defer it.Close()
+		it.Rewind()
+		r.False(it.Valid())
+		return nil
+	})
+	r.NoError(err)
+}
+
+func TestProofSchedule(t *testing.T) {
+	r := require.New(t)
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	f := &FileSystem{db: db}
+
+	r.NoError(f.ScheduleProof([]byte("a"), "owner", 0, 300))
+	r.NoError(f.ScheduleProof([]byte("b"), "owner", 0, 100))
+	r.NoError(f.ScheduleProof([]byte("c"), "owner", 5, 200))
+	r.NoError(f.ScheduleProof([]byte("d"), "owner", 0, 1000))
+
+	due := func(height int64) []string {
+		found := make([]string, 0)
+		err := f.ProcessDueFiles(height, func(merkle []byte, owner string, start int64) {
+			found = append(found, fmt.Sprintf("%s/%d", merkle, start))
+		})
+		r.NoError(err)
+		return found
+	}
+
+	r.Equal([]string{"b/0", "c/5", "a/0"}, due(300))
+	r.Empty(due(99))
+
+	// rescheduling replaces the old entry instead of adding a second one
+	r.NoError(f.ScheduleProof([]byte("b"), "owner", 0, 500))
+	r.Equal([]string{"c/5", "a/0"}, due(300))
+
+	h, found, err := f.GetProofDue([]byte("b"), "owner", 0)
+	r.NoError(err)
+	r.True(found)
+	r.Equal(int64(500), h)
+
+	err = db.Update(func(txn *badger.Txn) error {
+		return removeSchedule(txn, []byte("c"), "owner", 5)
+	})
+	r.NoError(err)
+	r.Equal([]string{"a/0", "b/0"}, due(999))
+
+	_, found, err = f.GetProofDue([]byte("c"), "owner", 5)
+	r.NoError(err)
+	r.False(found)
+}
+
+func TestVerifyFile(t *testing.T) {
+	r := require.New(t)
+
+	opts := badger.DefaultOptions("/tmp/badger/d")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(context.Background(), db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	token := make([]byte, 1024*512)
+	//nolint:all
+	rand.Read(token)
+
+	root, _, _, err := BuildTree(sequoiaTypes.NewBytesSeeker(token), chunkSize, 0)
+	r.NoError(err)
+
+	_, c, err := f.WriteFile(sequoiaTypes.NewBytesSeeker(token), root, "file_owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+
+	status, reason := f.VerifyFile(context.Background(), root, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+
+	status, _ = f.VerifyFile(context.Background(), []byte("not a stored merkle"), chunkSize)
+	r.Equal(ScrubMissing, status)
+
+	rootCid, err := cid.Decode(c)
+	r.NoError(err)
+	node, err := f.ipfs.Get(context.Background(), rootCid)
+	r.NoError(err)
+	r.Greater(len(node.Links()), 1)
+
+	// overwrite one chunk with data that no longer hashes to its cid
+	bs := f.ipfs.BlockStore()
+	damaged := node.Links()[1].Cid
+	good, err := bs.Get(context.Background(), damaged)
+	r.NoError(err)
+	r.NoError(bs.DeleteBlock(context.Background(), damaged))
+	bad, err := blocks.NewBlockWithCid([]byte("not the chunk"), damaged)
+	r.NoError(err)
+	r.NoError(bs.Put(context.Background(), bad))
+
+	// reporting leaves the damaged block where it is
+	status, reason = f.VerifyFile(context.Background(), root, chunkSize)
+	r.Equal(ScrubCorrupt, status)
+	r.Contains(reason, "1 blocks do not match")
+	has, err := bs.Has(context.Background(), damaged)
+	r.NoError(err)
+	r.True(has)
+
+	record := NewScrubber(f, chunkSize, time.Hour, 0, nil).scrubFile(root, "file_owner", 0)
+	r.Equal(ScrubCorrupt, record.Status)
+	r.False(record.Repaired)
+	has, err = bs.Has(context.Background(), damaged)
+	r.NoError(err)
+	r.True(has)
+
+	// a repair only starts once the damaged block is gone
+	repair := func(merkle []byte, owner string, start int64) error {
+		has, err := bs.Has(context.Background(), damaged)
+		r.NoError(err)
+		r.False(has)
+		return bs.Put(context.Background(), good)
+	}
+	record = NewScrubber(f, chunkSize, time.Hour, 0, repair).scrubFile(root, "file_owner", 0)
+	r.Equal(ScrubOK, record.Status, record.Reason)
+	r.True(record.Repaired)
+
+	// drop one chunk out from under the file
+	r.NoError(bs.DeleteBlock(context.Background(), node.Links()[0].Cid))
+
+	status, reason = f.VerifyFile(context.Background(), root, chunkSize)
+	r.Equal(ScrubMissing, status)
+	r.Contains(reason, "1 blocks missing")
+}
+
+func TestGarbageCollectionKeepsSharedBlocks(t *testing.T) {
+	r := require.New(t)
+
+	opts := badger.DefaultOptions("/tmp/badger/c")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(context.Background(), db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+
+	// the second file starts with the first one, so the leading unixfs chunks are shared
+	first := make([]byte, 1024*1024)
+	//nolint:all
+	rand.Read(first)
+	second := append(append([]byte{}, first...), []byte("and a little more")...)
+
+	firstRoot, _, _, err := BuildTree(sequoiaTypes.NewBytesSeeker(first), chunkSize, 0)
+	r.NoError(err)
+	secondRoot, _, _, err := BuildTree(sequoiaTypes.NewBytesSeeker(second), chunkSize, 0)
+	r.NoError(err)
+
+	_, _, err = f.WriteFile(sequoiaTypes.NewBytesSeeker(first), firstRoot, "file_owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+	_, _, err = f.WriteFile(sequoiaTypes.NewBytesSeeker(second), secondRoot, "file_owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+
+	res, err := f.CollectGarbage(context.Background(), true)
+	r.NoError(err)
+	r.Empty(res.Unused)
+
+	r.NoError(f.DeleteFile(firstRoot, "file_owner", 0))
+
+	pending, err := f.pendingGC()
+	r.NoError(err)
+	r.Len(pending, 1)
+
+	res, err = f.CollectGarbage(context.Background(), true)
+	r.NoError(err)
+	r.NotEmpty(res.Unused)
+	r.Zero(res.Removed)
+
+	res, err = f.CollectGarbage(context.Background(), false)
+	r.NoError(err)
+	r.Equal(len(res.Unused), res.Removed)
+
+	pending, err = f.pendingGC()
+	r.NoError(err)
+	r.Empty(pending)
+
+	status, reason := f.VerifyFile(context.Background(), secondRoot, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+
+	// a write in progress puts the sweep off without holding up other writes
+	done := f.startWrite()
+	f.startWrite()()
+	_, err = f.CollectGarbage(context.Background(), false)
+	r.ErrorIs(err, errWritesInProgress)
+	done()
+
+	_, err = f.CollectGarbage(context.Background(), false)
+	r.NoError(err)
+}
+
+func TestGarbageCollectionKeepsDamagedFiles(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/r")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	// small chunks so the file needs a layer of nodes between its root and its leaves
+	var chunkSize int64 = 1024
+	params := &ipfslite.AddParams{Layout: "balanced", Chunker: "size-256", RawLeaves: true, HashFun: "sha2-256"}
+	data := make([]byte, 256*200)
+	//nolint:all
+	rand.Read(data)
+
+	merkle, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+	r.NoError(err)
+	_, fcid, err := f.WriteFile(bytes.NewReader(data), merkle, "file_owner", 0, chunkSize, 0, params, SourceUpload)
+	r.NoError(err)
+
+	root, err := f.ipfs.Get(ctx, cid.MustParse(fcid))
+	r.NoError(err)
+	r.NotEmpty(root.Links())
+	lost := root.Links()[0].Cid
+	r.NotEqual(cid.Raw, lost.Type())
+	node, err := f.ipfs.Get(ctx, lost)
+	r.NoError(err)
+	r.NotEmpty(node.Links())
+	leaf := node.Links()[0].Cid
+
+	before, err := f.listBlocks(ctx)
+	r.NoError(err)
+
+	// the leaves below a lost node still belong to the file, nothing is swept while they can't be marked
+	bs := f.ipfs.BlockStore()
+	r.NoError(bs.DeleteBlock(ctx, lost))
+
+	_, err = f.CollectGarbage(ctx, false)
+	r.ErrorIs(err, errMissingNode)
+
+	after, err := f.listBlocks(ctx)
+	r.NoError(err)
+	r.Len(after, len(before)-1)
+	has, err := bs.Has(ctx, leaf)
+	r.NoError(err)
+	r.True(has)
+}
+
+func TestContractIndex(t *testing.T) {
+	r := require.New(t)
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	f := &FileSystem{db: db}
+
+	merkle := []byte("shared merkle")
+	other := []byte("other merkle")
+
+	// a database from before the index existed, only tree and cid records
+	err = db.Update(func(txn *badger.Txn) error {
+		for _, owner := range []string{"alice", "bob"} {
+			err := txn.Set(treeKey(merkle, owner, 10), []byte("tree"))
+			if err != nil {
+				return err
+			}
+		}
+		err := txn.Set(treeKey(other, "alice", 20), []byte("other tree"))
+		if err != nil {
+			return err
+		}
+		err = txn.Set(fmt.Appendf(nil, "cid/%x", merkle), []byte("shared cid"))
+		if err != nil {
+			return err
+		}
+		return txn.Set(fmt.Appendf(nil, "cid/%x", other), []byte("other cid"))
+	})
+	r.NoError(err)
+
+	r.NoError(f.migrateContractIndex())
+	r.NoError(f.migrateContractIndex()) // already done, must be a no-op
+
+	owners, starts, err := f.ListContracts(merkle)
+	r.NoError(err)
+	r.Equal([]string{"alice", "bob"}, owners)
+	r.Equal([]int64{10, 10}, starts)
+
+	// another owner storing the same file reuses the existing tree
+	copied, err := f.CopyContract(merkle, "carol", 30)
+	r.NoError(err)
+	r.True(copied)
+	found, err := f.CheckTree(merkle, "carol", 30)
+	r.NoError(err)
+	r.True(found)
+
+	copied, err = f.CopyContract([]byte("not stored"), "carol", 30)
+	r.NoError(err)
+	r.False(copied)
+
+	// the file stays on disk while any contract still needs it
+	r.NoError(f.DeleteFile(merkle, "alice", 10))
+	r.NoError(f.DeleteFile(merkle, "bob", 10))
+	_, err = f.GetCIDFromMerkle(merkle)
+	r.NoError(err)
+
+	r.NoError(f.DeleteFile(merkle, "carol", 30))
+	_, err = f.GetCIDFromMerkle(merkle)
+	r.ErrorIs(err, badger.ErrKeyNotFound)
+
+	_, err = f.GetCIDFromMerkle(other)
+	r.NoError(err)
+
+	pending, err := f.pendingGC()
+	r.NoError(err)
+	r.Len(pending, 1)
+}
+
+func TestWriteFileSinglePass(t *testing.T) {
+	r := require.New(t)
+
+	opts := badger.DefaultOptions("/tmp/badger/b")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(context.Background(), db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	data := make([]byte, 300*1024+17)
+	//nolint:all
+	rand.Read(data)
+
+	root, _, _, err := BuildTree(sequoiaTypes.NewBytesSeeker(data), chunkSize, 0)
+	r.NoError(err)
+
+	// a plain stream that cannot be rewound
+	size, c, err := f.WriteFile(io.MultiReader(bytes.NewReader(data)), root, "file_owner", 0, chunkSize, 0, nil, SourceStray)
+	r.NoError(err)
+	r.Equal(len(data), size)
+	r.NotEmpty(c)
+
+	status, reason := f.VerifyFile(context.Background(), root, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+
+	// a file that doesn't match its merkle is rejected and its blocks are left for collection
+	other := make([]byte, 50*1024)
+	//nolint:all
+	rand.Read(other)
+
+	_, _, err = f.WriteFile(io.MultiReader(bytes.NewReader(other)), root, "other_owner", 0, chunkSize, 0, nil, SourceStray)
+	r.ErrorContains(err, "merkle does not match")
+
+	found, err := f.CheckTree(root, "other_owner", 0)
+	r.NoError(err)
+	r.False(found)
+
+	pending, err := f.pendingGC()
+	r.NoError(err)
+	r.Len(pending, 1)
+
+	res, err := f.CollectGarbage(context.Background(), false)
+	r.NoError(err)
+	r.NotZero(res.Removed)
+
+	status, reason = f.VerifyFile(context.Background(), root, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+}
+
+func TestCARRoundTrip(t *testing.T) {
+	r := require.New(t)
+
+	opts := badger.DefaultOptions("/tmp/badger/j")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(context.Background(), db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	data := make([]byte, 600*1024+3)
+	//nolint:all
+	rand.Read(data)
+
+	root, _, _, err := BuildTree(sequoiaTypes.NewBytesSeeker(data), chunkSize, 0)
+	r.NoError(err)
+
+	_, c, err := f.WriteFile(bytes.NewReader(data), root, "file_owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+
+	var car bytes.Buffer
+	r.NoError(f.ExportCAR(context.Background(), root, &car, true))
+
+	// drop the file and its blocks so the import has to restore everything
+	r.NoError(f.DeleteFile(root, "file_owner", 0))
+	_, err = f.CollectGarbage(context.Background(), false)
+	r.NoError(err)
+	_, err = f.GetFileData(root)
+	r.Error(err)
+
+	// a car of a different file is rejected
+	_, _, err = f.ImportCAR(context.Background(), bytes.NewReader(car.Bytes()), []byte("not the merkle"), "file_owner", 0, chunkSize, 0, nil, SourceImport)
+	r.ErrorContains(err, "merkle does not match")
+
+	size, imported, err := f.ImportCAR(context.Background(), bytes.NewReader(car.Bytes()), root, "file_owner", 0, chunkSize, 0, nil, SourceImport)
+	r.NoError(err)
+	r.Equal(len(data), size)
+	r.Equal(c, imported)
+
+	status, reason := f.VerifyFile(context.Background(), root, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+
+	meta, err := f.GetFileMetadata(root, "file_owner", 0)
+	r.NoError(err)
+	r.Equal(SourceImport, meta.Source)
+
+	file, err := f.GetFileData(root)
+	r.NoError(err)
+	got, err := io.ReadAll(file)
+	r.NoError(err)
+	r.Equal(data, got)
+
+	// a truncated car is missing blocks
+	_, _, err = f.ImportCAR(context.Background(), bytes.NewReader(car.Bytes()[:car.Len()/2]), root, "other_owner", 0, chunkSize, 0, nil, SourceImport)
+	r.Error(err)
+}
+
+func TestTieredBlockStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/i")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	hot := ipfs.NewBadgerBlockStore(ds)
+	cold, err := ipfs.NewFlatfsBlockStore(t.TempDir())
+	r.NoError(err)
+
+	f, err := NewFileSystem(ctx, db, "", ds, ipfs.NewTieredBlockStore(hot, cold), 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+	r.True(f.Tiered())
+
+	var chunkSize int64 = 1024
+	write := func(owner string) ([]byte, cid.Cid) {
+		data := make([]byte, 200*1024)
+		//nolint:all
+		rand.Read(data)
+		root, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+		r.NoError(err)
+		_, c, err := f.WriteFile(bytes.NewReader(data), root, owner, 0, chunkSize, 0, nil, SourceUpload)
+		r.NoError(err)
+		return root, cid.MustParse(c)
+	}
+
+	busy, busyRoot := write("busy_owner")
+	idle, idleRoot := write("idle_owner")
+
+	// the idle file was last served two days ago
+	r.NoError(db.Update(func(txn *badger.Txn) error {
+		return setServed(txn, idle, time.Now().Add(-48*time.Hour))
+	}))
+
+	res, err := f.DemoteIdle(ctx, 24*time.Hour)
+	r.NoError(err)
+	r.Equal(1, res.Files)
+	r.NotZero(res.Blocks)
+
+	inTier := func(bs blockstore.Blockstore, root cid.Cid) bool {
+		blocks, err := f.dagBlocks(ctx, root, nil)
+		r.NoError(err)
+		for _, c := range blocks {
+			has, err := bs.Has(ctx, c)
+			r.NoError(err)
+			if !has {
+				return false
+			}
+		}
+		return true
+	}
+	r.True(inTier(hot, busyRoot))
+	r.True(inTier(cold, idleRoot))
+
+	// demoted files are still readable and provable through the hot tier
+	status, reason := f.VerifyFile(ctx, idle, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+
+	// a second pass has nothing left to move
+	res, err = f.DemoteIdle(ctx, 24*time.Hour)
+	r.NoError(err)
+	r.Zero(res.Files)
+
+	r.NoError(f.PromoteFile(ctx, idle))
+	r.True(inTier(hot, idleRoot))
+
+	// the counts kept while writing and moving blocks match counting every block
+	hotUsage, coldUsage := f.tiers.Usage()
+	r.NotZero(hotUsage.Blocks)
+	r.Zero(coldUsage.Blocks)
+	r.NoError(f.tiers.Recount(ctx))
+	hotCounted, coldCounted := f.tiers.Usage()
+	r.Equal(hotCounted, hotUsage)
+	r.Equal(coldCounted, coldUsage)
+
+	status, reason = f.VerifyFile(ctx, busy, chunkSize)
+	r.Equal(ScrubOK, status, reason)
+}
+
+func TestMultiDiskRebalance(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/a")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	first := t.TempDir()
+	second := t.TempDir()
+	disks := []config.DiskConfig{{Directory: first}, {Directory: second}}
+
+	bs, err := ipfs.NewMultiDiskBlockStore(disks)
+	r.NoError(err)
+	f, err := NewFileSystem(ctx, db, "", ds, bs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	merkles := make([][]byte, 0)
+	for i := 0; i < 3; i++ {
+		data := make([]byte, 100*1024)
+		//nolint:all
+		rand.Read(data)
+		root, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+		r.NoError(err)
+		_, _, err = f.WriteFile(bytes.NewReader(data), root, "owner", int64(i), chunkSize, 0, nil, SourceUpload)
+		r.NoError(err)
+		merkles = append(merkles, root)
+	}
+
+	diskOf := func(merkle []byte) string {
+		var disk string
+		r.NoError(db.View(func(txn *badger.Txn) error {
+			var found bool
+			disk, found, err = getDisk(txn, merkle)
+			r.True(found)
+			return err
+		}))
+		return disk
+	}
+	for _, merkle := range merkles {
+		r.Contains([]string{first, second}, diskOf(merkle))
+	}
+
+	// drain the first disk, every file has to end up on the second one
+	disks[0].Drain = true
+	bs, err = ipfs.NewMultiDiskBlockStore(disks)
+	r.NoError(err)
+	f, err = NewFileSystem(ctx, db, "", ds, bs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	_, err = f.Rebalance(ctx, false)
+	r.NoError(err)
+
+	for _, merkle := range merkles {
+		r.Equal(second, diskOf(merkle))
+
+		fcid, err := f.GetCIDFromMerkle(merkle)
+		r.NoError(err)
+		blocks, err := f.dagBlocks(ctx, cid.MustParse(fcid), nil)
+		r.NoError(err)
+		for _, c := range blocks {
+			disk, found, err := f.disks.Locate(context.Background(), c)
+			r.NoError(err)
+			r.True(found)
+			r.Equal(second, disk)
+		}
+
+		status, reason := f.VerifyFile(ctx, merkle, chunkSize)
+		r.Equal(ScrubOK, status, reason)
+	}
+
+	// nothing is left to move
+	res, err := f.Rebalance(ctx, true)
+	r.NoError(err)
+	r.Zero(res.Moved)
+}
+
+func TestSpaceAccounting(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/l")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	data := make([]byte, 10*1024)
+	//nolint:all
+	rand.Read(data)
+	root, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+	r.NoError(err)
+
+	_, _, err = f.WriteFile(bytes.NewReader(data), root, "owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+	copied, err := f.CopyContract(root, "other_owner", 10)
+	r.NoError(err)
+	r.True(copied)
+
+	usage, err := f.SpaceUsage()
+	r.NoError(err)
+	r.Equal(int64(2*len(data)), usage.Stored)
+
+	f.SetSpaceLimits(int64(3*len(data)), 0, nil)
+
+	release, err := f.ReserveSpace(int64(len(data)))
+	r.NoError(err)
+
+	// the space of the file being written is held until it is released
+	_, err = f.ReserveSpace(1)
+	r.ErrorIs(err, ErrInsufficientSpace)
+	release()
+	release()
+	r.NoError(f.CheckSpace(int64(len(data))))
+
+	// removing a contract frees its space
+	r.NoError(f.DeleteFile(root, "other_owner", 10))
+	r.NoError(f.CheckSpace(int64(2 * len(data))))
+
+	// a reserve larger than any disk refuses everything
+	f.SetSpaceLimits(0, 1<<62, []string{t.TempDir()})
+	err = f.CheckSpace(1)
+	r.ErrorIs(err, ErrInsufficientSpace)
+
+	// the count survives a restart
+	f, err = NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+	usage, err = f.SpaceUsage()
+	r.NoError(err)
+	r.Equal(int64(len(data)), usage.Stored)
+	r.Equal(int64(-1), usage.DiskFree)
+}
+
+func TestDatabaseMaintenance(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	// small value log files and memtables so deleted values fill whole files and reach the LSM tree
+	opts := badger.DefaultOptions("/tmp/badger/m").WithValueLogFileSize(2 << 20).WithValueThreshold(1024).WithMemTableSize(16 << 10)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	value := make([]byte, 8*1024)
+	for i := 0; i < 1000; i++ {
+		r.NoError(db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(fmt.Sprintf("scratch/%d", i)), value)
+		}))
+	}
+	for i := 0; i < 1000; i++ {
+		r.NoError(db.Update(func(txn *badger.Txn) error {
+			return txn.Delete([]byte(fmt.Sprintf("scratch/%d", i)))
+		}))
+	}
+
+	r.True(f.Idle(0))
+	_, err = f.Compact()
+	r.NoError(err)
+
+	res, err := f.RunValueLogGC(0.5)
+	r.NoError(err)
+	r.NotZero(res.Rewritten)
+	r.NotZero(res.Reclaimed)
+
+	// nothing is left to collect
+	res, err = f.RunValueLogGC(0.5)
+	r.NoError(err)
+	r.Zero(res.Rewritten)
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	r := require.New(t)
+
+	// a new database starts at the latest version
+	fresh, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer fresh.Close()
+
+	r.NoError(initSchema(fresh))
+	f := &FileSystem{db: fresh}
+	version, err := f.SchemaVersion()
+	r.NoError(err)
+	r.Equal(LatestSchemaVersion(), version)
+	r.NoError(f.CheckSchema())
+
+	// a database written before versions were recorded runs every migration
+	legacy, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer legacy.Close()
+
+	merkle := []byte("legacy merkle")
+	r.NoError(legacy.Update(func(txn *badger.Txn) error {
+		err := txn.Set(treeKey(merkle, "alice", 10), []byte("tree"))
+		if err != nil {
+			return err
+		}
+		return txn.Set(fmt.Appendf(nil, "cid/%x", merkle), []byte("cid"))
+	}))
+
+	r.NoError(initSchema(legacy))
+	f = &FileSystem{db: legacy}
+	pending, err := f.PendingMigrations()
+	r.NoError(err)
+	r.Len(pending, LatestSchemaVersion())
+	r.ErrorIs(f.CheckSchema(), ErrSchemaOutdated)
+
+	r.NoError(f.Migrate())
+	r.NoError(f.CheckSchema())
+	owners, _, err := f.ListContracts(merkle)
+	r.NoError(err)
+	r.Equal([]string{"alice"}, owners)
+
+	// a database from a newer build is refused
+	r.NoError(legacy.Update(func(txn *badger.Txn) error {
+		return setSchemaVersion(txn, LatestSchemaVersion()+1)
+	}))
+	r.Error(initSchema(legacy))
+}
+
+func TestMetadataBackfill(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/q")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+
+	f, err := NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	data := make([]byte, 10*1024)
+	//nolint:all
+	rand.Read(data)
+
+	merkles := make(map[int64][]byte)
+	for _, proofType := range []int64{sequoiaTypes.ProofTypeDefault, sequoiaTypes.ProofTypeBlake3} {
+		root, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, proofType)
+		r.NoError(err)
+		_, _, err = f.WriteFile(bytes.NewReader(data), root, "owner", proofType, chunkSize, proofType, nil, SourceUpload)
+		r.NoError(err)
+		merkles[proofType] = root
+	}
+
+	// contracts stored before metadata was recorded have none
+	r.NoError(db.Update(func(txn *badger.Txn) error {
+		for proofType, merkle := range merkles {
+			err := txn.Delete(metaKey(merkle, "owner", proofType))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	r.NoError(f.migrateMetadata())
+
+	for proofType, merkle := range merkles {
+		meta, err := f.GetFileMetadata(merkle, "owner", proofType)
+		r.NoError(err)
+		r.Equal(proofType, meta.ProofType)
+		r.Equal(SourceUnknown, meta.Source)
+		r.GreaterOrEqual(meta.Size, int64(len(data)))
+	}
+
+	// an upgraded provider only counts the space of these contracts once the migration ran
+	r.NoError(db.Update(func(txn *badger.Txn) error {
+		for proofType, merkle := range merkles {
+			err := txn.Delete(metaKey(merkle, "owner", proofType))
+			if err != nil {
+				return err
+			}
+		}
+		return setSchemaVersion(txn, 1)
+	}))
+
+	f, err = NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+	usage, err := f.SpaceUsage()
+	r.NoError(err)
+	r.Zero(usage.Stored)
+
+	r.NoError(f.Migrate())
+	usage, err = f.SpaceUsage()
+	r.NoError(err)
+	r.GreaterOrEqual(usage.Stored, int64(2*len(data)))
+}
+
+func TestBackupRestore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/n")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+	bs, err := ipfs.NewFlatfsBlockStore(t.TempDir())
+	r.NoError(err)
+	f, err := NewFileSystem(ctx, db, "", ds, bs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	files := make(map[string][]byte)
+	for i := 0; i < 2; i++ {
+		data := make([]byte, 50*1024)
+		//nolint:all
+		rand.Read(data)
+		root, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+		r.NoError(err)
+		_, _, err = f.WriteFile(bytes.NewReader(data), root, "owner", int64(i), chunkSize, 0, nil, SourceUpload)
+		r.NoError(err)
+		files[string(root)] = data
+	}
+
+	var withBlocks bytes.Buffer
+	res, err := f.Backup(ctx, &withBlocks, true)
+	r.NoError(err)
+	r.Equal(res.Cids, res.Blocks)
+	r.Greater(res.Cids, 0)
+
+	var withoutBlocks bytes.Buffer
+	res, err = f.Backup(ctx, &withoutBlocks, false)
+	r.NoError(err)
+	r.Equal(0, res.Blocks)
+
+	restore := func(archive []byte) (*FileSystem, []cid.Cid, error) {
+		rdb, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+		r.NoError(err)
+		t.Cleanup(func() { _ = rdb.Close() })
+		rds, err := ipfs.NewBadgerDataStore(rdb)
+		r.NoError(err)
+		rbs, err := ipfs.NewFlatfsBlockStore(t.TempDir())
+		r.NoError(err)
+
+		manifest, listed, err := Restore(ctx, bytes.NewReader(archive), rdb, rbs)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.Equal(LatestSchemaVersion(), manifest.SchemaVersion)
+
+		rf, err := NewFileSystem(ctx, rdb, "", rds, rbs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+		r.NoError(err)
+		return rf, listed, nil
+	}
+
+	// a backup with blocks rebuilds every file
+	rf, listed, err := restore(withBlocks.Bytes())
+	r.NoError(err)
+	check, err := rf.CheckRestore(ctx, listed)
+	r.NoError(err)
+	r.Equal(len(files), check.Roots)
+	r.Empty(check.Incomplete)
+	r.Equal(0, check.MissingBlocks)
+
+	for merkle, data := range files {
+		reader, err := rf.GetFileData([]byte(merkle))
+		r.NoError(err)
+		got, err := io.ReadAll(reader)
+		r.NoError(err)
+		r.Equal(data, got)
+	}
+
+	// without blocks the database comes back but every file is flagged
+	rf, listed, err = restore(withoutBlocks.Bytes())
+	r.NoError(err)
+	check, err = rf.CheckRestore(ctx, listed)
+	r.NoError(err)
+	r.Len(check.Incomplete, len(files))
+	r.Equal(len(listed), check.MissingBlocks)
+
+	// cut off archives and non-empty databases are refused
+	_, _, err = restore(withBlocks.Bytes()[:withBlocks.Len()-1])
+	r.ErrorIs(err, ErrInvalidBackup)
+	_, _, err = Restore(ctx, bytes.NewReader(withBlocks.Bytes()), db, bs)
+	r.Error(err)
+}
+
+func TestBackupEncrypted(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/t")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	key := make([]byte, 32)
+	//nolint:all
+	rand.Read(key)
+	encrypted := func(key []byte) *ipfs.EncryptedBlockStore {
+		inner, err := ipfs.NewFlatfsBlockStore(t.TempDir())
+		r.NoError(err)
+		bs, err := ipfs.NewEncryptedBlockStore(inner, key, config.CipherAESGCM)
+		r.NoError(err)
+		return bs
+	}
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+	f, err := NewFileSystem(ctx, db, "", ds, encrypted(key), 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	data := make([]byte, 50*1024)
+	//nolint:all
+	rand.Read(data)
+	merkle, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+	r.NoError(err)
+	_, _, err = f.WriteFile(bytes.NewReader(data), merkle, "owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+
+	// the archive keeps blocks the way they are stored
+	var archive bytes.Buffer
+	res, err := f.Backup(ctx, &archive, true)
+	r.NoError(err)
+	r.True(res.Manifest.Encrypted)
+	r.Greater(res.Blocks, 0)
+	r.False(bytes.Contains(archive.Bytes(), data[:64]))
+
+	restore := func(bs blockstore.Blockstore) (*FileSystem, error) {
+		rdb, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+		r.NoError(err)
+		t.Cleanup(func() { _ = rdb.Close() })
+
+		_, _, err = Restore(ctx, bytes.NewReader(archive.Bytes()), rdb, bs)
+		if err != nil {
+			return nil, err
+		}
+
+		rds, err := ipfs.NewBadgerDataStore(rdb)
+		r.NoError(err)
+		return NewFileSystem(ctx, rdb, "", rds, bs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	}
+
+	// restoring takes the key the blocks were encrypted with
+	plain, err := ipfs.NewFlatfsBlockStore(t.TempDir())
+	r.NoError(err)
+	_, err = restore(plain)
+	r.Error(err)
+	_, err = restore(encrypted(make([]byte, 32)))
+	r.Error(err)
+
+	rf, err := restore(encrypted(key))
+	r.NoError(err)
+	reader, err := rf.GetFileData(merkle)
+	r.NoError(err)
+	got, err := io.ReadAll(reader)
+	r.NoError(err)
+	r.Equal(data, got)
+}
+
+func TestRebuildIndex(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/o")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+	bs, err := ipfs.NewFlatfsBlockStore(t.TempDir())
+	r.NoError(err)
+	f, err := NewFileSystem(ctx, db, "", ds, bs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	var chunkSize int64 = 1024
+	rawLeaves := &ipfslite.AddParams{Layout: "balanced", Chunker: "size-2048", RawLeaves: true, HashFun: "sha2-256"}
+
+	contracts := make([]RebuildContract, 0)
+	files := make(map[string][]byte)
+	for i, params := range []*ipfslite.AddParams{nil, rawLeaves} {
+		data := make([]byte, 20*1024)
+		//nolint:all
+		rand.Read(data)
+		root, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+		r.NoError(err)
+		_, _, err = f.WriteFile(bytes.NewReader(data), root, "owner", int64(i), chunkSize, 0, params, SourceUpload)
+		r.NoError(err)
+		files[string(root)] = data
+
+		contracts = append(contracts, RebuildContract{Merkle: root, Owner: "owner", Start: int64(i), IPFSParams: params})
+	}
+	// a second owner of the first file and a file that was never stored
+	contracts = append(contracts,
+		RebuildContract{Merkle: contracts[0].Merkle, Owner: "other", Start: 5},
+		RebuildContract{Merkle: []byte("missing merkle"), Owner: "owner", Start: 9},
+	)
+
+	cids := make(map[string]string)
+	for merkle := range files {
+		c, err := f.GetCIDFromMerkle([]byte(merkle))
+		r.NoError(err)
+		cids[merkle] = c
+	}
+
+	// lose the database but keep the blocks
+	lost, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer lost.Close()
+	lostDs, err := ipfs.NewBadgerDataStore(lost)
+	r.NoError(err)
+	f, err = NewFileSystem(ctx, lost, "", lostDs, bs, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+
+	res, err := f.RebuildIndex(ctx, contracts, chunkSize)
+	r.NoError(err)
+	r.Equal(3, res.Recovered)
+	r.Equal(0, res.Relaid)
+	r.Len(res.Failed, 1)
+	r.Equal(int64(9), res.Failed[0].Contract.Start)
+
+	for merkle, data := range files {
+		c, err := f.GetCIDFromMerkle([]byte(merkle))
+		r.NoError(err)
+		r.Equal(cids[merkle], c)
+
+		reader, err := f.GetFileData([]byte(merkle))
+		r.NoError(err)
+		got, err := io.ReadAll(reader)
+		r.NoError(err)
+		r.Equal(data, got)
+	}
+	for _, c := range contracts[:3] {
+		found, err := f.CheckTree(c.Merkle, c.Owner, c.Start)
+		r.NoError(err)
+		r.True(found)
+	}
+
+	// a second run leaves recovered contracts alone
+	res, err = f.RebuildIndex(ctx, contracts, chunkSize)
+	r.NoError(err)
+	r.Equal(3, res.Indexed)
+	r.Equal(0, res.Recovered)
+}
+
+func TestProofCache(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	opts := badger.DefaultOptions("/tmp/badger/p")
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	r.NoError(db.DropAll())
+
+	ds, err := ipfs.NewBadgerDataStore(db)
+	r.NoError(err)
+	f, err := NewFileSystem(ctx, db, "", ds, nil, 4005, "/dns4/ipfs.example.com/tcp/4001")
+	r.NoError(err)
+	f.SetProofCacheSize(1 << 20)
+
+	var chunkSize int64 = 1024
+	data := make([]byte, 40*1024)
+	//nolint:all
+	rand.Read(data)
+	merkle, _, _, err := BuildTree(bytes.NewReader(data), chunkSize, 0)
+	r.NoError(err)
+	_, _, err = f.WriteFile(bytes.NewReader(data), merkle, "owner", 0, chunkSize, 0, nil, SourceUpload)
+	r.NoError(err)
+
+	hits := func(kind string) float64 {
+		return testutil.ToFloat64(cacheHits.WithLabelValues(kind))
+	}
+	treeHits, chunkHits := hits(cacheTree), hits(cacheChunk)
+
+	// a second proof of the same chunk is served from memory and is still valid
+	for i := 0; i < 2; i++ {
+		tree, chunk, err := f.GetFileTreeByChunk(merkle, "owner", 0, 3, int(chunkSize), 0)
+		r.NoError(err)
+		r.Equal(data[3*chunkSize:4*chunkSize], chunk)
+
+		proof, err := tree.GenerateProofWithIndex(3, 0)
+		r.NoError(err)
+		r.NotEmpty(proof.Hashes)
+	}
+	r.Equal(treeHits+1, hits(cacheTree))
+	r.Equal(chunkHits+1, hits(cacheChunk))
+
+	// only chunks that prove against the tree are cached
+	tree, err := f.loadTree(merkle, "owner", 0)
+	r.NoError(err)
+	valid, err := tree.proves(3, data[3*chunkSize:4*chunkSize], 0)
+	r.NoError(err)
+	r.True(valid)
+	valid, err = tree.proves(3, data[4*chunkSize:5*chunkSize], 0)
+	r.NoError(err)
+	r.False(valid)
+
+	// a damaged file drops its entries
+	fcid, err := f.GetCIDFromMerkle(merkle)
+	r.NoError(err)
+	root, err := cid.Decode(fcid)
+	r.NoError(err)
+	blocks, err := f.dagBlocks(ctx, root, nil)
+	r.NoError(err)
+	r.NoError(f.ipfs.BlockStore().DeleteBlock(ctx, blocks[0]))
+	status, _ := f.VerifyFile(ctx, merkle, chunkSize)
+	r.Equal(ScrubMissing, status)
+	r.Empty(f.cache.byMerkle[string(merkle)])
+
+	// removing the contract drops its entries
+	r.NoError(f.DeleteFile(merkle, "owner", 0))
+	r.Empty(f.cache.byMerkle[string(merkle)])
+	_, _, err = f.GetFileTreeByChunk(merkle, "owner", 0, 3, int(chunkSize), 0)
+	r.Error(err)
+
+	// the budget is kept by evicting the least recently used entries
+	c := newProofCache(3 * (cacheEntryOverhead + 100))
+	for i := 0; i < 4; i++ {
+		c.add(fmt.Sprintf("chunk/%d", i), []byte{byte(i)}, make([]byte, 90), 90)
+	}
+	_, ok := c.get(cacheChunk, "chunk/0")
+	r.False(ok)
+	_, ok = c.get(cacheChunk, "chunk/3")
+	r.True(ok)
+	r.LessOrEqual(c.size, c.limit)
+
+	c.dropMerkle([]byte{3})
+	_, ok = c.get(cacheChunk, "chunk/3")
+	r.False(ok)
+	r.Empty(c.byMerkle[string([]byte{3})])
+}
+
+func TestProofHistory(t *testing.T) {
+	r := require.New(t)
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	f := &FileSystem{db: db}
+	f.SetProofHistoryLimit(3)
+
+	merkle := []byte("a")
+	now := time.Now()
+	attempt := func(height int64, result string) {
+		now = now.Add(time.Second)
+		err := f.RecordProofAttempt(merkle, "owner", 0, &sequoiaTypes.ProofAttempt{
+			Time:   now,
+			Height: height,
+			Window: 100,
+			Result: result,
+		})
+		r.NoError(err)
+	}
+
+	_, _, err = f.ProofHistory(merkle, "owner", 0)
+	r.ErrorIs(err, badger.ErrKeyNotFound)
+
+	attempt(100, sequoiaTypes.ProofResultProven)
+	attempt(150, sequoiaTypes.ProofResultTx)
+	attempt(160, sequoiaTypes.ProofResultGeneration)
+
+	attempts, status, err := f.ProofHistory(merkle, "owner", 0)
+	r.NoError(err)
+	r.Len(attempts, 3)
+	r.Equal(sequoiaTypes.ProofResultGeneration, attempts[0].Result) // newest first
+	r.Equal(int64(2), status.Failures)
+	r.Equal(int64(100), status.LastProven.Height)
+	r.False(status.AtRisk()) // still inside the window of the last proof
+
+	// the oldest attempts are trimmed, the status keeps counting
+	attempt(200, sequoiaTypes.ProofResultRejected)
+	attempts, status, err = f.ProofHistory(merkle, "owner", 0)
+	r.NoError(err)
+	r.Len(attempts, 3)
+	r.Equal(int64(150), attempts[2].Height)
+	r.Equal(int64(4), status.Attempts)
+	r.True(status.AtRisk())
+
+	// other contracts of the same file keep their own history
+	r.NoError(f.RecordProofAttempt(merkle, "owner", 10, &sequoiaTypes.ProofAttempt{Height: 200, Result: sequoiaTypes.ProofResultProven}))
+
+	statuses, err := f.ProofStatuses()
+	r.NoError(err)
+	r.Len(statuses, 2)
+
+	attempt(210, sequoiaTypes.ProofResultProven)
+	_, status, err = f.ProofHistory(merkle, "owner", 0)
+	r.NoError(err)
+	r.Equal(int64(0), status.Failures)
+	r.False(status.AtRisk())
+
+	err = db.Update(func(txn *badger.Txn) error {
+		return deleteProofHistory(txn, merkle, "owner", 0)
+	})
+	r.NoError(err)
+
+	_, _, err = f.ProofHistory(merkle, "owner", 0)
+	r.ErrorIs(err, badger.ErrKeyNotFound)
+
+	attempts, _, err = f.ProofHistory(merkle, "owner", 10)
+	r.NoError(err)
+	r.Len(attempts, 1)
+}
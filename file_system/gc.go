@@ -0,0 +1,339 @@
+package file_system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/go-cid"
+	ipldFormat "github.com/ipfs/go-ipld-format"
+	"github.com/rs/zerolog/log"
+)
+
+// gcPrefix marks root CIDs that lost their last file since the previous sweep, so the
+// collector only runs when there may be something to reclaim.
+const gcPrefix = "gc/"
+
+// GCResult describes what a garbage collection sweep found and removed.
+type GCResult struct {
+	Roots      int      `json:"roots"`
+	Referenced int      `json:"referenced"`
+	Total      int      `json:"total"`
+	Unused     []string `json:"unused"`
+	Bytes      int64    `json:"bytes"`
+	Removed    int      `json:"removed"`
+	DryRun     bool     `json:"dry_run"`
+}
+
+// markSet holds reachable blocks by multihash, the blockstore lists its keys as raw CIDs
+// so comparing whole CIDs would miss every dag-pb node.
+type markSet map[string]struct{}
+
+func (m markSet) add(c cid.Cid) bool {
+	k := string(c.Hash())
+	if _, ok := m[k]; ok {
+		return false
+	}
+	m[k] = struct{}{}
+	return true
+}
+
+func (m markSet) has(c cid.Cid) bool {
+	_, ok := m[string(c.Hash())]
+	return ok
+}
+
+// errWritesInProgress is returned when a sweep is put off because blocks are being written.
+var errWritesInProgress = errors.New("blocks are being written")
+
+// startWrite registers a write of blocks that garbage collection cannot tell apart from unused ones
+// yet, such as an upload whose root is not recorded. It only waits for a sweep in progress, never for
+// other writes, and the sweep is put off while any write is registered. The returned func ends it.
+func (f *FileSystem) startWrite() func() {
+	f.gcLock.RLock()
+	f.writes.Add(1)
+	f.gcLock.RUnlock()
+
+	return func() {
+		f.writes.Add(-1)
+	}
+}
+
+// errMissingNode is returned when a block that links to others is missing, so whatever it links to
+// could not be marked.
+var errMissingNode = errors.New("block with links is missing")
+
+// markReachable adds every block reachable from root to marked. Raw leaves cannot have links so they are
+// marked without being read, which keeps marking from touching file contents. Missing blocks don't stop
+// the walk, but an error wrapping errMissingNode is returned once the rest of the DAG was marked.
+func (f *FileSystem) markReachable(ctx context.Context, root cid.Cid, marked markSet) error {
+	toVisit := []cid.Cid{root}
+	missing := make([]cid.Cid, 0)
+
+	for len(toVisit) > 0 {
+		c := toVisit[0]
+		toVisit = toVisit[1:]
+
+		if !marked.add(c) {
+			continue
+		}
+
+		if c.Type() == cid.Raw {
+			continue
+		}
+
+		blk, err := f.ipfs.BlockStore().Get(ctx, c)
+		if err != nil {
+			if ipldFormat.IsNotFound(err) {
+				log.Debug().Str("cid", c.String()).Msg("block missing while marking, its children cannot be reached")
+				missing = append(missing, c)
+				continue
+			}
+			return fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+
+		links, err := blockLinks(blk)
+		if err != nil {
+			return fmt.Errorf("cannot decode block %s | %w", c.String(), err)
+		}
+		for _, l := range links {
+			toVisit = append(toVisit, l.Cid)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %d under %s, first %s", errMissingNode, len(missing), root.String(), missing[0].String())
+	}
+	return nil
+}
+
+// markRoots marks every block reachable from roots that is not already in marked. Every root is
+// marked even if some miss blocks, the first of those is returned afterwards.
+func (f *FileSystem) markRoots(ctx context.Context, roots []string, marked markSet) error {
+	var missing error
+	for _, s := range roots {
+		c, err := cid.Decode(s)
+		if err != nil {
+			log.Warn().Err(err).Str("cid", s).Msg("failed to decode CID, skipping")
+			continue
+		}
+
+		err = f.markReachable(ctx, c, marked)
+		if errors.Is(err, errMissingNode) {
+			if missing == nil {
+				missing = err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return missing
+}
+
+func (f *FileSystem) listBlocks(ctx context.Context) ([]cid.Cid, error) {
+	keys, err := f.ipfs.BlockStore().AllKeysChan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all keys from blockstore: %w", err)
+	}
+
+	all := make([]cid.Cid, 0)
+	for c := range keys {
+		all = append(all, c)
+	}
+	return all, nil
+}
+
+// CollectGarbage removes every block that is not reachable from any cid/ root. Blocks shared between
+// files survive as long as one of those files is still stored. With dryRun nothing is deleted. Nothing
+// is swept either while a stored file misses a block that links to others.
+//
+// Marking happens without blocking writes, then the roots written in the meantime are marked again
+// while new writes are held off. Blocks of an upload in progress are never swept, the sweep is put off
+// with errWritesInProgress until no write is registered.
+func (f *FileSystem) CollectGarbage(ctx context.Context, dryRun bool) (*GCResult, error) {
+	started := time.Now()
+	res := &GCResult{DryRun: dryRun, Unused: make([]string, 0)}
+
+	// anything added to the blockstore after this listing is not a candidate for removal
+	candidates, err := f.listBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res.Total = len(candidates)
+
+	pending, err := f.pendingGC()
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := f.ListCids()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list root CIDs from badger: %w", err)
+	}
+
+	// the blocks below a missing node of a stored file can't be told apart from unused ones, sweeping
+	// would turn one lost node into a lost subtree
+	marked := make(markSet)
+	err = f.markRoots(ctx, roots, marked)
+	if err != nil {
+		return nil, fmt.Errorf("not sweeping | %w", err)
+	}
+
+	if !dryRun {
+		f.backupLock.Lock()
+		defer f.backupLock.Unlock()
+		f.gcLock.Lock()
+		defer f.gcLock.Unlock()
+
+		// the blocks of a write in progress may already be among the candidates
+		if n := f.writes.Load(); n > 0 {
+			return nil, fmt.Errorf("%w, %d in progress", errWritesInProgress, n)
+		}
+
+		// roots can be written or replaced while marking, catch up on those
+		roots, err = f.ListCids()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list root CIDs from badger: %w", err)
+		}
+		err = f.markRoots(ctx, roots, marked)
+		if err != nil {
+			return nil, fmt.Errorf("not sweeping | %w", err)
+		}
+	}
+	res.Roots = len(roots)
+	res.Referenced = len(marked)
+
+	bs := f.ipfs.BlockStore()
+	for _, c := range candidates {
+		if marked.has(c) {
+			continue
+		}
+		res.Unused = append(res.Unused, c.String())
+
+		size, err := bs.GetSize(ctx, c)
+		if err == nil {
+			res.Bytes += int64(size)
+		}
+
+		if dryRun {
+			continue
+		}
+
+		err = bs.DeleteBlock(ctx, c)
+		if err != nil {
+			log.Warn().Err(err).Str("cid", c.String()).Msg("could not remove unused block")
+			continue
+		}
+		res.Removed++
+	}
+
+	if !dryRun {
+		gcRemovedBlocks.Add(float64(res.Removed))
+		gcRemovedBytes.Add(float64(res.Bytes))
+		err = f.clearPendingGC(pending)
+		if err != nil {
+			log.Warn().Err(err).Msg("could not clear pending garbage collection markers")
+		}
+	}
+
+	log.Info().
+		Int("roots", res.Roots).
+		Int("referenced", res.Referenced).
+		Int("blocks", res.Total).
+		Int("unused", len(res.Unused)).
+		Int("removed", res.Removed).
+		Int64("bytes", res.Bytes).
+		Bool("dry_run", dryRun).
+		Dur("took", time.Since(started)).
+		Msg("Garbage collection finished")
+
+	return res, nil
+}
+
+func markPendingGC(txn *badger.Txn, root string) error {
+	return txn.Set([]byte(gcPrefix+root), nil)
+}
+
+func (f *FileSystem) pendingGC() ([][]byte, error) {
+	keys := make([][]byte, 0)
+	err := f.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(gcPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (f *FileSystem) clearPendingGC(keys [][]byte) error {
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		err := wb.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// GarbageCollector periodically sweeps unused blocks once files have been deleted.
+type GarbageCollector struct {
+	f        *FileSystem
+	interval time.Duration
+	running  bool
+	last     time.Time
+}
+
+func NewGarbageCollector(f *FileSystem, interval time.Duration) *GarbageCollector {
+	return &GarbageCollector{
+		f:        f,
+		interval: interval,
+		last:     time.Now(),
+	}
+}
+
+func (g *GarbageCollector) Start() {
+	g.running = true
+	defer log.Info().Msg("Garbage collector stopped")
+
+	for g.running {
+		time.Sleep(time.Second)
+		if !g.last.Add(g.interval).Before(time.Now()) {
+			continue
+		}
+		g.last = time.Now()
+
+		pending, err := g.f.pendingGC()
+		if err != nil {
+			log.Error().Err(err).Msg("could not check for pending garbage collection")
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		_, err = g.f.CollectGarbage(context.Background(), false)
+		if errors.Is(err, errWritesInProgress) {
+			log.Info().Err(err).Msg("Garbage collection put off")
+			g.last = time.Now().Add(time.Minute - g.interval) // try again in a minute
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("garbage collection failed")
+		}
+	}
+}
+
+func (g *GarbageCollector) Stop() {
+	g.running = false
+}
@@ -0,0 +1,251 @@
+package file_system
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	ipfs2 "github.com/JackalLabs/sequoia/ipfs"
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
+	"github.com/dgraph-io/badger/v4"
+	ipfslite "github.com/hsanjuan/ipfs-lite"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	ipldFormat "github.com/ipfs/go-ipld-format"
+	"github.com/rs/zerolog/log"
+	"github.com/wealdtech/go-merkletree/v2"
+	"github.com/zeebo/blake3"
+)
+
+// treeBuilder hashes a file into merkle leaves as it is written to, so the tree can be built
+// from the same read that feeds the unixfs importer.
+type treeBuilder struct {
+	proofType int64
+	hashKind  byte
+	treeHash  merkletree.HashType
+
+	chunk  []byte
+	fill   int
+	index  int
+	size   int
+	leaves []byte
+}
+
+func newTreeBuilder(chunkSize int64, proofType int64) (*treeBuilder, error) {
+	hashKind := proofTreeHash(proofType)
+	treeHash, err := treeHashType(hashKind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &treeBuilder{
+		proofType: proofType,
+		hashKind:  hashKind,
+		treeHash:  treeHash,
+		chunk:     make([]byte, chunkSize),
+		leaves:    make([]byte, 0),
+	}, nil
+}
+
+func (b *treeBuilder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		c := copy(b.chunk[b.fill:], p)
+		b.fill += c
+		p = p[c:]
+		if b.fill == len(b.chunk) {
+			b.hashChunk()
+		}
+	}
+	return n, nil
+}
+
+// chunkData is what the merkle leaf of a chunk is built from, its index followed by its hex encoding.
+func chunkData(proofType int64, index int, chunk []byte) []byte {
+	var h hash.Hash
+	switch proofType {
+	case sequoiaTypes.ProofTypeBlake3:
+		h = blake3.New()
+	default:
+		h = sha256.New()
+	}
+
+	_, _ = h.Write([]byte(strconv.Itoa(index)))
+	_, _ = hex.NewEncoder(h).Write(chunk)
+	return h.Sum(nil)
+}
+
+// hashChunk adds the leaf for the buffered chunk.
+func (b *treeBuilder) hashChunk() {
+	b.leaves = append(b.leaves, b.treeHash.Hash(chunkData(b.proofType, b.index, b.chunk[:b.fill]))...)
+	b.size += b.fill
+	b.fill = 0
+	b.index++
+}
+
+func (b *treeBuilder) finish() ([]byte, *Tree, int, error) {
+	if b.fill > 0 {
+		b.hashChunk()
+	}
+
+	tree, err := newTree(b.hashKind, b.leaves, uint64(b.index))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return tree.Root(), tree, b.size, nil
+}
+
+// ingest reads a file exactly once, adding it to IPFS while its merkle tree is built alongside. Blocks
+// of a file that turns out not to match merkle are handed to the garbage collector, which keeps the
+// ones other stored files still use.
+func (f *FileSystem) ingest(ctx context.Context, reader io.Reader, merkle []byte, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams) (ipldFormat.Node, *Tree, int, error) {
+	builder, err := newTreeBuilder(chunkSize, proofType)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var n ipldFormat.Node
+	if proofType == sequoiaTypes.ProofTypeIPFSFolder {
+		// Read entire reader into a single byte slice called data
+		data, err := io.ReadAll(io.TeeReader(reader, builder))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		folderNode := unixfs.EmptyDirNode()
+		err = folderNode.UnmarshalJSON(data)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		err = f.ipfs.Add(ctx, folderNode)
+		if err != nil {
+			f.discardBlocks(fmt.Sprintf("incomplete/%x", merkle))
+			return nil, nil, 0, err
+		}
+		n = folderNode
+	} else {
+		pr, pw := io.Pipe()
+		hashed := make(chan error, 1)
+		go func() {
+			_, err := io.Copy(builder, pr)
+			hashed <- err
+		}()
+
+		n, err = f.ipfs.AddFile(ctx, io.TeeReader(reader, pw), ipfsParams)
+		_ = pw.CloseWithError(err)
+		hashErr := <-hashed
+		if err != nil {
+			f.discardBlocks(fmt.Sprintf("incomplete/%x", merkle))
+			return nil, nil, 0, err
+		}
+		if hashErr != nil {
+			f.discardBlocks(n.Cid().String())
+			return nil, nil, 0, fmt.Errorf("cannot build tree | %w", hashErr)
+		}
+	}
+
+	root, tree, size, err := builder.finish()
+	if err != nil {
+		f.discardBlocks(n.Cid().String())
+		return nil, nil, 0, fmt.Errorf("cannot build tree | %w", err)
+	}
+
+	if hex.EncodeToString(merkle) != hex.EncodeToString(root) {
+		f.discardBlocks(n.Cid().String())
+		return nil, nil, 0, fmt.Errorf("merkle does not match %x != %x", merkle, root)
+	}
+
+	return n, tree, size, nil
+}
+
+// discardBlocks hands the blocks of a rejected or incomplete ingestion to the garbage collector.
+func (f *FileSystem) discardBlocks(root string) {
+	err := f.db.Update(func(txn *badger.Txn) error {
+		return markPendingGC(txn, root)
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("root", root).Msg("could not schedule rejected blocks for garbage collection")
+	}
+}
+
+// recordFile stores the tree, cid, metadata and proof schedule of a newly ingested contract, and the
+// disk its blocks were placed on if ctx carries one.
+func (f *FileSystem) recordFile(ctx context.Context, root cid.Cid, tree *Tree, size int, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, source string) error {
+	err := f.saveTreeNodes(merkle, tree)
+	if err != nil {
+		return fmt.Errorf("cannot save tree %x | %w", merkle, err)
+	}
+
+	var delta int64
+	err = f.db.Update(func(txn *badger.Txn) error {
+		err := txn.Set(treeKey(merkle, owner, start), tree.header())
+		if err != nil {
+			e := fmt.Errorf("cannot set tree %x | %w", merkle, err)
+			log.Error().Err(e)
+			return e
+		}
+
+		err = indexContract(txn, merkle, owner, start)
+		if err != nil {
+			return fmt.Errorf("cannot index contract %x | %w", merkle, err)
+		}
+
+		err = setCid(txn, merkle, root.String())
+		if err != nil {
+			e := fmt.Errorf("cannot set cid %x | %w", merkle, err)
+			log.Error().Err(e)
+			return e
+		}
+
+		old, err := contractSize(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
+		delta = int64(size) - old
+
+		err = setMetadata(txn, &FileMetadata{
+			Merkle:     merkle,
+			Owner:      owner,
+			Start:      start,
+			Size:       int64(size),
+			ProofType:  proofType,
+			ChunkSize:  chunkSize,
+			CID:        root.String(),
+			IPFSParams: ipfsParams,
+			Source:     source,
+			StoredAt:   time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot set metadata %x | %w", merkle, err)
+		}
+
+		if disk, ok := ipfs2.DiskFromContext(ctx); ok {
+			err = setDisk(txn, merkle, disk)
+			if err != nil {
+				return fmt.Errorf("cannot record disk of %x | %w", merkle, err)
+			}
+		}
+
+		err = resetTier(txn, merkle)
+		if err != nil {
+			return fmt.Errorf("cannot reset tier of %x | %w", merkle, err)
+		}
+
+		return setSchedule(txn, merkle, owner, start, 0) // new contracts are due right away
+	})
+	if err != nil {
+		return err
+	}
+
+	f.cache.dropMerkle(merkle)
+
+	f.addStoredBytes(delta)
+	return nil
+}
@@ -6,8 +6,6 @@ import (
 	"strings"
 
 	"github.com/dgraph-io/badger/v4"
-	"github.com/ipfs/go-cid"
-	"github.com/rs/zerolog/log"
 )
 
 func (f *FileSystem) ListPeers() []string {
@@ -123,66 +121,12 @@ func (f *FileSystem) MapCids() (map[string][]byte, error) {
 
 // ListUnusedCids returns a list of CIDs that exist in the blockstore but are not
 // referenced by any root CID in the badger database or their children.
-// This recursively traverses all folder and file node children to build a complete
-// set of referenced CIDs before comparing against the blockstore.
+// This is a dry run of the garbage collector, nothing is removed.
 func (f *FileSystem) ListUnusedCids(ctx context.Context) ([]string, error) {
-	// Step 1: Get all root CIDs from badger database
-	rootCidStrings, err := f.ListCids()
+	res, err := f.CollectGarbage(ctx, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list root CIDs from badger: %w", err)
+		return nil, err
 	}
 
-	log.Info().Msgf("Found %d root CIDs in badger database", len(rootCidStrings))
-
-	// Step 2: For each root CID, recursively collect all referenced CIDs (including children)
-	referencedCids := make(map[cid.Cid]struct{})
-	for _, cidStr := range rootCidStrings {
-		log.Info().Msgf("Collecting %s...", cidStr)
-		c, err := cid.Decode(cidStr)
-		if err != nil {
-			log.Warn().Err(err).Str("cid", cidStr).Msg("failed to decode CID, skipping")
-			continue
-		}
-
-		// Recursively collect all DAG nodes starting from this root CID
-		nodes, err := collectDAGNodes(ctx, f.ipfs, c)
-		if err != nil {
-			log.Warn().Err(err).Str("cid", cidStr).Msg("failed to collect DAG nodes, skipping")
-			continue
-		}
-
-		// Add all collected nodes to the referenced set
-		for nodeCid := range nodes {
-			referencedCids[nodeCid] = struct{}{}
-		}
-
-	}
-
-	log.Info().Msgf("Found %d total referenced CIDs (including children)", len(referencedCids))
-
-	// Step 3: Get all CIDs from the blockstore
-	bstore := f.ipfs.BlockStore()
-	allKeysChan, err := bstore.AllKeysChan(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get all keys from blockstore: %w", err)
-	}
-
-	blockstoreCids := make(map[cid.Cid]struct{})
-	for c := range allKeysChan {
-		blockstoreCids[c] = struct{}{}
-	}
-
-	log.Info().Msgf("Found %d total CIDs in blockstore", len(blockstoreCids))
-
-	// Step 4: Find unused CIDs (in blockstore but not in referenced set)
-	unusedCids := make([]string, 0)
-	for blockstoreCid := range blockstoreCids {
-		if _, isReferenced := referencedCids[blockstoreCid]; !isReferenced {
-			unusedCids = append(unusedCids, blockstoreCid.String())
-		}
-	}
-
-	log.Info().Msgf("Found %d unused CIDs", len(unusedCids))
-
-	return unusedCids, nil
+	return res.Unused, nil
 }
@@ -26,3 +26,11 @@ func SplitMerkle(key []byte) (merkle []byte, owner string, start int64, err erro
 func treeKey(merkle []byte, owner string, start int64) []byte {
 	return []byte(fmt.Sprintf("tree/%x/%s/%d", merkle, owner, start))
 }
+
+func contractKey(merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%s/%d", contractPrefix, merkle, owner, start))
+}
+
+func contractsKey(merkle []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x/", contractPrefix, merkle))
+}
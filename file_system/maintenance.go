@@ -0,0 +1,213 @@
+package file_system
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// ValueLogGCResult describes a value log garbage collection run.
+type ValueLogGCResult struct {
+	Rewritten int           // value log files rewritten
+	Reclaimed int64         // bytes the value log shrank by
+	Duration  time.Duration // time the run took
+}
+
+// CompactResult describes a compaction of the LSM tree.
+type CompactResult struct {
+	Reclaimed int64 // bytes the LSM tree shrank by
+	Duration  time.Duration
+}
+
+// dirSize adds up the sizes of the files in dir ending in ext. badger only refreshes DB.Size once a
+// minute, which is too stale to measure a run with.
+func dirSize(dir string, ext string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ext) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // removed by badger while listing
+			}
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+func (f *FileSystem) valueLogSize() (int64, error) {
+	return dirSize(f.db.Opts().ValueDir, ".vlog")
+}
+
+func (f *FileSystem) lsmSize() (int64, error) {
+	return dirSize(f.db.Opts().Dir, ".sst")
+}
+
+// updateDatabaseSize refreshes the database size gauges.
+func (f *FileSystem) updateDatabaseSize() {
+	vlog, err := f.valueLogSize()
+	if err == nil {
+		dbValueLogBytes.Set(float64(vlog))
+	}
+	lsm, err := f.lsmSize()
+	if err == nil {
+		dbLSMBytes.Set(float64(lsm))
+	}
+}
+
+// RunValueLogGC rewrites value log files until none has more than discardRatio of stale data left.
+func (f *FileSystem) RunValueLogGC(discardRatio float64) (*ValueLogGCResult, error) {
+	started := time.Now()
+	res := &ValueLogGCResult{}
+
+	before, err := f.valueLogSize()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = f.db.RunValueLogGC(discardRatio)
+		if err != nil {
+			break
+		}
+		res.Rewritten++
+	}
+	if !errors.Is(err, badger.ErrNoRewrite) && !errors.Is(err, badger.ErrRejected) {
+		return nil, err
+	}
+
+	after, err := f.valueLogSize()
+	if err != nil {
+		return nil, err
+	}
+
+	res.Reclaimed = max(before-after, 0)
+	res.Duration = time.Since(started)
+
+	dbValueLogReclaimed.Add(float64(res.Reclaimed))
+	dbValueLogGCDuration.Observe(res.Duration.Seconds())
+	f.updateDatabaseSize()
+
+	return res, nil
+}
+
+// Compact flattens the LSM tree so stale versions of deleted keys are dropped and the value log
+// learns which of its entries are stale. Writes still work during a compaction but slow it down.
+func (f *FileSystem) Compact() (*CompactResult, error) {
+	started := time.Now()
+
+	before, err := f.lsmSize()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.db.Flatten(max(runtime.NumCPU()/2, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := f.lsmSize()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &CompactResult{
+		Reclaimed: max(before-after, 0),
+		Duration:  time.Since(started),
+	}
+
+	dbCompactReclaimed.Add(float64(res.Reclaimed))
+	dbCompactDuration.Observe(res.Duration.Seconds())
+	f.updateDatabaseSize()
+
+	return res, nil
+}
+
+// Idle reports whether no file was written or removed for d and none is being written.
+func (f *FileSystem) Idle(d time.Duration) bool {
+	f.space.lock.Lock()
+	writing := f.space.reserved > 0
+	f.space.lock.Unlock()
+
+	return !writing && time.Since(time.Unix(0, f.lastWrite.Load())) >= d
+}
+
+// Maintenance periodically garbage collects the value log of the database and compacts its LSM tree
+// once the provider is idle.
+type Maintenance struct {
+	f               *FileSystem
+	interval        time.Duration
+	discardRatio    float64
+	compactInterval time.Duration
+	idleAfter       time.Duration
+	running         bool
+	lastGC          time.Time
+	lastCompact     time.Time
+}
+
+func NewMaintenance(f *FileSystem, interval time.Duration, discardRatio float64, compactInterval time.Duration, idleAfter time.Duration) *Maintenance {
+	return &Maintenance{
+		f:               f,
+		interval:        interval,
+		discardRatio:    discardRatio,
+		compactInterval: compactInterval,
+		idleAfter:       idleAfter,
+		lastGC:          time.Now(),
+		lastCompact:     time.Now(),
+	}
+}
+
+func (m *Maintenance) Start() {
+	m.running = true
+	defer log.Info().Msg("Database maintenance stopped")
+
+	m.f.updateDatabaseSize()
+
+	for m.running {
+		time.Sleep(time.Second)
+
+		// a compaction that is due waits for a quiet moment, the value log is collected right after it
+		// since the compaction is what tells badger which values are stale
+		if m.compactInterval > 0 && m.lastCompact.Add(m.compactInterval).Before(time.Now()) && m.f.Idle(m.idleAfter) {
+			m.lastCompact = time.Now()
+
+			res, err := m.f.Compact()
+			if err != nil {
+				log.Error().Err(err).Msg("database compaction failed")
+			} else {
+				log.Info().Int64("reclaimed", res.Reclaimed).Dur("took", res.Duration).Msg("Compacted database")
+			}
+			m.lastGC = time.Time{}
+		}
+
+		if !m.lastGC.Add(m.interval).Before(time.Now()) {
+			continue
+		}
+		m.lastGC = time.Now()
+
+		res, err := m.f.RunValueLogGC(m.discardRatio)
+		if err != nil {
+			log.Error().Err(err).Msg("value log garbage collection failed")
+		} else if res.Rewritten > 0 {
+			log.Info().Int("files", res.Rewritten).Int64("reclaimed", res.Reclaimed).Dur("took", res.Duration).Msg("Collected value log garbage")
+		}
+	}
+}
+
+func (m *Maintenance) Stop() {
+	m.running = false
+}
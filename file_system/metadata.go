@@ -0,0 +1,269 @@
+package file_system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
+	"github.com/dgraph-io/badger/v4"
+	ipfslite "github.com/hsanjuan/ipfs-lite"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	ipldFormat "github.com/ipfs/go-ipld-format"
+	"github.com/rs/zerolog/log"
+)
+
+// How a file arrived on this provider.
+const (
+	SourceUpload = "upload"
+	SourceStray  = "stray"
+	SourceRepair = "repair"
+	SourceCopy   = "copy"
+	SourceImport = "import"
+	// SourceUnknown marks contracts stored before metadata was recorded, filled in by a migration.
+	SourceUnknown = "unknown"
+
+	metaPrefix = "meta/"
+)
+
+// FileMetadata is stored for every contract next to its tree so basic facts about a file
+// don't have to be looked up on chain again.
+type FileMetadata struct {
+	Merkle     []byte              `json:"merkle"`
+	Owner      string              `json:"owner"`
+	Start      int64               `json:"start"`
+	Size       int64               `json:"size"`
+	ProofType  int64               `json:"proof_type"`
+	ChunkSize  int64               `json:"chunk_size"`
+	CID        string              `json:"cid"`
+	IPFSParams *ipfslite.AddParams `json:"ipfs_params,omitempty"`
+	Source     string              `json:"source"`
+	StoredAt   time.Time           `json:"stored_at"`
+}
+
+func metaKey(merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%s/%d", metaPrefix, merkle, owner, start))
+}
+
+func setMetadata(txn *badger.Txn, meta *FileMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return txn.Set(metaKey(meta.Merkle, meta.Owner, meta.Start), data)
+}
+
+func getMetadata(txn *badger.Txn, merkle []byte, owner string, start int64) (*FileMetadata, error) {
+	item, err := txn.Get(metaKey(merkle, owner, start))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta FileMetadata
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// GetFileMetadata returns the metadata recorded when a contract was stored.
+// Contracts whose metadata could not be backfilled return badger.ErrKeyNotFound.
+func (f *FileSystem) GetFileMetadata(merkle []byte, owner string, start int64) (meta *FileMetadata, err error) {
+	err = f.db.View(func(txn *badger.Txn) error {
+		meta, err = getMetadata(txn, merkle, owner, start)
+		return err
+	})
+	return meta, err
+}
+
+// ListFileMetadata returns the metadata of every contract that has any.
+func (f *FileSystem) ListFileMetadata() ([]FileMetadata, error) {
+	metas := make([]FileMetadata, 0)
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(metaPrefix), PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var m FileMetadata
+				err := json.Unmarshal(val, &m)
+				if err != nil {
+					return err
+				}
+				metas = append(metas, m)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return metas, err
+}
+
+// storedProofType returns the proof type recorded for any contract of merkle.
+func (f *FileSystem) storedProofType(merkle []byte) (proofType int64, found bool, err error) {
+	err = f.db.View(func(txn *badger.Txn) error {
+		owner, start, ok, err := firstContract(txn, merkle)
+		if err != nil || !ok {
+			return err
+		}
+
+		meta, err := getMetadata(txn, merkle, owner, start)
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		proofType = meta.ProofType
+		found = true
+		return nil
+	})
+	return proofType, found, err
+}
+
+// dagSize adds up the size of every block of a file found in the local blockstore.
+func (f *FileSystem) dagSize(ctx context.Context, root cid.Cid) (int64, error) {
+	cids, err := f.dagBlocks(ctx, root, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	bs := f.ipfs.BlockStore()
+	var size int64
+	for _, c := range cids {
+		n, err := bs.GetSize(ctx, c)
+		if err != nil {
+			if ipldFormat.IsNotFound(err) {
+				continue
+			}
+			return 0, fmt.Errorf("cannot read size of block %s | %w", c.String(), err)
+		}
+		size += int64(n)
+	}
+	return size, nil
+}
+
+// legacyProofType works out the proof type of a contract stored before metadata was recorded. The
+// tree tells blake3 files apart, a root block that is a directory makes it a folder.
+func (f *FileSystem) legacyProofType(ctx context.Context, merkle []byte, owner string, start int64, root cid.Cid) (int64, error) {
+	tree, err := f.loadTree(merkle, owner, start)
+	if err != nil {
+		return 0, err
+	}
+	if tree.hashKind == treeHashBlake3 {
+		return sequoiaTypes.ProofTypeBlake3, nil
+	}
+
+	if root.Type() != cid.DagProtobuf {
+		return sequoiaTypes.ProofTypeDefault, nil
+	}
+	blk, err := f.ipfs.BlockStore().Get(ctx, root)
+	if err != nil {
+		if ipldFormat.IsNotFound(err) {
+			return sequoiaTypes.ProofTypeDefault, nil
+		}
+		return 0, err
+	}
+	n, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return sequoiaTypes.ProofTypeDefault, nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(n.Data())
+	if err == nil && (fsn.Type() == unixfs.TDirectory || fsn.Type() == unixfs.THAMTShard) {
+		return sequoiaTypes.ProofTypeIPFSFolder, nil
+	}
+	return sequoiaTypes.ProofTypeDefault, nil
+}
+
+// migrateMetadata records metadata for every contract stored before it was written. The size is
+// measured from the blocks of the file and the proof type read from its tree, the chunk size and
+// upload parameters are unknown and left empty.
+func (f *FileSystem) migrateMetadata() error {
+	ctx := context.Background()
+
+	merkles, owners, starts, err := f.ListFiles()
+	if err != nil {
+		return fmt.Errorf("cannot list contracts | %w", err)
+	}
+
+	sizes := make(map[string]int64)
+	filled := 0
+	for i, merkle := range merkles {
+		owner, start := owners[i], starts[i]
+
+		var found bool
+		err := f.db.View(func(txn *badger.Txn) error {
+			_, err := getMetadata(txn, merkle, owner, start)
+			if err == nil {
+				found = true
+				return nil
+			}
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+
+		fcid, err := f.GetCIDFromMerkle(merkle)
+		if err != nil {
+			log.Warn().Err(err).Hex("merkle", merkle).Msg("no cid for contract, cannot record its metadata")
+			continue
+		}
+		root, err := cid.Decode(fcid)
+		if err != nil {
+			log.Warn().Err(err).Hex("merkle", merkle).Str("cid", fcid).Msg("cannot decode cid, cannot record its metadata")
+			continue
+		}
+
+		size, ok := sizes[string(merkle)]
+		if !ok {
+			size, err = f.dagSize(ctx, root)
+			if err != nil {
+				return fmt.Errorf("cannot measure %x | %w", merkle, err)
+			}
+			sizes[string(merkle)] = size
+		}
+
+		proofType, err := f.legacyProofType(ctx, merkle, owner, start, root)
+		if err != nil {
+			log.Warn().Err(err).Hex("merkle", merkle).Msg("cannot read tree, cannot record its metadata")
+			continue
+		}
+
+		err = f.db.Update(func(txn *badger.Txn) error {
+			return setMetadata(txn, &FileMetadata{
+				Merkle:    merkle,
+				Owner:     owner,
+				Start:     start,
+				Size:      size,
+				ProofType: proofType,
+				CID:       fcid,
+				Source:    SourceUnknown,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		filled++
+	}
+
+	log.Info().Int("contracts", filled).Msg("Recorded metadata of contracts stored before it")
+	return nil
+}
@@ -9,3 +9,95 @@ var fileCount = promauto.NewGauge(prometheus.GaugeOpts{
 	Name: "sequoia_file_count",
 	Help: "The number of files on disk",
 })
+
+var storedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_stored_bytes",
+	Help: "The summed size of every stored contract",
+})
+
+var tierBlocks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sequoia_blockstore_tier_blocks",
+	Help: "The number of blocks kept in each tier of a tiered blockstore",
+}, []string{"tier"})
+
+var tierBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sequoia_blockstore_tier_bytes",
+	Help: "The number of bytes kept in each tier of a tiered blockstore",
+}, []string{"tier"})
+
+var scrubChecked = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sequoia_scrub_checked_total",
+	Help: "The number of files verified by the scrubber",
+})
+
+var scrubCorrupt = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_scrub_corrupt_files",
+	Help: "The number of contracts whose data did not match their merkle root in the last scrubbing pass",
+})
+
+var scrubMissing = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_scrub_missing_files",
+	Help: "The number of contracts with missing data in the last scrubbing pass",
+})
+
+var scrubLastPass = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_scrub_last_pass_timestamp",
+	Help: "Unix time the last scrubbing pass finished",
+})
+
+var gcRemovedBlocks = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sequoia_gc_removed_blocks_total",
+	Help: "The number of unused blocks removed by garbage collection",
+})
+
+var gcRemovedBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sequoia_gc_removed_bytes_total",
+	Help: "The number of bytes reclaimed by garbage collection",
+})
+
+var dbValueLogBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_db_value_log_bytes",
+	Help: "The size of the database value log on disk",
+})
+
+var dbLSMBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_db_lsm_bytes",
+	Help: "The size of the database LSM tree on disk",
+})
+
+var dbValueLogReclaimed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sequoia_db_value_log_gc_reclaimed_bytes_total",
+	Help: "The number of bytes reclaimed by value log garbage collection",
+})
+
+var dbValueLogGCDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "sequoia_db_value_log_gc_duration_seconds",
+	Help:    "How long value log garbage collection runs take",
+	Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+})
+
+var dbCompactReclaimed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sequoia_db_compaction_reclaimed_bytes_total",
+	Help: "The number of bytes reclaimed by compacting the LSM tree",
+})
+
+var dbCompactDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "sequoia_db_compaction_duration_seconds",
+	Help:    "How long compactions of the LSM tree take",
+	Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+})
+
+var cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sequoia_proof_cache_hits_total",
+	Help: "The number of trees, tree pages and chunks served from the proof cache",
+}, []string{"kind"})
+
+var cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sequoia_proof_cache_misses_total",
+	Help: "The number of trees, tree pages and chunks that had to be read from disk",
+}, []string{"kind"})
+
+var cacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_proof_cache_bytes",
+	Help: "The number of bytes held by the proof cache",
+})
@@ -0,0 +1,275 @@
+package file_system
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	ipfs2 "github.com/JackalLabs/sequoia/ipfs"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+const diskPrefix = "disk/"
+
+// diskKey holds the disk the blocks of a file were placed on.
+func diskKey(merkle []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x", diskPrefix, merkle))
+}
+
+func setDisk(txn *badger.Txn, merkle []byte, disk string) error {
+	return txn.Set(diskKey(merkle), []byte(disk))
+}
+
+func getDisk(txn *badger.Txn, merkle []byte) (string, bool, error) {
+	item, err := txn.Get(diskKey(merkle))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	v, err := item.ValueCopy(nil)
+	return string(v), true, err
+}
+
+// fileContext points reads of a file at the disk it was placed on.
+func (f *FileSystem) fileContext(ctx context.Context, merkle []byte) context.Context {
+	if f.disks == nil {
+		return ctx
+	}
+
+	var disk string
+	var found bool
+	err := f.db.View(func(txn *badger.Txn) error {
+		var err error
+		disk, found, err = getDisk(txn, merkle)
+		return err
+	})
+	if err != nil || !found {
+		return ctx
+	}
+	return ipfs2.WithDisk(ctx, disk)
+}
+
+// placeFile picks the disk the blocks of a new file are written to. Another contract of the same file
+// keeps the disk the file is already on.
+func (f *FileSystem) placeFile(ctx context.Context, merkle []byte) (context.Context, error) {
+	if f.disks == nil {
+		return ctx, nil
+	}
+
+	var disk string
+	var found bool
+	err := f.db.View(func(txn *badger.Txn) error {
+		var err error
+		disk, found, err = getDisk(txn, merkle)
+		return err
+	})
+	if err != nil {
+		return ctx, err
+	}
+
+	if !found || f.disks.Draining(disk) {
+		disk, err = f.disks.Pick()
+		if err != nil {
+			return ctx, fmt.Errorf("cannot place file | %w", err)
+		}
+	}
+
+	return ipfs2.WithDisk(ctx, disk), nil
+}
+
+// RebalanceResult summarizes a rebalancing pass.
+type RebalanceResult struct {
+	Located int   // files stored before placement was recorded whose disk was looked up
+	Moved   int   // files moved to another disk
+	Blocks  int   // blocks moved
+	Bytes   int64 // bytes of the moved files
+}
+
+// Rebalance moves files off draining disks and from fuller disks to emptier ones. A file is only moved
+// when the disk it lands on still scores higher than the disk it leaves afterward, so repeated passes
+// settle instead of moving files back and forth. Blocks shared with a file on the old disk move along
+// and are found there by searching every disk.
+func (f *FileSystem) Rebalance(ctx context.Context, dryRun bool) (*RebalanceResult, error) {
+	if f.disks == nil {
+		return nil, errors.New("blockstore is not spread over several disks")
+	}
+
+	roots, err := f.listRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RebalanceResult{}
+
+	// simulated free space so dry runs and moves of files still being counted agree
+	scores := make(map[string]int64)
+	for _, name := range f.disks.Disks() {
+		score, err := f.disks.Score(name)
+		if err != nil {
+			return nil, err
+		}
+		scores[name] = int64(score)
+	}
+
+	for _, r := range roots {
+		merkle := r.merkle
+		c, err := cid.Decode(r.root)
+		if err != nil {
+			log.Warn().Err(err).Str("cid", r.root).Msg("failed to decode CID, skipping")
+			continue
+		}
+
+		var disk string
+		var found bool
+		var size int64
+		err = f.db.View(func(txn *badger.Txn) error {
+			disk, found, err = getDisk(txn, merkle)
+			if err != nil {
+				return err
+			}
+			owner, start, ok, err := firstContract(txn, merkle)
+			if err != nil || !ok {
+				return err
+			}
+			meta, err := getMetadata(txn, merkle, owner, start)
+			if err == nil {
+				size = meta.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+
+		if !found {
+			disk, found, err = f.disks.Locate(ctx, c)
+			if err != nil {
+				return res, err
+			}
+			if !found {
+				log.Warn().Hex("merkle", merkle).Msg("root block is on no disk, skipping")
+				continue
+			}
+			if !dryRun {
+				err = f.db.Update(func(txn *badger.Txn) error {
+					return setDisk(txn, merkle, disk)
+				})
+				if err != nil {
+					return res, err
+				}
+			}
+			res.Located++
+		}
+
+		// files stored before metadata was recorded are measured from their blocks
+		if size == 0 {
+			size, err = f.dagSize(ipfs2.WithDisk(ctx, disk), c)
+			if err != nil {
+				return res, fmt.Errorf("cannot measure %x | %w", merkle, err)
+			}
+		}
+
+		target := ""
+		for name, score := range scores {
+			if name != disk && (target == "" || score > scores[target]) {
+				target = name
+			}
+		}
+		if target == "" {
+			continue
+		}
+
+		if !f.disks.Draining(disk) {
+			// only move when the target still wins once the file has moved
+			after := scores[target] - size*int64(f.disks.Weight(target))
+			before := scores[disk] + size*int64(f.disks.Weight(disk))
+			if after <= before {
+				continue
+			}
+		}
+
+		if !dryRun {
+			blocks, err := f.moveFile(ctx, merkle, c, disk, target)
+			if err != nil {
+				return res, fmt.Errorf("cannot move %x to %s | %w", merkle, target, err)
+			}
+			res.Blocks += blocks
+		}
+
+		if _, ok := scores[disk]; ok {
+			scores[disk] += size * int64(f.disks.Weight(disk))
+		}
+		scores[target] -= size * int64(f.disks.Weight(target))
+		res.Moved++
+		res.Bytes += size
+	}
+
+	return res, nil
+}
+
+// storedRoot is a stored file and the cid of its root block.
+type storedRoot struct {
+	merkle []byte
+	root   string
+}
+
+// listRoots lists every stored file with the cid of its root block.
+func (f *FileSystem) listRoots() ([]storedRoot, error) {
+	roots := make([]storedRoot, 0)
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("cid/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			merkle, err := hex.DecodeString(string(item.Key()[len(prefix):]))
+			if err != nil {
+				log.Warn().Err(err).Str("key", string(item.Key())).Msg("skipping invalid cid key")
+				continue
+			}
+			root, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			roots = append(roots, storedRoot{merkle: merkle, root: string(root)})
+		}
+		return nil
+	})
+
+	return roots, err
+}
+
+// moveFile copies the blocks of a file to another disk, records the new disk and then removes the
+// blocks from the old one.
+func (f *FileSystem) moveFile(ctx context.Context, merkle []byte, root cid.Cid, from string, to string) (int, error) {
+	done := f.startWrite()
+	defer done()
+
+	cids, err := f.dagBlocks(ipfs2.WithDisk(ctx, from), root, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, c := range cids {
+		ok, err := f.disks.MoveBlock(ctx, c, from, to)
+		if err != nil {
+			return moved, err
+		}
+		if ok {
+			moved++
+		}
+	}
+
+	err = f.db.Update(func(txn *badger.Txn) error {
+		return setDisk(txn, merkle, to)
+	})
+	return moved, err
+}
@@ -0,0 +1,229 @@
+package file_system
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Every proof attempt is kept under proofs/<merkle>/<owner>/<start>/<time> so the history of a
+// contract sorts oldest first, proofstatus/<merkle>/<owner>/<start> sums it up so the health of
+// every contract can be listed without reading all of their attempts.
+const (
+	proofHistoryPrefix = "proofs/"
+	proofStatusPrefix  = "proofstatus/"
+)
+
+func proofHistoryKey(merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%s/%d/", proofHistoryPrefix, merkle, owner, start))
+}
+
+func proofAttemptKey(merkle []byte, owner string, start int64, t time.Time) []byte {
+	return fmt.Appendf(proofHistoryKey(merkle, owner, start), "%020d", t.UnixNano())
+}
+
+func proofStatusKey(merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%s/%d", proofStatusPrefix, merkle, owner, start))
+}
+
+// ProofStatus sums up the proof history of a contract.
+type ProofStatus struct {
+	Merkle      string                     `json:"merkle"`
+	Owner       string                     `json:"owner"`
+	Start       int64                      `json:"start"`
+	Window      int64                      `json:"window"`   // last known proof interval of the contract
+	Attempts    int64                      `json:"attempts"` // every attempt ever made, including the ones trimmed away
+	Failures    int64                      `json:"failures"` // failed attempts since the last proof landed
+	LastAttempt *sequoiaTypes.ProofAttempt `json:"last_attempt"`
+	LastProven  *sequoiaTypes.ProofAttempt `json:"last_proven"`
+}
+
+// AtRisk reports whether the contract is failing and has gone a whole proof window without a
+// proof landing, which is when the chain starts counting it against the provider.
+func (s *ProofStatus) AtRisk() bool {
+	if s.Failures == 0 || s.LastAttempt == nil {
+		return false
+	}
+	if s.LastProven == nil || s.Window <= 0 {
+		return true
+	}
+	return s.LastAttempt.Height-s.LastProven.Height >= s.Window
+}
+
+func getProofStatus(txn *badger.Txn, merkle []byte, owner string, start int64) (*ProofStatus, error) {
+	item, err := txn.Get(proofStatusKey(merkle, owner, start))
+	if err != nil {
+		return nil, err
+	}
+
+	var s ProofStatus
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// trimProofHistory removes the oldest attempts of a contract until at most limit are left.
+func trimProofHistory(txn *badger.Txn, merkle []byte, owner string, start int64, limit int) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = proofHistoryKey(merkle, owner, start)
+
+	it := txn.NewIterator(opts)
+	keys := make([][]byte, 0)
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	if limit < 0 {
+		limit = 0
+	}
+	for len(keys) > limit {
+		err := txn.Delete(keys[0])
+		if err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+func deleteProofHistory(txn *badger.Txn, merkle []byte, owner string, start int64) error {
+	err := trimProofHistory(txn, merkle, owner, start, 0)
+	if err != nil {
+		return err
+	}
+	return txn.Delete(proofStatusKey(merkle, owner, start))
+}
+
+// SetProofHistoryLimit sets how many attempts are kept for each contract, older ones are dropped
+// as new ones are recorded. Zero or less keeps every attempt.
+func (f *FileSystem) SetProofHistoryLimit(limit int) {
+	f.historyLimit.Store(int64(limit))
+}
+
+// RecordProofAttempt adds an attempt to the history of a contract and updates its status.
+func (f *FileSystem) RecordProofAttempt(merkle []byte, owner string, start int64, attempt *sequoiaTypes.ProofAttempt) error {
+	if attempt.Time.IsZero() {
+		attempt.Time = time.Now()
+	}
+
+	value, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+
+	limit := int(f.historyLimit.Load())
+
+	return f.db.Update(func(txn *badger.Txn) error {
+		s, err := getProofStatus(txn, merkle, owner, start)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			s = &ProofStatus{
+				Merkle: fmt.Sprintf("%x", merkle),
+				Owner:  owner,
+				Start:  start,
+			}
+		} else if err != nil {
+			return err
+		}
+
+		s.Attempts++
+		s.LastAttempt = attempt
+		if attempt.Window > 0 {
+			s.Window = attempt.Window
+		}
+		if attempt.Proven() {
+			s.Failures = 0
+			s.LastProven = attempt
+		} else {
+			s.Failures++
+		}
+
+		status, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Set(proofAttemptKey(merkle, owner, start, attempt.Time), value)
+		if err != nil {
+			return err
+		}
+		err = txn.Set(proofStatusKey(merkle, owner, start), status)
+		if err != nil {
+			return err
+		}
+
+		if limit <= 0 {
+			return nil
+		}
+		return trimProofHistory(txn, merkle, owner, start, limit)
+	})
+}
+
+// ProofHistory returns the kept attempts of a contract newest first, along with its status.
+func (f *FileSystem) ProofHistory(merkle []byte, owner string, start int64) ([]sequoiaTypes.ProofAttempt, *ProofStatus, error) {
+	attempts := make([]sequoiaTypes.ProofAttempt, 0)
+	var status *ProofStatus
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		var err error
+		status, err = getProofStatus(txn, merkle, owner, start)
+		if err != nil {
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = proofHistoryKey(merkle, owner, start)
+		opts.Reverse = true
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		// reverse iteration starts from the last key sharing the prefix
+		for it.Seek(append(proofHistoryKey(merkle, owner, start), 0xff)); it.Valid(); it.Next() {
+			var a sequoiaTypes.ProofAttempt
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &a)
+			})
+			if err != nil {
+				return err
+			}
+			attempts = append(attempts, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attempts, status, nil
+}
+
+// ProofStatuses returns the status of every contract with at least one recorded proof attempt.
+func (f *FileSystem) ProofStatuses() ([]ProofStatus, error) {
+	statuses := make([]ProofStatus, 0)
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(proofStatusPrefix), PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var s ProofStatus
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &s)
+			})
+			if err != nil {
+				return err
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+
+	return statuses, err
+}
@@ -0,0 +1,267 @@
+package file_system
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	ipfslite "github.com/hsanjuan/ipfs-lite"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+// SourceRebuild marks contracts whose records were rebuilt from the blockstore.
+const SourceRebuild = "rebuild"
+
+// RebuildContract is a contract on chain this provider is meant to be storing.
+type RebuildContract struct {
+	Merkle     []byte
+	Owner      string
+	Start      int64
+	ProofType  int64
+	IPFSParams *ipfslite.AddParams
+}
+
+// RebuildFailure is a contract whose records could not be rebuilt.
+type RebuildFailure struct {
+	Contract RebuildContract
+	Reason   string
+}
+
+// RebuildResult describes what RebuildIndex recovered.
+type RebuildResult struct {
+	Contracts  int              // contracts on chain
+	Indexed    int              // contracts that already had a tree and were left alone
+	Recovered  int              // contracts whose tree, cid and metadata were rebuilt
+	Relaid     int              // files whose blocks were added again since the note asks for another layout
+	Candidates int              // possible file roots found in the blockstore
+	Unmatched  int              // candidates no contract hashes to, left for garbage collection
+	Failed     []RebuildFailure // contracts that could not be recovered
+}
+
+// rebuildCandidates lists the blocks that can be the root of a stored file: every block no file
+// node links to, and the entries of folders since those are often stored on their own as well.
+// The blockstore only knows multihashes, so nodes are told apart from raw leaves by decoding them.
+func (f *FileSystem) rebuildCandidates(ctx context.Context) ([]cid.Cid, error) {
+	all, err := f.listBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := f.ipfs.BlockStore()
+	nodes := make(markSet)
+	linked := make(markSet)
+	for _, c := range all {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+
+		n, err := merkledag.DecodeProtobuf(blk.RawData())
+		if err != nil {
+			continue
+		}
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			continue
+		}
+		nodes.add(c)
+
+		if fsn.Type() == unixfs.TDirectory || fsn.Type() == unixfs.THAMTShard {
+			continue
+		}
+		for _, l := range n.Links() {
+			linked.add(l.Cid)
+		}
+	}
+
+	candidates := make([]cid.Cid, 0)
+	for _, c := range all {
+		if linked.has(c) {
+			continue
+		}
+		if nodes.has(c) {
+			candidates = append(candidates, cid.NewCidV1(cid.DagProtobuf, c.Hash()))
+		} else {
+			candidates = append(candidates, cid.NewCidV1(cid.Raw, c.Hash()))
+		}
+	}
+	return candidates, nil
+}
+
+// candidateMerkles hashes the file under root into a merkle root for each proof type.
+func (f *FileSystem) candidateMerkles(ctx context.Context, root cid.Cid, chunkSize int64, proofTypes []int64) (map[int64][]byte, error) {
+	data, err := f.getRootData(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	builders := make([]*treeBuilder, len(proofTypes))
+	writers := make([]io.Writer, len(proofTypes))
+	for i, proofType := range proofTypes {
+		builders[i], err = newTreeBuilder(chunkSize, proofType)
+		if err != nil {
+			return nil, err
+		}
+		writers[i] = builders[i]
+	}
+
+	_, err = io.Copy(io.MultiWriter(writers...), data)
+	if err != nil {
+		return nil, err
+	}
+
+	merkles := make(map[int64][]byte, len(proofTypes))
+	for i, proofType := range proofTypes {
+		merkle, _, _, err := builders[i].finish()
+		if err != nil {
+			return nil, err
+		}
+		merkles[proofType] = merkle
+	}
+	return merkles, nil
+}
+
+// RebuildIndex recovers the tree, cid and metadata records of contracts whose files are still in
+// the blockstore, for when the database was lost but the blocks were not. Every possible root in
+// the blockstore is hashed to find the file of each contract, which is then added again with the
+// IPFS params from its note. That recomputes its CID, blocks that are already there are not
+// written twice. Contracts that already have a tree are left alone.
+func (f *FileSystem) RebuildIndex(ctx context.Context, contracts []RebuildContract, chunkSize int64) (*RebuildResult, error) {
+	res := &RebuildResult{
+		Contracts: len(contracts),
+		Failed:    make([]RebuildFailure, 0),
+	}
+
+	missing := make([]RebuildContract, 0)
+	wanted := make(map[string]bool)
+	proofTypes := make([]int64, 0)
+	for _, c := range contracts {
+		indexed, err := f.CheckTree(c.Merkle, c.Owner, c.Start)
+		if err != nil {
+			return nil, err
+		}
+		if indexed {
+			res.Indexed++
+			continue
+		}
+
+		missing = append(missing, c)
+		wanted[fmt.Sprintf("%x/%d", c.Merkle, c.ProofType)] = true
+		if !slices.Contains(proofTypes, c.ProofType) {
+			proofTypes = append(proofTypes, c.ProofType)
+		}
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	candidates, err := f.rebuildCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res.Candidates = len(candidates)
+
+	// stop hashing once every file was found, the rest of the candidates are garbage or unrelated
+	roots := make(map[string]cid.Cid)
+	present := make(markSet)
+	absent := make(markSet)
+	for _, root := range candidates {
+		if len(roots) == len(wanted) {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// reading a file with blocks missing would go looking for them on the network
+		complete, err := f.rootComplete(ctx, root, present, absent)
+		if err != nil {
+			return nil, err
+		}
+		if !complete {
+			res.Unmatched++
+			continue
+		}
+
+		merkles, err := f.candidateMerkles(ctx, root, chunkSize, proofTypes)
+		if err != nil {
+			log.Debug().Err(err).Str("cid", root.String()).Msg("cannot read candidate root")
+			res.Unmatched++
+			continue
+		}
+
+		matched := false
+		for proofType, merkle := range merkles {
+			key := fmt.Sprintf("%x/%d", merkle, proofType)
+			if wanted[key] {
+				if _, ok := roots[key]; !ok {
+					roots[key] = root
+				}
+				matched = true
+			}
+		}
+		if !matched {
+			res.Unmatched++
+		}
+	}
+
+	for _, c := range missing {
+		// another contract for the same file was recovered already, share its tree
+		copied, err := f.CopyContract(c.Merkle, c.Owner, c.Start)
+		if err != nil {
+			res.Failed = append(res.Failed, RebuildFailure{Contract: c, Reason: err.Error()})
+			continue
+		}
+		if copied {
+			res.Recovered++
+			continue
+		}
+
+		root, ok := roots[fmt.Sprintf("%x/%d", c.Merkle, c.ProofType)]
+		if !ok {
+			res.Failed = append(res.Failed, RebuildFailure{Contract: c, Reason: "no file in the blockstore hashes to this merkle"})
+			continue
+		}
+
+		err = f.rebuildContract(ctx, c, root, chunkSize)
+		if err != nil {
+			res.Failed = append(res.Failed, RebuildFailure{Contract: c, Reason: err.Error()})
+			continue
+		}
+		res.Recovered++
+
+		stored, err := f.GetCIDFromMerkle(c.Merkle)
+		if err == nil && stored != "" {
+			if sc, err := cid.Decode(stored); err == nil && string(sc.Hash()) != string(root.Hash()) {
+				res.Relaid++
+				f.discardBlocks(root.String())
+			}
+		}
+	}
+
+	log.Info().
+		Int("contracts", res.Contracts).
+		Int("indexed", res.Indexed).
+		Int("recovered", res.Recovered).
+		Int("failed", len(res.Failed)).
+		Int("candidates", res.Candidates).
+		Msg("Index rebuild finished")
+
+	return res, nil
+}
+
+func (f *FileSystem) rebuildContract(ctx context.Context, c RebuildContract, root cid.Cid, chunkSize int64) error {
+	data, err := f.getRootData(ctx, root)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	_, _, err = f.WriteFile(data, c.Merkle, c.Owner, c.Start, chunkSize, c.ProofType, c.IPFSParams, SourceRebuild)
+	return err
+}
@@ -0,0 +1,141 @@
+package file_system
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// The proof schedule is a persistent priority index of contracts ordered by the height their next proof is due.
+// schedule/<due>/<merkle>/<owner>/<start> keys sort by due height, due/<merkle>/<owner>/<start> points back to the
+// current entry so a contract can be rescheduled without scanning the index.
+const (
+	schedulePrefix = "schedule/"
+	duePrefix      = "due/"
+)
+
+func scheduleKey(due int64, merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%020d/%x/%s/%d", schedulePrefix, due, merkle, owner, start))
+}
+
+func dueKey(merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%s/%d", duePrefix, merkle, owner, start))
+}
+
+// splitScheduleKey parses the due height and contract out of a schedule key without its prefix.
+func splitScheduleKey(key []byte) (due int64, merkle []byte, owner string, start int64, err error) {
+	d, rest, found := strings.Cut(string(key), "/")
+	if !found {
+		return 0, nil, "", 0, fmt.Errorf("malformed schedule key %s", key)
+	}
+
+	due, err = strconv.ParseInt(d, 10, 64)
+	if err != nil {
+		return 0, nil, "", 0, err
+	}
+
+	merkle, owner, start, err = SplitMerkle([]byte(rest))
+	return due, merkle, owner, start, err
+}
+
+func getDue(txn *badger.Txn, merkle []byte, owner string, start int64) (int64, bool, error) {
+	item, err := txn.Get(dueKey(merkle, owner, start))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	var due int64
+	err = item.Value(func(val []byte) error {
+		due, err = strconv.ParseInt(string(val), 10, 64)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return due, true, nil
+}
+
+func setSchedule(txn *badger.Txn, merkle []byte, owner string, start int64, due int64) error {
+	if due < 0 {
+		due = 0
+	}
+
+	err := removeSchedule(txn, merkle, owner, start)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Set(scheduleKey(due, merkle, owner, start), nil)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set(dueKey(merkle, owner, start), []byte(strconv.FormatInt(due, 10)))
+}
+
+func removeSchedule(txn *badger.Txn, merkle []byte, owner string, start int64) error {
+	due, found, err := getDue(txn, merkle, owner, start)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	err = txn.Delete(scheduleKey(due, merkle, owner, start))
+	if err != nil {
+		return err
+	}
+
+	return txn.Delete(dueKey(merkle, owner, start))
+}
+
+// ScheduleProof records the height at which the next proof for a contract is due, replacing any earlier entry.
+func (f *FileSystem) ScheduleProof(merkle []byte, owner string, start int64, due int64) error {
+	return f.db.Update(func(txn *badger.Txn) error {
+		return setSchedule(txn, merkle, owner, start, due)
+	})
+}
+
+// GetProofDue returns the height at which the next proof for a contract is due and whether it is scheduled at all.
+func (f *FileSystem) GetProofDue(merkle []byte, owner string, start int64) (due int64, found bool, err error) {
+	err = f.db.View(func(txn *badger.Txn) error {
+		due, found, err = getDue(txn, merkle, owner, start)
+		return err
+	})
+	return due, found, err
+}
+
+// ProcessDueFiles calls fn for every scheduled contract whose proof is due at or before height, earliest first.
+func (f *FileSystem) ProcessDueFiles(height int64, fn func(merkle []byte, owner string, start int64)) error {
+	return f.db.View(func(txn *badger.Txn) error {
+		prefix := []byte(schedulePrefix)
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			k := it.Item().Key()
+			due, merkle, owner, start, err := splitScheduleKey(k[len(prefix):])
+			if err != nil {
+				return err
+			}
+
+			if due > height {
+				return nil
+			}
+
+			fn(merkle, owner, start)
+		}
+		return nil
+	})
+}
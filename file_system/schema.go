@@ -0,0 +1,152 @@
+package file_system
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+)
+
+const schemaVersionKey = "schema/version"
+
+// ErrSchemaOutdated is returned when the database has migrations pending.
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// Migration upgrades the database from the previous schema version to Version.
+type Migration struct {
+	Version int
+	Name    string
+	run     func(f *FileSystem) error
+}
+
+// migrations upgrade the key layout in order. A change to the layout gets a new entry at the end,
+// entries that shipped are never changed since databases may already be past them.
+var migrations = []Migration{
+	{Version: 1, Name: "index contracts by merkle", run: (*FileSystem).migrateContractIndex},
+	{Version: 2, Name: "record metadata of contracts stored before it", run: (*FileSystem).migrateMetadata},
+}
+
+// LatestSchemaVersion is the schema version this build writes.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func getSchemaVersion(txn *badger.Txn) (version int, found bool, err error) {
+	item, err := txn.Get([]byte(schemaVersionKey))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("invalid schema version")
+		}
+		version = int(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return version, true, err
+}
+
+func setSchemaVersion(txn *badger.Txn, version int) error {
+	return txn.Set([]byte(schemaVersionKey), binary.BigEndian.AppendUint64(nil, uint64(version)))
+}
+
+// initSchema stamps a new database with the latest version, databases written before versions were
+// recorded are left at version 0 so every migration runs on them.
+func initSchema(db *badger.DB) error {
+	return db.Update(func(txn *badger.Txn) error {
+		version, found, err := getSchemaVersion(txn)
+		if err != nil {
+			return err
+		}
+		if found {
+			if version > LatestSchemaVersion() {
+				return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, LatestSchemaVersion())
+			}
+			return nil
+		}
+
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		defer it.Close()
+		it.Rewind()
+		if it.Valid() {
+			return nil
+		}
+
+		return setSchemaVersion(txn, LatestSchemaVersion())
+	})
+}
+
+// SchemaVersion returns the schema version of the database.
+func (f *FileSystem) SchemaVersion() (version int, err error) {
+	err = f.db.View(func(txn *badger.Txn) error {
+		version, _, err = getSchemaVersion(txn)
+		return err
+	})
+	return version, err
+}
+
+// PendingMigrations lists the migrations the database still needs.
+func (f *FileSystem) PendingMigrations() ([]Migration, error) {
+	version, err := f.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]Migration, 0)
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// CheckSchema returns ErrSchemaOutdated if the database has migrations pending.
+func (f *FileSystem) CheckSchema() error {
+	pending, err := f.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w, %d migrations pending up to version %d", ErrSchemaOutdated, len(pending), LatestSchemaVersion())
+	}
+	return nil
+}
+
+// Migrate runs the pending migrations in order. The version is recorded after each one so an
+// interrupted run picks up at the migration that failed.
+func (f *FileSystem) Migrate() error {
+	pending, err := f.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		log.Info().Int("version", m.Version).Str("migration", m.Name).Msg("Migrating database...")
+
+		err := m.run(f)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed | %w", m.Version, m.Name, err)
+		}
+
+		err = f.db.Update(func(txn *badger.Txn) error {
+			return setSchemaVersion(txn, m.Version)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// migrations can record the sizes of contracts that were not counted when the database was opened
+	return f.countStoredBytes()
+}
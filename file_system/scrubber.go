@@ -0,0 +1,419 @@
+package file_system
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipldFormat "github.com/ipfs/go-ipld-format"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ScrubOK      = "ok"
+	ScrubMissing = "missing"
+	ScrubCorrupt = "corrupt"
+
+	scrubPrefix    = "scrub/"
+	scrubStatusKey = "scrubstatus"
+)
+
+// RepairFunc fetches a fresh copy of a damaged file, usually from another provider.
+type RepairFunc func(merkle []byte, owner string, start int64) error
+
+// ScrubRecord is the outcome of verifying a single contract's data against its merkle root.
+// Only damaged contracts are kept in the database, they are removed again once a pass finds them healthy.
+type ScrubRecord struct {
+	Merkle    string    `json:"merkle"`
+	Owner     string    `json:"owner"`
+	Start     int64     `json:"start"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	Repaired  bool      `json:"repaired"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// ScrubStatus summarizes the most recent scrubbing pass.
+type ScrubStatus struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Checked  int       `json:"checked"`
+	Corrupt  int       `json:"corrupt"`
+	Missing  int       `json:"missing"`
+}
+
+func scrubKey(merkle []byte, owner string, start int64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%s/%d", scrubPrefix, merkle, owner, start))
+}
+
+// Scrubber periodically re-reads every stored file and rebuilds its merkle root to catch
+// damaged blockstore data before a proof fails on-chain.
+type Scrubber struct {
+	f         *FileSystem
+	chunkSize int64
+	interval  time.Duration
+	delay     time.Duration
+	repair    RepairFunc
+	running   bool
+	lastPass  time.Time
+	lock      sync.Mutex
+}
+
+// NewScrubber creates a scrubber that starts a pass every interval and pauses for delay between files.
+// repair may be nil, in which case damaged files are only reported.
+func NewScrubber(f *FileSystem, chunkSize int64, interval time.Duration, delay time.Duration, repair RepairFunc) *Scrubber {
+	s := &Scrubber{
+		f:         f,
+		chunkSize: chunkSize,
+		interval:  interval,
+		delay:     delay,
+		repair:    repair,
+	}
+
+	status, err := f.GetScrubStatus()
+	if err == nil {
+		s.lastPass = status.Finished // don't rescrub everything after every restart
+	}
+
+	return s
+}
+
+func (s *Scrubber) Start() {
+	s.running = true
+	defer log.Info().Msg("Scrubber stopped")
+
+	for s.running {
+		time.Sleep(time.Second)
+		if !s.lastPass.Add(s.interval).Before(time.Now()) {
+			continue
+		}
+
+		err := s.Pass()
+		if err != nil {
+			log.Error().Err(err).Msg("scrubbing pass failed")
+		}
+		s.lastPass = time.Now()
+	}
+}
+
+func (s *Scrubber) Stop() {
+	s.running = false
+}
+
+// Pass verifies every stored contract once. Contracts sharing a merkle are only read once.
+func (s *Scrubber) Pass() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	log.Info().Msg("Starting scrubbing pass...")
+	status := ScrubStatus{Started: time.Now()}
+
+	merkles, owners, starts, err := s.f.ListFiles()
+	if err != nil {
+		return fmt.Errorf("cannot list files to scrub | %w", err)
+	}
+
+	results := make(map[string]*ScrubRecord)
+	for i, merkle := range merkles {
+		if !s.running {
+			break
+		}
+		owner := owners[i]
+		start := starts[i]
+
+		r, checked := results[string(merkle)]
+		if !checked {
+			r = s.scrubFile(merkle, owner, start)
+			results[string(merkle)] = r
+			time.Sleep(s.delay)
+		}
+
+		record := *r
+		record.Owner = owner
+		record.Start = start
+
+		status.Checked++
+		switch record.Status {
+		case ScrubCorrupt:
+			status.Corrupt++
+		case ScrubMissing:
+			status.Missing++
+		}
+
+		err := s.f.saveScrubRecord(merkle, owner, start, &record)
+		if err != nil {
+			log.Warn().Err(err).Hex("merkle", merkle).Msg("could not save scrub result")
+		}
+	}
+
+	status.Finished = time.Now()
+	scrubCorrupt.Set(float64(status.Corrupt))
+	scrubMissing.Set(float64(status.Missing))
+	scrubLastPass.Set(float64(status.Finished.Unix()))
+
+	log.Info().
+		Int("checked", status.Checked).
+		Int("corrupt", status.Corrupt).
+		Int("missing", status.Missing).
+		Dur("took", status.Finished.Sub(status.Started)).
+		Msg("Scrubbing pass finished")
+
+	return s.f.saveScrubStatus(&status)
+}
+
+// scrubFile checks one file and tries to repair it if a repair function is configured.
+func (s *Scrubber) scrubFile(merkle []byte, owner string, start int64) *ScrubRecord {
+	scrubChecked.Inc()
+
+	status, reason, corrupt := s.f.verifyFile(context.Background(), merkle, s.chunkSize)
+	r := &ScrubRecord{
+		Merkle:    hex.EncodeToString(merkle),
+		Status:    status,
+		Reason:    reason,
+		CheckedAt: time.Now(),
+	}
+	if status == ScrubOK {
+		return r
+	}
+
+	log.Warn().Hex("merkle", merkle).Str("status", status).Str("reason", reason).Msg("Scrubber found a damaged file")
+
+	if s.repair == nil {
+		return r
+	}
+
+	// damaged blocks have to go for the repair to fetch them again, they are only removed when
+	// repairing so a report only scrub leaves the blockstore as it found it
+	bs := s.f.ipfs.BlockStore()
+	for _, c := range corrupt {
+		err := bs.DeleteBlock(context.Background(), c)
+		if err != nil {
+			log.Warn().Err(err).Str("cid", c.String()).Msg("could not remove corrupted block")
+		}
+	}
+
+	err := s.repair(merkle, owner, start)
+	if err != nil {
+		log.Warn().Err(err).Hex("merkle", merkle).Msg("could not repair damaged file")
+		return r
+	}
+
+	// the repaired blocks may differ from what was cached while the file was damaged
+	s.f.cache.dropMerkle(merkle)
+
+	status, reason = s.f.VerifyFile(context.Background(), merkle, s.chunkSize)
+	r.Status = status
+	r.Reason = reason
+	r.Repaired = status == ScrubOK
+	r.CheckedAt = time.Now()
+
+	return r
+}
+
+// VerifyFile checks every block of a file against its CID, then rebuilds the merkle root from the file data.
+// It only reports what it finds, damaged blocks are left in place.
+func (f *FileSystem) VerifyFile(ctx context.Context, merkle []byte, chunkSize int64) (status string, reason string) {
+	status, reason, _ = f.verifyFile(ctx, merkle, chunkSize)
+	return status, reason
+}
+
+// verifyFile is VerifyFile that also returns the blocks that fail their hash check.
+func (f *FileSystem) verifyFile(ctx context.Context, merkle []byte, chunkSize int64) (status string, reason string, corrupt []cid.Cid) {
+	fcid, err := f.GetCIDFromMerkle(merkle)
+	if err != nil {
+		return ScrubMissing, fmt.Sprintf("no cid for merkle: %s", err.Error()), nil
+	}
+
+	root, err := cid.Decode(fcid)
+	if err != nil {
+		return ScrubCorrupt, fmt.Sprintf("cannot decode cid %s: %s", fcid, err.Error()), nil
+	}
+
+	missing, corrupt, err := f.checkBlocks(ctx, root)
+	if err != nil {
+		return ScrubCorrupt, err.Error(), nil
+	}
+	if len(corrupt) > 0 {
+		f.cache.dropMerkle(merkle)
+		return ScrubCorrupt, fmt.Sprintf("%d blocks do not match their cid", len(corrupt)), corrupt
+	}
+	if len(missing) > 0 {
+		f.cache.dropMerkle(merkle)
+		return ScrubMissing, fmt.Sprintf("%d blocks missing from blockstore", len(missing)), nil
+	}
+
+	// files stored before metadata was recorded don't know their proof type, try every candidate
+	proofTypes := []int64{sequoiaTypes.ProofTypeDefault, sequoiaTypes.ProofTypeBlake3}
+	proofType, found, err := f.storedProofType(merkle)
+	if err == nil && found {
+		proofTypes = []int64{proofType}
+	}
+
+	for _, proofType := range proofTypes {
+		data, err := f.GetFileData(merkle)
+		if err != nil {
+			return ScrubMissing, fmt.Sprintf("cannot read file data: %s", err.Error()), nil
+		}
+
+		r, _, _, err := BuildTree(data, chunkSize, proofType)
+		_ = data.Close()
+		if err != nil {
+			return ScrubCorrupt, fmt.Sprintf("cannot build tree: %s", err.Error()), nil
+		}
+
+		if bytes.Equal(r, merkle) {
+			return ScrubOK, "", nil
+		}
+	}
+
+	f.cache.dropMerkle(merkle)
+	return ScrubCorrupt, "rebuilt merkle root does not match", nil
+}
+
+// checkBlocks walks a DAG using only the local blockstore, never the network, and returns
+// the blocks that are missing and the ones whose data no longer hashes to their CID.
+func (f *FileSystem) checkBlocks(ctx context.Context, root cid.Cid) (missing []cid.Cid, corrupt []cid.Cid, err error) {
+	bs := f.ipfs.BlockStore()
+
+	toVisit := []cid.Cid{root}
+	visited := make(map[cid.Cid]struct{})
+
+	for len(toVisit) > 0 {
+		c := toVisit[0]
+		toVisit = toVisit[1:]
+
+		if _, ok := visited[c]; ok {
+			continue
+		}
+		visited[c] = struct{}{}
+
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			if ipldFormat.IsNotFound(err) {
+				missing = append(missing, c)
+				continue
+			}
+			return nil, nil, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+
+		sum, err := c.Prefix().Sum(blk.RawData())
+		if err != nil || !sum.Equals(c) {
+			corrupt = append(corrupt, c)
+			continue
+		}
+
+		links, err := blockLinks(blk)
+		if err != nil {
+			corrupt = append(corrupt, c)
+			continue
+		}
+		for _, l := range links {
+			toVisit = append(toVisit, l.Cid)
+		}
+	}
+
+	return missing, corrupt, nil
+}
+
+func blockLinks(blk blocks.Block) ([]*ipldFormat.Link, error) {
+	var n ipldFormat.Node
+	var err error
+	switch blk.Cid().Type() {
+	case cid.DagProtobuf:
+		n, err = merkledag.DecodeProtobufBlock(blk)
+	case cid.Raw:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %d", blk.Cid().Type())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return n.Links(), nil
+}
+
+func (f *FileSystem) saveScrubRecord(merkle []byte, owner string, start int64, record *ScrubRecord) error {
+	return f.db.Update(func(txn *badger.Txn) error {
+		if record.Status == ScrubOK {
+			err := txn.Delete(scrubKey(merkle, owner, start))
+			if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			return nil
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return txn.Set(scrubKey(merkle, owner, start), data)
+	})
+}
+
+func (f *FileSystem) saveScrubStatus(status *ScrubStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	return f.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(scrubStatusKey), data)
+	})
+}
+
+// GetScrubStatus returns the summary of the last finished scrubbing pass.
+func (f *FileSystem) GetScrubStatus() (*ScrubStatus, error) {
+	var status ScrubStatus
+	err := f.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(scrubStatusKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &status)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// ListScrubRecords returns every contract the scrubber found damaged in its last check of it.
+func (f *FileSystem) ListScrubRecords() ([]ScrubRecord, error) {
+	records := make([]ScrubRecord, 0)
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		prefix := []byte(scrubPrefix)
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var r ScrubRecord
+				err := json.Unmarshal(val, &r)
+				if err != nil {
+					return err
+				}
+				records = append(records, r)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return records, err
+}
@@ -0,0 +1,194 @@
+package file_system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	ipfs2 "github.com/JackalLabs/sequoia/ipfs"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// ErrInsufficientSpace is returned when storing a file would exceed the space offered on chain or
+// eat into the free disk space that is kept in reserve.
+var ErrInsufficientSpace = errors.New("insufficient storage space")
+
+// SpaceUsage is the local view of storage space, next to the on-chain numbers.
+type SpaceUsage struct {
+	Offered     int64 // total_bytes_offered, 0 when it isn't enforced
+	Stored      int64 // sum of the sizes of every stored contract
+	Reserved    int64 // bytes of uploads and strays still being written
+	DiskFree    int64 // free bytes on the fullest data disk, -1 when no disk is checked
+	DiskReserve int64 // free bytes that are never filled
+}
+
+// spaceAccountant tracks the bytes of stored contracts so new files can be checked against the
+// offered space without walking the database.
+type spaceAccountant struct {
+	lock     sync.Mutex
+	offered  int64
+	reserve  int64
+	dirs     []string
+	stored   int64
+	reserved int64
+}
+
+// SetSpaceLimits sets the space offered on chain and the free disk space to keep in reserve. dirs
+// are the directories holding the database and blocks, a blockstore spread over several disks is
+// checked on its emptiest disk since that is where the next file goes.
+func (f *FileSystem) SetSpaceLimits(offered int64, reserve int64, dirs []string) {
+	f.space.lock.Lock()
+	defer f.space.lock.Unlock()
+
+	f.space.offered = offered
+	f.space.reserve = reserve
+	f.space.dirs = make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		f.space.dirs = append(f.space.dirs, os.ExpandEnv(dir))
+	}
+}
+
+// countStoredBytes adds up the sizes of every contract with metadata. Contracts stored before
+// metadata was recorded only count once the migration recording it has run.
+func (f *FileSystem) countStoredBytes() error {
+	metas, err := f.ListFileMetadata()
+	if err != nil {
+		return err
+	}
+
+	var stored int64
+	for _, meta := range metas {
+		stored += meta.Size
+	}
+
+	f.space.lock.Lock()
+	f.space.stored = stored
+	f.space.lock.Unlock()
+	storedBytes.Set(float64(stored))
+
+	return nil
+}
+
+// addStoredBytes is called once a transaction changing the metadata of a contract committed, which
+// also counts as activity for maintenance waiting on an idle provider.
+func (f *FileSystem) addStoredBytes(delta int64) {
+	f.lastWrite.Store(time.Now().UnixNano())
+	if delta == 0 {
+		return
+	}
+
+	f.space.lock.Lock()
+	f.space.stored += delta
+	stored := f.space.stored
+	f.space.lock.Unlock()
+	storedBytes.Set(float64(stored))
+}
+
+// contractSize returns the recorded size of a contract, 0 if it has no metadata.
+func contractSize(txn *badger.Txn, merkle []byte, owner string, start int64) (int64, error) {
+	meta, err := getMetadata(txn, merkle, owner, start)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return meta.Size, nil
+}
+
+// diskFree returns the free space of the fullest data disk.
+func (f *FileSystem) diskFree() (free int64, checked bool, err error) {
+	free = -1
+	for _, dir := range f.space.dirs {
+		n, err := ipfs2.FreeSpace(dir)
+		if err != nil {
+			return 0, false, fmt.Errorf("cannot read free space of %s | %w", dir, err)
+		}
+		if free < 0 || int64(n) < free {
+			free = int64(n)
+		}
+	}
+
+	if f.disks != nil {
+		var best int64 = -1
+		for _, name := range f.disks.Disks() {
+			n, err := f.disks.Free(name)
+			if err != nil {
+				return 0, false, err
+			}
+			if int64(n) > best {
+				best = int64(n)
+			}
+		}
+		if best >= 0 && (free < 0 || best < free) {
+			free = best
+		}
+	}
+
+	return free, free >= 0, nil
+}
+
+// ReserveSpace checks that a file of size bytes fits in the offered space and leaves the disk
+// reserve untouched, and holds the space until release is called. Files being written are counted
+// as if none of their data was on disk yet, so concurrent writes can't overshoot the reserve.
+func (f *FileSystem) ReserveSpace(size int64) (release func(), err error) {
+	f.space.lock.Lock()
+	defer f.space.lock.Unlock()
+
+	s := &f.space
+	if s.offered > 0 && s.stored+s.reserved+size > s.offered {
+		return nil, fmt.Errorf("%w, %d bytes would exceed the %d bytes offered (%d stored, %d being written)",
+			ErrInsufficientSpace, size, s.offered, s.stored, s.reserved)
+	}
+
+	free, checked, err := f.diskFree()
+	if err != nil {
+		return nil, err
+	}
+	if checked && free-s.reserved-size < s.reserve {
+		return nil, fmt.Errorf("%w, %d bytes would leave less than the %d bytes kept in reserve (%d free, %d being written)",
+			ErrInsufficientSpace, size, s.reserve, free, s.reserved)
+	}
+
+	s.reserved += size
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.space.lock.Lock()
+			f.space.reserved -= size
+			f.space.lock.Unlock()
+		})
+	}, nil
+}
+
+// CheckSpace reports whether a file of size bytes would currently fit, without holding the space.
+func (f *FileSystem) CheckSpace(size int64) error {
+	release, err := f.ReserveSpace(size)
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+// SpaceUsage reports the offered, stored and free space.
+func (f *FileSystem) SpaceUsage() (SpaceUsage, error) {
+	f.space.lock.Lock()
+	defer f.space.lock.Unlock()
+
+	free, _, err := f.diskFree()
+	if err != nil {
+		return SpaceUsage{}, err
+	}
+
+	return SpaceUsage{
+		Offered:     f.space.offered,
+		Stored:      f.space.stored,
+		Reserved:    f.space.reserved,
+		DiskFree:    free,
+		DiskReserve: f.space.reserve,
+	}, nil
+}
@@ -0,0 +1,370 @@
+package file_system
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	servedPrefix = "served/"
+	coldPrefix   = "cold/"
+)
+
+// servedKey holds the last time a file was served to a client.
+func servedKey(merkle []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x", servedPrefix, merkle))
+}
+
+// coldKey marks a file whose blocks were moved to the cold tier.
+func coldKey(merkle []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x", coldPrefix, merkle))
+}
+
+// TierResult summarizes a demotion pass.
+type TierResult struct {
+	Files  int // files moved to the cold tier
+	Blocks int // blocks moved to the cold tier
+}
+
+// Tiered reports whether the blockstore has a cold tier.
+func (f *FileSystem) Tiered() bool {
+	return f.tiers != nil
+}
+
+func servedValue(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.Unix()))
+}
+
+func setServed(txn *badger.Txn, merkle []byte, t time.Time) error {
+	return txn.Set(servedKey(merkle), servedValue(t))
+}
+
+func getServed(txn *badger.Txn, merkle []byte) (time.Time, bool, error) {
+	item, err := txn.Get(servedKey(merkle))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+
+	var t time.Time
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("invalid served time for %x", merkle)
+		}
+		t = time.Unix(int64(binary.BigEndian.Uint64(val)), 0)
+		return nil
+	})
+	return t, true, err
+}
+
+// resetTier is called when a file is written, its blocks are in the hot tier again.
+func resetTier(txn *badger.Txn, merkle []byte) error {
+	err := txn.Delete(coldKey(merkle))
+	if err != nil {
+		return err
+	}
+	return setServed(txn, merkle, time.Now())
+}
+
+// clearTier drops the tiering state of a deleted file.
+func clearTier(txn *badger.Txn, merkle []byte) error {
+	err := txn.Delete(coldKey(merkle))
+	if err != nil {
+		return err
+	}
+	return txn.Delete(servedKey(merkle))
+}
+
+// FileServed records that a file was read by a client. Files that keep being served stay in the
+// hot tier and files that were demoted are moved back in the background.
+func (f *FileSystem) FileServed(merkle []byte) {
+	if f.tiers == nil {
+		return
+	}
+
+	cold := false
+	err := f.db.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(coldKey(merkle))
+		if err == nil {
+			cold = true
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return setServed(txn, merkle, time.Now())
+	})
+	if err != nil {
+		log.Warn().Err(err).Hex("merkle", merkle).Msg("could not record served file")
+		return
+	}
+
+	if cold {
+		go f.promoteFile(merkle)
+	}
+}
+
+// dagBlocks lists every block of a DAG as raw CIDs, which is how the blockstores key them.
+func (f *FileSystem) dagBlocks(ctx context.Context, root cid.Cid, skip markSet) ([]cid.Cid, error) {
+	// the blocks of a damaged file that are still there are listed all the same
+	marked := make(markSet)
+	err := f.markReachable(ctx, root, marked)
+	if err != nil && !errors.Is(err, errMissingNode) {
+		return nil, err
+	}
+
+	cids := make([]cid.Cid, 0, len(marked))
+	for k := range marked {
+		if _, ok := skip[k]; ok {
+			continue
+		}
+		cids = append(cids, cid.NewCidV1(cid.Raw, multihash.Multihash(k)))
+	}
+	return cids, nil
+}
+
+func (f *FileSystem) promoteFile(merkle []byte) {
+	if _, loaded := f.promoting.LoadOrStore(string(merkle), struct{}{}); loaded {
+		return
+	}
+	defer f.promoting.Delete(string(merkle))
+
+	err := f.PromoteFile(context.Background(), merkle)
+	if err != nil {
+		log.Warn().Err(err).Hex("merkle", merkle).Msg("could not promote file to the hot tier")
+	}
+}
+
+// PromoteFile moves every block of a file back to the hot tier.
+func (f *FileSystem) PromoteFile(ctx context.Context, merkle []byte) error {
+	if f.tiers == nil {
+		return errors.New("blockstore has no cold tier")
+	}
+
+	fcid, err := f.GetCIDFromMerkle(merkle)
+	if err != nil {
+		return err
+	}
+	root, err := cid.Decode(fcid)
+	if err != nil {
+		return err
+	}
+
+	f.tierLock.Lock()
+	defer f.tierLock.Unlock()
+	done := f.startWrite()
+	defer done()
+
+	cids, err := f.dagBlocks(ctx, root, nil)
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, c := range cids {
+		ok, err := f.tiers.Promote(ctx, c)
+		if err != nil {
+			return err
+		}
+		if ok {
+			moved++
+		}
+	}
+
+	err = f.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(coldKey(merkle))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Hex("merkle", merkle).Int("blocks", moved).Msg("Promoted file to the hot tier")
+	return nil
+}
+
+// DemoteIdle moves the blocks of files that were not served for idleAfter to the cold tier. Files
+// without a served time start their clock now. Blocks shared with a file that is still hot stay hot.
+func (f *FileSystem) DemoteIdle(ctx context.Context, idleAfter time.Duration) (*TierResult, error) {
+	if f.tiers == nil {
+		return nil, errors.New("blockstore has no cold tier")
+	}
+
+	f.tierLock.Lock()
+	defer f.tierLock.Unlock()
+
+	now := time.Now()
+	hot := make([]string, 0)
+	idle := make(map[string][]byte)
+	unseen := make([][]byte, 0)
+
+	err := f.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("cid/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			merkle, err := hex.DecodeString(string(item.Key()[len(prefix):]))
+			if err != nil {
+				log.Warn().Err(err).Str("key", string(item.Key())).Msg("skipping invalid cid key")
+				continue
+			}
+			root, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			served, found, err := getServed(txn, merkle)
+			if err != nil {
+				return err
+			}
+			if !found {
+				unseen = append(unseen, merkle)
+				served = now
+			}
+
+			if now.Sub(served) < idleAfter {
+				hot = append(hot, string(root))
+				continue
+			}
+
+			_, err = txn.Get(coldKey(merkle))
+			if err == nil {
+				continue // already demoted
+			}
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			idle[string(root)] = merkle
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, merkle := range unseen {
+		err = wb.Set(servedKey(merkle), servedValue(now))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = wb.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	done := f.startWrite()
+	defer done()
+
+	hotBlocks := make(markSet)
+	err = f.markRoots(ctx, hot, hotBlocks)
+	if err != nil && !errors.Is(err, errMissingNode) {
+		return nil, err
+	}
+
+	res := &TierResult{}
+	for root, merkle := range idle {
+		c, err := cid.Decode(root)
+		if err != nil {
+			log.Warn().Err(err).Str("cid", root).Msg("failed to decode CID, skipping")
+			continue
+		}
+
+		blocks, err := f.dagBlocks(ctx, c, hotBlocks)
+		if err != nil {
+			return res, err
+		}
+
+		for _, b := range blocks {
+			moved, err := f.tiers.Demote(ctx, b)
+			if err != nil {
+				return res, err
+			}
+			if moved {
+				res.Blocks++
+			}
+		}
+
+		err = f.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(coldKey(merkle), []byte{})
+		})
+		if err != nil {
+			return res, err
+		}
+		res.Files++
+	}
+
+	return res, nil
+}
+
+// updateTierUsage refreshes the per tier gauges from the counts the blockstore keeps.
+func (f *FileSystem) updateTierUsage() {
+	hot, cold := f.tiers.Usage()
+
+	tierBlocks.WithLabelValues("hot").Set(float64(hot.Blocks))
+	tierBytes.WithLabelValues("hot").Set(float64(hot.Bytes))
+	tierBlocks.WithLabelValues("cold").Set(float64(cold.Blocks))
+	tierBytes.WithLabelValues("cold").Set(float64(cold.Bytes))
+}
+
+// TierManager periodically demotes idle files to the cold tier.
+type TierManager struct {
+	f         *FileSystem
+	idleAfter time.Duration
+	interval  time.Duration
+	running   bool
+	last      time.Time
+}
+
+func NewTierManager(f *FileSystem, idleAfter time.Duration, interval time.Duration) *TierManager {
+	return &TierManager{
+		f:         f,
+		idleAfter: idleAfter,
+		interval:  interval,
+	}
+}
+
+func (t *TierManager) Start() {
+	t.running = true
+	defer log.Info().Msg("Tier manager stopped")
+
+	// the blockstore keeps its tier counts up to date, they only have to be counted once
+	err := t.f.tiers.Recount(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("could not measure blockstore tiers")
+	}
+	t.f.updateTierUsage()
+
+	for t.running {
+		time.Sleep(time.Second)
+		if !t.last.Add(t.interval).Before(time.Now()) {
+			continue
+		}
+		t.last = time.Now()
+
+		res, err := t.f.DemoteIdle(context.Background(), t.idleAfter)
+		if err != nil {
+			log.Error().Err(err).Msg("demoting idle files failed")
+		} else if res.Files > 0 {
+			log.Info().Int("files", res.Files).Int("blocks", res.Blocks).Msg("Moved idle files to the cold tier")
+		}
+
+		t.f.updateTierUsage()
+	}
+}
+
+func (t *TierManager) Stop() {
+	t.running = false
+}
@@ -0,0 +1,415 @@
+package file_system
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/wealdtech/go-merkletree/v2"
+	treeblake3 "github.com/wealdtech/go-merkletree/v2/blake3"
+	"github.com/wealdtech/go-merkletree/v2/sha3"
+)
+
+// Trees are stored in a compact binary form instead of the JSON go-merkletree produces. The tree/<merkle>/<owner>/<start>
+// record only holds a small header, the nodes are stored once per merkle in heap order (the root is node 1, node i has the
+// children 2i and 2i+1) and split into fixed size pages under treenode/<merkle>/<page>. A proof only needs the sibling of
+// every node on the path from a leaf to the root, so proving a chunk reads one page per tree level at most.
+//
+// The layout is the one go-merkletree uses internally, leaves padded to a power of two with zero hashes, so proofs are
+// identical to the ones it generates.
+const (
+	treeNodePrefix = "treenode/"
+	treePageNodes  = 256
+
+	treeFormatCompact = 1
+
+	treeHashSHA3   = 0
+	treeHashBlake3 = 1
+)
+
+var _ sequoiaTypes.ProofTree = &Tree{}
+
+// Tree is a merkle tree that can produce proofs without every node being in memory.
+type Tree struct {
+	hash     merkletree.HashType
+	hashKind byte
+	leaves   uint64
+	width    uint64 // leaves padded to the next power of two
+	root     []byte
+
+	nodes []byte                            // every node back to back, only set for trees built in memory
+	page  func(page uint64) ([]byte, error) // loads a page of nodes for trees read from disk
+	pages map[uint64][]byte
+}
+
+func treeHashType(hashKind byte) (merkletree.HashType, error) {
+	switch hashKind {
+	case treeHashSHA3:
+		return sha3.New512(), nil
+	case treeHashBlake3:
+		return treeblake3.New256(), nil
+	}
+	return nil, fmt.Errorf("unknown tree hash %d", hashKind)
+}
+
+func proofTreeHash(proofType int64) byte {
+	if proofType == sequoiaTypes.ProofTypeBlake3 {
+		return treeHashBlake3
+	}
+	return treeHashSHA3
+}
+
+// newTree builds every branch of a tree from its leaf hashes.
+func newTree(hashKind byte, leafHashes []byte, leaves uint64) (*Tree, error) {
+	if leaves == 0 {
+		return nil, errors.New("tree must have at least 1 piece of data")
+	}
+
+	h, err := treeHashType(hashKind)
+	if err != nil {
+		return nil, err
+	}
+	hashLen := uint64(h.HashLength())
+
+	width := uint64(1)
+	for width < leaves {
+		width <<= 1
+	}
+
+	nodes := make([]byte, 2*width*hashLen) // padding leaves stay zero
+	copy(nodes[width*hashLen:], leafHashes)
+	for i := width - 1; i > 0; i-- {
+		left := nodes[2*i*hashLen : (2*i+1)*hashLen]
+		right := nodes[(2*i+1)*hashLen : (2*i+2)*hashLen]
+		copy(nodes[i*hashLen:], h.Hash(left, right))
+	}
+
+	return &Tree{
+		hash:     h,
+		hashKind: hashKind,
+		leaves:   leaves,
+		width:    width,
+		root:     nodes[hashLen : 2*hashLen],
+		nodes:    nodes,
+	}, nil
+}
+
+// treeFromLegacy converts a JSON go-merkletree into the compact form.
+func treeFromLegacy(t *merkletree.MerkleTree) (*Tree, error) {
+	var hashKind byte
+	switch t.Hash.HashName() {
+	case sha3.New512().HashName():
+		hashKind = treeHashSHA3
+	case treeblake3.New256().HashName():
+		hashKind = treeHashBlake3
+	default:
+		return nil, fmt.Errorf("unsupported tree hash %s", t.Hash.HashName())
+	}
+
+	hashLen := t.Hash.HashLength()
+	nodes := make([]byte, len(t.Nodes)*hashLen)
+	for i, n := range t.Nodes {
+		copy(nodes[i*hashLen:], n) // node 0 is never set
+	}
+
+	width := uint64(len(t.Nodes) / 2)
+	return &Tree{
+		hash:     t.Hash,
+		hashKind: hashKind,
+		leaves:   uint64(len(t.Data)),
+		width:    width,
+		root:     t.Root(),
+		nodes:    nodes,
+	}, nil
+}
+
+func (t *Tree) Root() []byte {
+	return t.root
+}
+
+func (t *Tree) node(i uint64) ([]byte, error) {
+	hashLen := uint64(t.hash.HashLength())
+	if t.nodes != nil {
+		return t.nodes[i*hashLen : (i+1)*hashLen], nil
+	}
+
+	p := i / treePageNodes
+	page, ok := t.pages[p]
+	if !ok {
+		var err error
+		page, err = t.page(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load tree page %d | %w", p, err)
+		}
+		t.pages[p] = page
+	}
+
+	offset := (i % treePageNodes) * hashLen
+	if uint64(len(page)) < offset+hashLen {
+		return nil, fmt.Errorf("tree page %d is too short", p)
+	}
+	return page[offset : offset+hashLen], nil
+}
+
+// GenerateProofWithIndex returns the same proof go-merkletree does for the leaf at index.
+func (t *Tree) GenerateProofWithIndex(index uint64, height int) (*merkletree.Proof, error) {
+	if index >= t.leaves {
+		return nil, errors.New("index out of range")
+	}
+
+	hashes := make([][]byte, 0, bits.TrailingZeros64(t.width))
+	minI := uint64(1)<<(height+1) - 1
+	for i := index + t.width; i > minI; i /= 2 {
+		n, err := t.node(i ^ 1)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, n)
+	}
+
+	return &merkletree.Proof{Hashes: hashes, Index: index}, nil
+}
+
+// proves reports whether chunk is the data of the leaf at index, so it can be trusted to prove with.
+func (t *Tree) proves(index int, chunk []byte, proofType int64) (bool, error) {
+	treeHash, err := treeHashType(t.hashKind)
+	if err != nil {
+		return false, err
+	}
+
+	proof, err := t.GenerateProofWithIndex(uint64(index), 0)
+	if err != nil {
+		return false, err
+	}
+
+	return merkletree.VerifyProofUsing(chunkData(proofType, index, chunk), false, proof, [][]byte{t.root}, treeHash)
+}
+
+// header is what is stored under the tree key of every contract: format, hash, leaf count and root.
+func (t *Tree) header() []byte {
+	b := make([]byte, 10, 10+len(t.root))
+	b[0] = treeFormatCompact
+	b[1] = t.hashKind
+	binary.BigEndian.PutUint64(b[2:], t.leaves)
+	return append(b, t.root...)
+}
+
+// isLegacyTree reports whether a stored tree is still a JSON go-merkletree.
+func isLegacyTree(val []byte) bool {
+	return len(val) > 0 && val[0] == '{'
+}
+
+func decodeTreeHeader(val []byte) (*Tree, error) {
+	if len(val) < 10 || val[0] != treeFormatCompact {
+		return nil, errors.New("unknown tree format")
+	}
+
+	h, err := treeHashType(val[1])
+	if err != nil {
+		return nil, err
+	}
+
+	leaves := binary.BigEndian.Uint64(val[2:10])
+	if leaves == 0 {
+		return nil, errors.New("tree has no leaves")
+	}
+	width := uint64(1)
+	for width < leaves {
+		width <<= 1
+	}
+
+	return &Tree{
+		hash:     h,
+		hashKind: val[1],
+		leaves:   leaves,
+		width:    width,
+		root:     append([]byte{}, val[10:]...),
+		pages:    make(map[uint64][]byte),
+	}, nil
+}
+
+func treeNodeKey(merkle []byte, page uint64) []byte {
+	return []byte(fmt.Sprintf("%s%x/%010d", treeNodePrefix, merkle, page))
+}
+
+func treeNodesKey(merkle []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x/", treeNodePrefix, merkle))
+}
+
+// saveTreeNodes writes the node pages of an in-memory tree. Files can have far more pages than fit into
+// a single transaction so they are written in a batch ahead of the records pointing to them.
+func (f *FileSystem) saveTreeNodes(merkle []byte, t *Tree) error {
+	if t.nodes == nil {
+		return errors.New("tree nodes are not in memory")
+	}
+
+	pageLen := treePageNodes * t.hash.HashLength()
+
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+	for p := 0; p*pageLen < len(t.nodes); p++ {
+		end := min((p+1)*pageLen, len(t.nodes))
+		err := wb.Set(treeNodeKey(merkle, uint64(p)), t.nodes[p*pageLen:end])
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// deleteTreeNodes removes the node pages of a file no contract uses anymore. Like when they are saved,
+// they are deleted in a batch since they can be more than a single transaction holds.
+func (f *FileSystem) deleteTreeNodes(merkle []byte) error {
+	keys := make([][]byte, 0)
+	err := f.db.View(func(txn *badger.Txn) error {
+		// the file may have been stored again since its last contract was removed
+		_, _, found, err := firstContract(txn, merkle)
+		if err != nil || found {
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = treeNodesKey(merkle)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		err := wb.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// treePages returns a loader for the node pages of a stored tree.
+func (f *FileSystem) treePages(merkle []byte) func(page uint64) ([]byte, error) {
+	return func(page uint64) (data []byte, err error) {
+		err = f.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(treeNodeKey(merkle, page))
+			if err != nil {
+				return err
+			}
+			data, err = item.ValueCopy(nil)
+			return err
+		})
+		return data, err
+	}
+}
+
+// cachedTreePages returns a loader for the node pages of a stored tree that goes through the proof cache.
+func (f *FileSystem) cachedTreePages(merkle []byte) func(page uint64) ([]byte, error) {
+	load := f.treePages(merkle)
+	return func(page uint64) ([]byte, error) {
+		key := fmt.Sprintf("%s/%x/%d", cachePage, merkle, page)
+		if v, ok := f.cache.get(cachePage, key); ok {
+			return v.([]byte), nil
+		}
+
+		data, err := load(page)
+		if err != nil {
+			return nil, err
+		}
+		f.cache.add(key, merkle, data, int64(len(data)))
+		return data, nil
+	}
+}
+
+// loadTree reads the tree of a contract. JSON trees written by older versions are converted to the compact
+// form on first use.
+func (f *FileSystem) loadTree(merkle []byte, owner string, start int64) (*Tree, error) {
+	key := fmt.Sprintf("%s/%x/%s/%d", cacheTree, merkle, owner, start)
+	if v, ok := f.cache.get(cacheTree, key); ok {
+		t := *v.(*Tree)
+		t.pages = make(map[uint64][]byte)
+		return &t, nil
+	}
+
+	var val []byte
+	err := f.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(treeKey(merkle, owner, start))
+		if err != nil {
+			return fmt.Errorf("cannot find tree structure | %w", err)
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if isLegacyTree(val) {
+		return f.migrateTree(merkle, owner, start, val)
+	}
+
+	t, err := decodeTreeHeader(val)
+	if err != nil {
+		return nil, err
+	}
+	t.page = f.cachedTreePages(merkle)
+
+	// the cached copy never loads pages itself, every load gets a page map of its own
+	cached := *t
+	cached.pages = nil
+	f.cache.add(key, merkle, &cached, int64(len(val)))
+
+	return t, nil
+}
+
+func (f *FileSystem) migrateTree(merkle []byte, owner string, start int64, val []byte) (*Tree, error) {
+	var legacy merkletree.MerkleTree
+	err := json.Unmarshal(val, &legacy)
+	if err != nil {
+		return nil, fmt.Errorf("can't unmarshal tree | %w", err)
+	}
+
+	t, err := treeFromLegacy(&legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.saveTreeNodes(merkle, t)
+	if err != nil {
+		return nil, fmt.Errorf("cannot save tree nodes | %w", err)
+	}
+
+	err = f.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(treeKey(merkle, owner, start))
+		if err != nil {
+			return err
+		}
+		current, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, val) {
+			return nil // replaced since it was read
+		}
+		return txn.Set(treeKey(merkle, owner, start), t.header())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot save tree header | %w", err)
+	}
+
+	log.Debug().Hex("merkle", merkle).Str("owner", owner).Int64("start", start).Msg("Converted tree to compact format")
+
+	return t, nil
+}
@@ -2,6 +2,9 @@ package file_system
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ipfs/boxo/blockstore"
 	"github.com/ipfs/go-datastore"
@@ -19,14 +22,56 @@ type FileSystem struct {
 	ipfs       *ipfslite.Peer
 	ipfsHost   host.Host
 	ipfsDomain string
+	gcLock     sync.RWMutex // held by the sweep, writes only take it to register themselves
+	backupLock sync.RWMutex // held by backups, garbage collection waits for them before sweeping
+	writes     atomic.Int64 // block writes the sweep cannot see yet, see startWrite
+
+	encrypted *ipfs2.EncryptedBlockStore // nil unless blocks are encrypted at rest
+
+	tiers     *ipfs2.TieredBlockStore // nil unless a cold tier is configured
+	tierLock  sync.Mutex              // one pass moves blocks between the tiers at a time
+	promoting sync.Map
+
+	disks *ipfs2.MultiDiskBlockStore // nil unless blocks are spread over several disks
+
+	space spaceAccountant
+	cache *proofCache // disabled until SetProofCacheSize is called
+
+	historyLimit atomic.Int64 // proof attempts kept per contract, every one of them until SetProofHistoryLimit is called
+
+	lastWrite atomic.Int64 // unix nanoseconds of the last file written or removed, used to find idle time
 }
 
 func NewFileSystem(ctx context.Context, db *badger.DB, seed string, ds datastore.Batching, bs blockstore.Blockstore, ipfsPort int, ipfsDomain string) (*FileSystem, error) {
+	err := initSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
 	ipfs, hh, err := ipfs2.MakeIPFS(ctx, seed, ds, bs, ipfsPort, ipfsDomain)
 	if err != nil {
 		return nil, err
 	}
-	return &FileSystem{db: db, ipfs: ipfs, ipfsHost: hh, ipfsDomain: ipfsDomain}, nil
+	f := &FileSystem{db: db, ipfs: ipfs, ipfsHost: hh, ipfsDomain: ipfsDomain, cache: newProofCache(0)}
+	// blocks are moved between tiers and disks still encrypted
+	if enc, ok := bs.(*ipfs2.EncryptedBlockStore); ok {
+		f.encrypted = enc
+		bs = enc.Inner()
+	}
+	if tiers, ok := bs.(*ipfs2.TieredBlockStore); ok {
+		f.tiers = tiers
+		bs = tiers.Hot()
+	}
+	if disks, ok := bs.(*ipfs2.MultiDiskBlockStore); ok {
+		f.disks = disks
+	}
+
+	err = f.countStoredBytes()
+	if err != nil {
+		return nil, fmt.Errorf("cannot count stored bytes | %w", err)
+	}
+
+	return f, nil
 }
 
 func (f *FileSystem) Close() {
@@ -1,6 +1,11 @@
 package ipfs
 
 import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/JackalLabs/sequoia/config"
 	badger "github.com/dgraph-io/badger/v4"
 	"github.com/ipfs/boxo/blockstore"
 	ds "github.com/ipfs/go-datastore"
@@ -20,3 +25,69 @@ func NewFlatfsBlockStore(path string) (blockstore.Blockstore, error) {
 func NewBadgerDataStore(db *badger.DB) (ds.Batching, error) {
 	return bds.NewDatastoreFromDB(db)
 }
+
+// NewBadgerBlockStore returns the blockstore ipfs-lite keeps in the data store when no separate
+// blockstore is configured.
+func NewBadgerBlockStore(d ds.Batching) blockstore.Blockstore {
+	return blockstore.NewBlockstore(d)
+}
+
+// OpenBlockStore opens the blockstore described by cfg, wrapping it in a TieredBlockStore when a cold
+// tier is configured and in an EncryptedBlockStore when an encryption key is.
+func OpenBlockStore(ctx context.Context, cfg config.BlockStoreConfig, d ds.Batching) (blockstore.Blockstore, error) {
+	bs, err := openBackends(ctx, cfg, d)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.EncryptionKeyFile == "" {
+		return bs, nil
+	}
+
+	key, err := LoadEncryptionKey(cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedBlockStore(bs, key, cfg.Cipher)
+}
+
+func openBackends(ctx context.Context, cfg config.BlockStoreConfig, d ds.Batching) (blockstore.Blockstore, error) {
+	var bs blockstore.Blockstore
+	var err error
+	switch cfg.Type {
+	case config.OptBadgerDS:
+		bs = NewBadgerBlockStore(d)
+	case config.OptFlatFS:
+		if len(cfg.Disks) > 0 {
+			bs, err = NewMultiDiskBlockStore(cfg.Disks)
+		} else {
+			bs, err = NewFlatfsBlockStore(os.ExpandEnv(cfg.Directory))
+		}
+	case config.OptS3:
+		bs, err = NewS3BlockStore(ctx, cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown blockstore backend '%s'", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Cold == nil {
+		return bs, nil
+	}
+
+	var cold blockstore.Blockstore
+	switch cfg.Cold.Type {
+	case config.OptFlatFS:
+		cold, err = NewFlatfsBlockStore(os.ExpandEnv(cfg.Cold.Directory))
+	case config.OptS3:
+		cold, err = NewS3BlockStore(ctx, cfg.Cold.S3)
+	default:
+		return nil, fmt.Errorf("unknown cold tier backend '%s'", cfg.Cold.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTieredBlockStore(bs, cold), nil
+}
@@ -0,0 +1,313 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"golang.org/x/sys/unix"
+)
+
+var _ blockstore.Blockstore = (*MultiDiskBlockStore)(nil)
+
+type diskKey struct{}
+
+// WithDisk tells a MultiDiskBlockStore which disk the blocks of a request belong to. Writes go to that
+// disk and reads look there first.
+func WithDisk(ctx context.Context, disk string) context.Context {
+	return context.WithValue(ctx, diskKey{}, disk)
+}
+
+// DiskFromContext returns the disk set with WithDisk.
+func DiskFromContext(ctx context.Context) (string, bool) {
+	disk, ok := ctx.Value(diskKey{}).(string)
+	return disk, ok && disk != ""
+}
+
+// FreeSpace returns the bytes available to unprivileged users on the file system holding dir.
+func FreeSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	err := unix.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+type disk struct {
+	name      string // the directory as written in the config, used to record placements
+	directory string
+	weight    uint64
+	draining  bool
+	bs        blockstore.Blockstore
+}
+
+// MultiDiskBlockStore spreads blocks over several flatfs directories. Callers pick the disk of a file
+// through the context, requests without a disk, like bitswap's, are answered from whichever disk has
+// the block. Draining disks can still be read but are never written to.
+type MultiDiskBlockStore struct {
+	disks []*disk
+}
+
+// NewMultiDiskBlockStore opens a flatfs blockstore in every disk directory. Draining disks are only
+// read from so their files can be moved off them.
+func NewMultiDiskBlockStore(disks []config.DiskConfig) (*MultiDiskBlockStore, error) {
+	m := &MultiDiskBlockStore{}
+
+	for _, d := range disks {
+		dir := os.ExpandEnv(d.Directory)
+		bs, err := NewFlatfsBlockStore(dir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open disk %s | %w", d.Directory, err)
+		}
+		weight := d.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		m.disks = append(m.disks, &disk{name: d.Directory, directory: dir, weight: weight, draining: d.Drain, bs: bs})
+	}
+
+	if len(m.disks) == 0 {
+		return nil, errors.New("no disks configured")
+	}
+
+	return m, nil
+}
+
+func (m *MultiDiskBlockStore) disk(name string) (*disk, bool) {
+	for _, d := range m.disks {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// Disks lists the names of the disks new files can be placed on.
+func (m *MultiDiskBlockStore) Disks() []string {
+	names := make([]string, 0, len(m.disks))
+	for _, d := range m.disks {
+		if !d.draining {
+			names = append(names, d.name)
+		}
+	}
+	return names
+}
+
+// Draining reports whether files should be moved off a disk, disks that are not configured count as draining.
+func (m *MultiDiskBlockStore) Draining(name string) bool {
+	d, ok := m.disk(name)
+	return !ok || d.draining
+}
+
+// Free returns the free space of a disk.
+func (m *MultiDiskBlockStore) Free(name string) (uint64, error) {
+	d, ok := m.disk(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown disk %s", name)
+	}
+	free, err := FreeSpace(d.directory)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read free space of %s | %w", name, err)
+	}
+	return free, nil
+}
+
+// Score is the free space of a disk multiplied by its weight, new files go to the disk with the highest score.
+func (m *MultiDiskBlockStore) Score(name string) (uint64, error) {
+	free, err := m.Free(name)
+	if err != nil {
+		return 0, err
+	}
+	return free * m.Weight(name), nil
+}
+
+// Weight returns the weight of a disk.
+func (m *MultiDiskBlockStore) Weight(name string) uint64 {
+	d, ok := m.disk(name)
+	if !ok {
+		return 1
+	}
+	return d.weight
+}
+
+// Pick returns the disk a new file should be placed on.
+func (m *MultiDiskBlockStore) Pick() (string, error) {
+	best := ""
+	var bestScore uint64
+	for _, name := range m.Disks() {
+		score, err := m.Score(name)
+		if err != nil {
+			return "", err
+		}
+		if best == "" || score > bestScore {
+			best = name
+			bestScore = score
+		}
+	}
+	if best == "" {
+		return "", errors.New("every disk is draining")
+	}
+	return best, nil
+}
+
+// lookup returns the disks in the order they should be searched, the disk of the request first.
+func (m *MultiDiskBlockStore) lookup(ctx context.Context) []*disk {
+	name, ok := DiskFromContext(ctx)
+	if !ok {
+		return m.disks
+	}
+	first, ok := m.disk(name)
+	if !ok {
+		return m.disks
+	}
+
+	ordered := make([]*disk, 0, len(m.disks))
+	ordered = append(ordered, first)
+	for _, d := range m.disks {
+		if d != first {
+			ordered = append(ordered, d)
+		}
+	}
+	return ordered
+}
+
+// target returns the disk a write goes to.
+func (m *MultiDiskBlockStore) target(ctx context.Context) (*disk, error) {
+	if name, ok := DiskFromContext(ctx); ok {
+		if d, ok := m.disk(name); ok && !d.draining {
+			return d, nil
+		}
+	}
+
+	name, err := m.Pick()
+	if err != nil {
+		return nil, err
+	}
+	d, _ := m.disk(name)
+	return d, nil
+}
+
+func (m *MultiDiskBlockStore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	for _, d := range m.disks {
+		err := d.bs.DeleteBlock(ctx, c)
+		if err != nil && !ipld.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MultiDiskBlockStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	for _, d := range m.lookup(ctx) {
+		has, err := d.bs.Has(ctx, c)
+		if err != nil || has {
+			return has, err
+		}
+	}
+	return false, nil
+}
+
+func (m *MultiDiskBlockStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	for _, d := range m.lookup(ctx) {
+		blk, err := d.bs.Get(ctx, c)
+		if err == nil || !ipld.IsNotFound(err) {
+			return blk, err
+		}
+	}
+	return nil, ipld.ErrNotFound{Cid: c}
+}
+
+func (m *MultiDiskBlockStore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	for _, d := range m.lookup(ctx) {
+		size, err := d.bs.GetSize(ctx, c)
+		if err == nil || !ipld.IsNotFound(err) {
+			return size, err
+		}
+	}
+	return -1, ipld.ErrNotFound{Cid: c}
+}
+
+func (m *MultiDiskBlockStore) Put(ctx context.Context, blk blocks.Block) error {
+	d, err := m.target(ctx)
+	if err != nil {
+		return err
+	}
+	return d.bs.Put(ctx, blk)
+}
+
+func (m *MultiDiskBlockStore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	d, err := m.target(ctx)
+	if err != nil {
+		return err
+	}
+	return d.bs.PutMany(ctx, blks)
+}
+
+// AllKeysChan lists the blocks of every disk, blocks kept on more than one disk are listed once.
+func (m *MultiDiskBlockStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+
+		seen := make(map[string]struct{})
+		for _, d := range m.disks {
+			keys, err := d.bs.AllKeysChan(ctx)
+			if err != nil {
+				return
+			}
+			for c := range keys {
+				if _, ok := seen[string(c.Hash())]; ok {
+					continue
+				}
+				seen[string(c.Hash())] = struct{}{}
+				select {
+				case out <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (m *MultiDiskBlockStore) HashOnRead(enabled bool) {
+	for _, d := range m.disks {
+		d.bs.HashOnRead(enabled)
+	}
+}
+
+// MoveBlock copies a block to another disk and removes it from the disk it was on.
+func (m *MultiDiskBlockStore) MoveBlock(ctx context.Context, c cid.Cid, from string, to string) (bool, error) {
+	src, ok := m.disk(from)
+	if !ok {
+		return false, fmt.Errorf("unknown disk %s", from)
+	}
+	dst, ok := m.disk(to)
+	if !ok {
+		return false, fmt.Errorf("unknown disk %s", to)
+	}
+	return moveBlock(ctx, c, src.bs, dst.bs)
+}
+
+// Locate returns the disk holding a block.
+func (m *MultiDiskBlockStore) Locate(ctx context.Context, c cid.Cid) (string, bool, error) {
+	for _, d := range m.lookup(ctx) {
+		has, err := d.bs.Has(ctx, c)
+		if err != nil {
+			return "", false, err
+		}
+		if has {
+			return d.name, true, nil
+		}
+	}
+	return "", false, nil
+}
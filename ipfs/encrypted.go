@@ -0,0 +1,221 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var _ blockstore.Blockstore = (*EncryptedBlockStore)(nil)
+
+// encryptedMagic starts every encrypted block, it is followed by the cipher id and the nonce.
+const encryptedMagic byte = 0xe5
+
+const (
+	cipherAESGCM byte = iota + 1
+	cipherXChaCha20Poly1305
+)
+
+// EncryptedBlockStore encrypts blocks before they reach the wrapped blockstore. Blocks keep the CID
+// of their plaintext and the multihash is authenticated along with the contents, so a block can't be
+// swapped for another one on disk. Blocks written before encryption was turned on are still read as
+// long as they hash to their CID.
+type EncryptedBlockStore struct {
+	bs         blockstore.Blockstore
+	id         byte
+	ciphers    map[byte]cipher.AEAD
+	hashOnRead bool
+}
+
+// LoadEncryptionKey reads a 32 byte key from a file, either raw or hex encoded.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read encryption key | %w", err)
+	}
+
+	if len(data) == chacha20poly1305.KeySize {
+		return data, nil
+	}
+
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, raw or hex encoded", chacha20poly1305.KeySize)
+	}
+	return key, nil
+}
+
+// NewEncryptedBlockStore wraps bs so new blocks are sealed with the named cipher. Blocks sealed with
+// the other cipher can still be opened, so the cipher can be changed without rewriting the store.
+func NewEncryptedBlockStore(bs blockstore.Blockstore, key []byte, name string) (*EncryptedBlockStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	xchacha, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &EncryptedBlockStore{
+		bs: bs,
+		ciphers: map[byte]cipher.AEAD{
+			cipherAESGCM:            gcm,
+			cipherXChaCha20Poly1305: xchacha,
+		},
+	}
+
+	switch name {
+	case "", config.CipherAESGCM:
+		e.id = cipherAESGCM
+	case config.CipherXChaCha20Poly1305:
+		e.id = cipherXChaCha20Poly1305
+	default:
+		return nil, fmt.Errorf("unknown cipher '%s'", name)
+	}
+
+	return e, nil
+}
+
+// Inner returns the wrapped blockstore, its blocks are encrypted.
+func (e *EncryptedBlockStore) Inner() blockstore.Blockstore {
+	return e.bs
+}
+
+func (e *EncryptedBlockStore) seal(blk blocks.Block) (blocks.Block, error) {
+	aead := e.ciphers[e.id]
+
+	out := make([]byte, 2+aead.NonceSize(), 2+aead.NonceSize()+len(blk.RawData())+aead.Overhead())
+	out[0] = encryptedMagic
+	out[1] = e.id
+	_, err := rand.Read(out[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	out = aead.Seal(out, out[2:], blk.RawData(), blk.Cid().Hash())
+	return blocks.NewBlockWithCid(out, blk.Cid())
+}
+
+func (e *EncryptedBlockStore) open(blk blocks.Block) (blocks.Block, error) {
+	c := blk.Cid()
+	data := blk.RawData()
+
+	if len(data) >= 2 && data[0] == encryptedMagic {
+		if aead, ok := e.ciphers[data[1]]; ok && len(data) >= 2+aead.NonceSize()+aead.Overhead() {
+			nonce := data[2 : 2+aead.NonceSize()]
+			plain, err := aead.Open(nil, nonce, data[2+aead.NonceSize():], c.Hash())
+			if err == nil {
+				return e.block(plain, c)
+			}
+		}
+	}
+
+	// stored before encryption was turned on
+	err := verifyBlock(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt block %s | %w", c.String(), err)
+	}
+	return blk, nil
+}
+
+// PutSealed stores a block that is already encrypted, such as one copied out of Inner, as it is. It
+// is refused unless it opens with the key of this blockstore.
+func (e *EncryptedBlockStore) PutSealed(ctx context.Context, blk blocks.Block) error {
+	_, err := e.open(blk)
+	if err != nil {
+		return err
+	}
+	return e.bs.Put(ctx, blk)
+}
+
+func (e *EncryptedBlockStore) block(data []byte, c cid.Cid) (blocks.Block, error) {
+	if e.hashOnRead {
+		err := verifyBlock(data, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func verifyBlock(data []byte, c cid.Cid) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Hash(), c.Hash()) {
+		return errors.New("block does not match its hash")
+	}
+	return nil
+}
+
+func (e *EncryptedBlockStore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	return e.bs.DeleteBlock(ctx, c)
+}
+
+func (e *EncryptedBlockStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return e.bs.Has(ctx, c)
+}
+
+func (e *EncryptedBlockStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := e.bs.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(blk)
+}
+
+// GetSize returns the size of the plaintext, which takes decrypting the block.
+func (e *EncryptedBlockStore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	blk, err := e.Get(ctx, c)
+	if err != nil {
+		return -1, err
+	}
+	return len(blk.RawData()), nil
+}
+
+func (e *EncryptedBlockStore) Put(ctx context.Context, blk blocks.Block) error {
+	sealed, err := e.seal(blk)
+	if err != nil {
+		return err
+	}
+	return e.bs.Put(ctx, sealed)
+}
+
+func (e *EncryptedBlockStore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	sealed := make([]blocks.Block, len(blks))
+	for i, blk := range blks {
+		s, err := e.seal(blk)
+		if err != nil {
+			return err
+		}
+		sealed[i] = s
+	}
+	return e.bs.PutMany(ctx, sealed)
+}
+
+func (e *EncryptedBlockStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return e.bs.AllKeysChan(ctx)
+}
+
+// HashOnRead checks the decrypted blocks, the wrapped blockstore only ever sees ciphertext.
+func (e *EncryptedBlockStore) HashOnRead(enabled bool) {
+	e.hashOnRead = enabled
+}
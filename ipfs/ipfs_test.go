@@ -1,10 +1,17 @@
 package ipfs
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path"
 	"strings"
 	"testing"
 
+	"github.com/JackalLabs/sequoia/config"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
@@ -43,3 +50,137 @@ func TestIPFSDomains(t *testing.T) {
 		}
 	}
 }
+
+func TestMigrateBlocks(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	src := NewBadgerBlockStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	dst, err := NewFlatfsBlockStore(t.TempDir())
+	r.NoError(err)
+
+	var all []blocks.Block
+	for i := 0; i < 600; i++ {
+		blk := blocks.NewBlock([]byte(fmt.Sprintf("block %d", i)))
+		all = append(all, blk)
+		r.NoError(src.Put(ctx, blk))
+	}
+
+	// an interrupted run already copied part of the blocks
+	r.NoError(dst.PutMany(ctx, all[:250]))
+
+	res, err := MigrateBlocks(ctx, src, dst, 100)
+	r.NoError(err)
+	r.Equal(600, res.Total)
+	r.Equal(350, res.Copied)
+	r.Equal(250, res.Skipped)
+	r.Equal(100, res.Verified)
+
+	for _, blk := range all {
+		got, err := dst.Get(ctx, blk.Cid())
+		r.NoError(err)
+		r.Equal(blk.RawData(), got.RawData())
+	}
+
+	// running it again has nothing left to copy
+	res, err = MigrateBlocks(ctx, src, dst, 1000)
+	r.NoError(err)
+	r.Zero(res.Copied)
+	r.Equal(600, res.Verified)
+}
+
+func TestBlockCache(t *testing.T) {
+	r := require.New(t)
+
+	cache := newBlockCache(10)
+
+	a := blocks.NewBlock([]byte("aaaa"))
+	b := blocks.NewBlock([]byte("bbbb"))
+	c := blocks.NewBlock([]byte("cccc"))
+
+	cache.add(a.Cid(), a.RawData())
+	cache.add(b.Cid(), b.RawData())
+
+	// reading a makes b the least recently used block
+	_, ok := cache.get(a.Cid())
+	r.True(ok)
+
+	cache.add(c.Cid(), c.RawData())
+	r.Equal(int64(8), cache.size)
+
+	_, ok = cache.get(b.Cid())
+	r.False(ok)
+	data, ok := cache.get(a.Cid())
+	r.True(ok)
+	r.Equal(a.RawData(), data)
+
+	// blocks larger than the whole cache are never kept
+	cache.add(blocks.NewBlock([]byte("way too large")).Cid(), []byte("way too large"))
+	r.Equal(int64(8), cache.size)
+
+	cache.remove(a.Cid())
+	_, ok = cache.get(a.Cid())
+	r.False(ok)
+	r.Equal(int64(4), cache.size)
+}
+
+func TestEncryptedBlockStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	keyFile := path.Join(t.TempDir(), "key")
+	r.NoError(os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0o600))
+	key, err := LoadEncryptionKey(keyFile)
+	r.NoError(err)
+
+	inner, err := NewFlatfsBlockStore(t.TempDir())
+	r.NoError(err)
+
+	// written before encryption was turned on
+	legacy := blocks.NewBlock([]byte("stored in the clear"))
+	r.NoError(inner.Put(ctx, legacy))
+
+	e, err := NewEncryptedBlockStore(inner, key, config.CipherAESGCM)
+	r.NoError(err)
+	e.HashOnRead(true)
+
+	blk := blocks.NewBlock([]byte("secret block contents"))
+	r.NoError(e.Put(ctx, blk))
+
+	raw, err := inner.Get(ctx, blk.Cid())
+	r.NoError(err)
+	r.NotContains(string(raw.RawData()), "secret")
+
+	got, err := e.Get(ctx, blk.Cid())
+	r.NoError(err)
+	r.Equal(blk.RawData(), got.RawData())
+
+	size, err := e.GetSize(ctx, blk.Cid())
+	r.NoError(err)
+	r.Equal(len(blk.RawData()), size)
+
+	got, err = e.Get(ctx, legacy.Cid())
+	r.NoError(err)
+	r.Equal(legacy.RawData(), got.RawData())
+
+	// blocks sealed with the previous cipher stay readable
+	x, err := NewEncryptedBlockStore(inner, key, config.CipherXChaCha20Poly1305)
+	r.NoError(err)
+	got, err = x.Get(ctx, blk.Cid())
+	r.NoError(err)
+	r.Equal(blk.RawData(), got.RawData())
+
+	// a block moved under another CID fails authentication
+	other := blocks.NewBlock([]byte("another block"))
+	swapped, err := blocks.NewBlockWithCid(raw.RawData(), other.Cid())
+	r.NoError(err)
+	r.NoError(inner.Put(ctx, swapped))
+	_, err = e.Get(ctx, other.Cid())
+	r.Error(err)
+
+	// the wrong key can't read anything
+	wrong, err := NewEncryptedBlockStore(inner, make([]byte, 32), config.CipherAESGCM)
+	r.NoError(err)
+	_, err = wrong.Get(ctx, blk.Cid())
+	r.Error(err)
+}
@@ -0,0 +1,111 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog/log"
+)
+
+const migrateBatchSize = 256
+
+// MigrationResult describes a finished blockstore migration.
+type MigrationResult struct {
+	Total    int // blocks in the source
+	Copied   int // blocks written during this run
+	Skipped  int // blocks the destination already had from an interrupted run
+	Verified int // blocks whose data was re-read and hashed after copying
+}
+
+// MigrateBlocks copies every block of src into dst. Blocks dst already has are skipped, which is what
+// makes an interrupted migration resumable: running it again only copies what is left. Once every
+// block is copied it checks that dst has all of them and re-hashes sample random blocks read back from dst.
+func MigrateBlocks(ctx context.Context, src blockstore.Blockstore, dst blockstore.Blockstore, sample int) (*MigrationResult, error) {
+	keys, err := src.AllKeysChan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list source blocks | %w", err)
+	}
+
+	res := &MigrationResult{}
+	all := make([]cid.Cid, 0)
+	batch := make([]blocks.Block, 0, migrateBatchSize)
+
+	for c := range keys {
+		res.Total++
+		all = append(all, c)
+
+		has, err := dst.Has(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check block %s | %w", c.String(), err)
+		}
+		if has {
+			res.Skipped++
+			continue
+		}
+
+		blk, err := src.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+
+		batch = append(batch, blk)
+		if len(batch) < migrateBatchSize {
+			continue
+		}
+
+		err = dst.PutMany(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write blocks | %w", err)
+		}
+		res.Copied += len(batch)
+		batch = batch[:0]
+
+		if res.Copied%(migrateBatchSize*40) == 0 {
+			log.Info().Int("copied", res.Copied).Int("skipped", res.Skipped).Msg("migrating blocks...")
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(batch) > 0 {
+		err = dst.PutMany(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write blocks | %w", err)
+		}
+		res.Copied += len(batch)
+	}
+
+	for _, c := range all {
+		has, err := dst.Has(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check block %s | %w", c.String(), err)
+		}
+		if !has {
+			return nil, fmt.Errorf("block %s is missing from the destination", c.String())
+		}
+	}
+
+	if sample > len(all) {
+		sample = len(all)
+	}
+	for _, i := range rand.Perm(len(all))[:sample] {
+		c := all[i]
+		blk, err := dst.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read back block %s | %w", c.String(), err)
+		}
+
+		sum, err := c.Prefix().Sum(blk.RawData())
+		if err != nil || !sum.Equals(c) {
+			return nil, fmt.Errorf("block %s does not match its hash after copying", c.String())
+		}
+		res.Verified++
+	}
+
+	return res, nil
+}
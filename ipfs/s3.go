@@ -0,0 +1,320 @@
+package ipfs
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	s3PutConcurrency = 8
+	s3KeysBuffer     = 64
+)
+
+var _ blockstore.Blockstore = (*S3BlockStore)(nil)
+
+// S3BlockStore keeps every block as an object in an S3 compatible bucket. Objects are named by the
+// block's multihash the same way flatfs names its files, so CIDs of any version map to one object.
+// Recently read and written blocks are kept in a local cache to save round trips for proofs.
+type S3BlockStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	cache  *blockCache
+
+	hashOnRead bool
+}
+
+// NewS3BlockStore connects to the bucket of cfg, creating it if it doesn't exist yet.
+func NewS3BlockStore(ctx context.Context, cfg config.S3Config) (*S3BlockStore, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create s3 client | %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach bucket %s | %w", cfg.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create bucket %s | %w", cfg.Bucket, err)
+		}
+		log.Info().Str("bucket", cfg.Bucket).Msg("Created s3 bucket for blockstore")
+	}
+
+	cacheSize := cfg.CacheSizeBytes
+	if cacheSize == 0 {
+		cacheSize = config.DefaultS3CacheSizeBytes()
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3BlockStore{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: prefix,
+		cache:  newBlockCache(cacheSize),
+	}, nil
+}
+
+func (s *S3BlockStore) objectName(c cid.Cid) string {
+	return s.prefix + dshelp.MultihashToDsKey(c.Hash()).String()[1:]
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+func (s *S3BlockStore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	s.cache.remove(c)
+
+	err := s.client.RemoveObject(ctx, s.bucket, s.objectName(c), minio.RemoveObjectOptions{})
+	if err != nil && !isNoSuchKey(err) {
+		return fmt.Errorf("cannot remove block %s | %w", c.String(), err)
+	}
+	return nil
+}
+
+func (s *S3BlockStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if _, ok := s.cache.get(c); ok {
+		return true, nil
+	}
+
+	_, err := s.client.StatObject(ctx, s.bucket, s.objectName(c), minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot stat block %s | %w", c.String(), err)
+	}
+	return true, nil
+}
+
+func (s *S3BlockStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if !c.Defined() {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+
+	data, ok := s.cache.get(c)
+	if !ok {
+		obj, err := s.client.GetObject(ctx, s.bucket, s.objectName(c), minio.GetObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot get block %s | %w", c.String(), err)
+		}
+		//nolint:errcheck
+		defer obj.Close()
+
+		// the object is fetched lazily, a missing key only shows up on read
+		data, err = io.ReadAll(obj)
+		if err != nil {
+			if isNoSuchKey(err) {
+				return nil, ipld.ErrNotFound{Cid: c}
+			}
+			return nil, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+		}
+		s.cache.add(c, data)
+	}
+
+	if s.hashOnRead {
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		if !sum.Equals(c) {
+			return nil, blockstore.ErrHashMismatch
+		}
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (s *S3BlockStore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	if data, ok := s.cache.get(c); ok {
+		return len(data), nil
+	}
+
+	info, err := s.client.StatObject(ctx, s.bucket, s.objectName(c), minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return -1, ipld.ErrNotFound{Cid: c}
+		}
+		return -1, fmt.Errorf("cannot stat block %s | %w", c.String(), err)
+	}
+	return int(info.Size), nil
+}
+
+func (s *S3BlockStore) Put(ctx context.Context, blk blocks.Block) error {
+	data := blk.RawData()
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectName(blk.Cid()), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return fmt.Errorf("cannot put block %s | %w", blk.Cid().String(), err)
+	}
+
+	s.cache.add(blk.Cid(), data)
+	return nil
+}
+
+func (s *S3BlockStore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(s3PutConcurrency)
+
+	for _, blk := range blks {
+		g.Go(func() error {
+			return s.Put(ctx, blk)
+		})
+	}
+
+	return g.Wait()
+}
+
+// AllKeysChan lists every block in the bucket as a raw CIDv1, like the other blockstores do.
+func (s *S3BlockStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix,
+		Recursive: true,
+	})
+
+	out := make(chan cid.Cid, s3KeysBuffer)
+	go func() {
+		defer close(out)
+		for obj := range objects {
+			if obj.Err != nil {
+				log.Error().Err(obj.Err).Msg("failed to list s3 blocks")
+				return
+			}
+
+			mh, err := dshelp.DsKeyToMultihash(ds.NewKey(strings.TrimPrefix(obj.Key, s.prefix)))
+			if err != nil {
+				log.Warn().Err(err).Str("key", obj.Key).Msg("skipping object that is not a block")
+				continue
+			}
+
+			select {
+			case out <- cid.NewCidV1(cid.Raw, mh):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Usage sums up the objects of the bucket from its listing, no block has to be read.
+func (s *S3BlockStore) Usage(ctx context.Context) (TierUsage, error) {
+	var usage TierUsage
+
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return usage, fmt.Errorf("cannot list s3 blocks | %w", obj.Err)
+		}
+		usage.Blocks++
+		usage.Bytes += obj.Size
+	}
+
+	return usage, nil
+}
+
+func (s *S3BlockStore) HashOnRead(enabled bool) {
+	s.hashOnRead = enabled
+}
+
+// blockCache is an LRU of block data bounded by the total size of the cached blocks.
+type blockCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newBlockCache(maxSize int64) *blockCache {
+	return &blockCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (b *blockCache) get(c cid.Cid) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[string(c.Hash())]
+	if !ok {
+		return nil, false
+	}
+	b.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+func (b *blockCache) add(c cid.Cid, data []byte) {
+	if int64(len(data)) > b.maxSize {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := string(c.Hash())
+	if e, ok := b.entries[key]; ok {
+		b.order.MoveToFront(e)
+		return
+	}
+
+	b.entries[key] = b.order.PushFront(&cacheEntry{key: key, data: data})
+	b.size += int64(len(data))
+
+	for b.size > b.maxSize {
+		e := b.order.Back()
+		entry := e.Value.(*cacheEntry)
+		b.order.Remove(e)
+		delete(b.entries, entry.key)
+		b.size -= int64(len(entry.data))
+	}
+}
+
+func (b *blockCache) remove(c cid.Cid) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := string(c.Hash())
+	if e, ok := b.entries[key]; ok {
+		b.order.Remove(e)
+		delete(b.entries, key)
+		b.size -= int64(len(e.Value.(*cacheEntry).data))
+	}
+}
@@ -0,0 +1,323 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var _ blockstore.Blockstore = (*TieredBlockStore)(nil)
+
+// TieredBlockStore puts a fast hot tier in front of a large cold tier. New blocks always land in the
+// hot tier and reads fall through to the cold tier, moving blocks between the tiers is left to the
+// caller through Demote and Promote.
+type TieredBlockStore struct {
+	hot  blockstore.Blockstore
+	cold blockstore.Blockstore
+
+	// counted once by Recount and kept up to date by every write after
+	hotUsage  tierCounter
+	coldUsage tierCounter
+}
+
+// TierUsage is the number of blocks and bytes kept in a tier.
+type TierUsage struct {
+	Blocks int64
+	Bytes  int64
+}
+
+type tierCounter struct {
+	blocks atomic.Int64
+	bytes  atomic.Int64
+}
+
+func (c *tierCounter) add(blocks int64, bytes int64) {
+	c.blocks.Add(blocks)
+	c.bytes.Add(bytes)
+}
+
+func (c *tierCounter) set(u TierUsage) {
+	c.blocks.Store(u.Blocks)
+	c.bytes.Store(u.Bytes)
+}
+
+func (c *tierCounter) usage() TierUsage {
+	return TierUsage{Blocks: c.blocks.Load(), Bytes: c.bytes.Load()}
+}
+
+// usageReporter is implemented by blockstores that can count their contents without reading every block.
+type usageReporter interface {
+	Usage(ctx context.Context) (TierUsage, error)
+}
+
+func NewTieredBlockStore(hot blockstore.Blockstore, cold blockstore.Blockstore) *TieredBlockStore {
+	return &TieredBlockStore{
+		hot:  hot,
+		cold: cold,
+	}
+}
+
+// Hot returns the hot tier.
+func (t *TieredBlockStore) Hot() blockstore.Blockstore {
+	return t.hot
+}
+
+func (t *TieredBlockStore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	err := removeBlock(ctx, t.hot, &t.hotUsage, c)
+	if err != nil {
+		return err
+	}
+	return removeBlock(ctx, t.cold, &t.coldUsage, c)
+}
+
+// removeBlock deletes a block from a tier and takes it off the count of the tier.
+func removeBlock(ctx context.Context, bs blockstore.Blockstore, usage *tierCounter, c cid.Cid) error {
+	size, err := bs.GetSize(ctx, c)
+	if err != nil {
+		if ipld.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	err = bs.DeleteBlock(ctx, c)
+	if err != nil && !ipld.IsNotFound(err) {
+		return err
+	}
+	usage.add(-1, -int64(size))
+	return nil
+}
+
+func (t *TieredBlockStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	has, err := t.hot.Has(ctx, c)
+	if err != nil || has {
+		return has, err
+	}
+	return t.cold.Has(ctx, c)
+}
+
+func (t *TieredBlockStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := t.hot.Get(ctx, c)
+	if err == nil || !ipld.IsNotFound(err) {
+		return blk, err
+	}
+	return t.cold.Get(ctx, c)
+}
+
+func (t *TieredBlockStore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	size, err := t.hot.GetSize(ctx, c)
+	if err == nil || !ipld.IsNotFound(err) {
+		return size, err
+	}
+	return t.cold.GetSize(ctx, c)
+}
+
+// Put writes to the hot tier and drops a cold copy of the block if there was one.
+func (t *TieredBlockStore) Put(ctx context.Context, blk blocks.Block) error {
+	has, err := t.hot.Has(ctx, blk.Cid())
+	if err != nil {
+		return err
+	}
+	err = t.hot.Put(ctx, blk)
+	if err != nil {
+		return err
+	}
+	if !has {
+		t.hotUsage.add(1, int64(len(blk.RawData())))
+	}
+	return t.dropCold(ctx, blk.Cid())
+}
+
+func (t *TieredBlockStore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	added := make([]blocks.Block, 0, len(blks))
+	for _, blk := range blks {
+		has, err := t.hot.Has(ctx, blk.Cid())
+		if err != nil {
+			return err
+		}
+		if !has {
+			added = append(added, blk)
+		}
+	}
+
+	err := t.hot.PutMany(ctx, blks)
+	if err != nil {
+		return err
+	}
+	for _, blk := range added {
+		t.hotUsage.add(1, int64(len(blk.RawData())))
+	}
+
+	for _, blk := range blks {
+		err = t.dropCold(ctx, blk.Cid())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TieredBlockStore) dropCold(ctx context.Context, c cid.Cid) error {
+	return removeBlock(ctx, t.cold, &t.coldUsage, c)
+}
+
+// AllKeysChan lists the blocks of both tiers, a block is only listed once while it is being moved.
+func (t *TieredBlockStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	hotKeys, err := t.hot.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+
+		seen := make(map[string]struct{})
+		for c := range hotKeys {
+			seen[string(c.Hash())] = struct{}{}
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		coldKeys, err := t.cold.AllKeysChan(ctx)
+		if err != nil {
+			return
+		}
+		for c := range coldKeys {
+			if _, ok := seen[string(c.Hash())]; ok {
+				continue
+			}
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (t *TieredBlockStore) HashOnRead(enabled bool) {
+	t.hot.HashOnRead(enabled)
+	t.cold.HashOnRead(enabled)
+}
+
+// Demote moves a block from the hot tier to the cold tier, blocks that are not hot are left alone.
+func (t *TieredBlockStore) Demote(ctx context.Context, c cid.Cid) (bool, error) {
+	return countedMove(ctx, c, t.hot, &t.hotUsage, t.cold, &t.coldUsage)
+}
+
+// Promote moves a block from the cold tier back to the hot tier.
+func (t *TieredBlockStore) Promote(ctx context.Context, c cid.Cid) (bool, error) {
+	return countedMove(ctx, c, t.cold, &t.coldUsage, t.hot, &t.hotUsage)
+}
+
+// countedMove moves a block between tiers and moves it over in their counts.
+func countedMove(ctx context.Context, c cid.Cid, from blockstore.Blockstore, fromUsage *tierCounter, to blockstore.Blockstore, toUsage *tierCounter) (bool, error) {
+	size, err := from.GetSize(ctx, c)
+	if err != nil {
+		if ipld.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+	}
+	has, err := to.Has(ctx, c)
+	if err != nil {
+		return false, fmt.Errorf("cannot check block %s | %w", c.String(), err)
+	}
+
+	moved, err := moveBlock(ctx, c, from, to)
+	if err != nil || !moved {
+		return moved, err
+	}
+
+	fromUsage.add(-1, -int64(size))
+	if !has {
+		toUsage.add(1, int64(size))
+	}
+	return true, nil
+}
+
+func moveBlock(ctx context.Context, c cid.Cid, from blockstore.Blockstore, to blockstore.Blockstore) (bool, error) {
+	blk, err := from.Get(ctx, c)
+	if err != nil {
+		if ipld.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot read block %s | %w", c.String(), err)
+	}
+
+	// the block is written before it is removed so it can always be found in one of the tiers
+	err = to.Put(ctx, blk)
+	if err != nil {
+		return false, fmt.Errorf("cannot write block %s | %w", c.String(), err)
+	}
+
+	err = from.DeleteBlock(ctx, c)
+	if err != nil {
+		return false, fmt.Errorf("cannot remove block %s | %w", c.String(), err)
+	}
+
+	return true, nil
+}
+
+// Usage returns the blocks and bytes of each tier as counted since the last Recount.
+func (t *TieredBlockStore) Usage() (hot TierUsage, cold TierUsage) {
+	return t.hotUsage.usage(), t.coldUsage.usage()
+}
+
+// Recount counts the blocks and bytes of each tier from scratch, which reads the size of every block
+// unless the tier can report its usage itself. Writes made while counting may be off until the next
+// recount.
+func (t *TieredBlockStore) Recount(ctx context.Context) error {
+	hot, err := blockstoreUsage(ctx, t.hot)
+	if err != nil {
+		return err
+	}
+	cold, err := blockstoreUsage(ctx, t.cold)
+	if err != nil {
+		return err
+	}
+
+	t.hotUsage.set(hot)
+	t.coldUsage.set(cold)
+	return nil
+}
+
+func blockstoreUsage(ctx context.Context, bs blockstore.Blockstore) (TierUsage, error) {
+	if r, ok := bs.(usageReporter); ok {
+		return r.Usage(ctx)
+	}
+
+	var usage TierUsage
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return usage, err
+	}
+	for c := range keys {
+		size, err := bs.GetSize(ctx, c)
+		if err != nil {
+			if ipld.IsNotFound(err) {
+				continue
+			}
+			return usage, err
+		}
+		usage.Blocks++
+		usage.Bytes += int64(size)
+	}
+
+	return usage, ctx.Err()
+}
@@ -81,7 +81,7 @@ func readFileWithTimeout(filepath string, timeout time.Duration) ([]byte, error)
 // DownloadFile attempts to download a file identified by its Merkle root from a network of providers, excluding the caller's own URL.
 // It queries the provider network, tries each available provider until the file is successfully downloaded and matches the expected size, and writes the file to the local file system.
 // Returns an error if the file cannot be found or downloaded from any provider.
-func DownloadFile(f *file_system.FileSystem, merkle []byte, owner string, start int64, wallet *wallet.Wallet, fileSize int64, myUrl string, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams) error {
+func DownloadFile(f *file_system.FileSystem, merkle []byte, owner string, start int64, wallet *wallet.Wallet, fileSize int64, myUrl string, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, source string) error {
 	queryParams := &types.QueryFindFile{
 		Merkle: merkle,
 	}
@@ -111,7 +111,7 @@ func DownloadFile(f *file_system.FileSystem, merkle []byte, owner string, start
 			url = mappedUrl
 		}
 
-		size, err := DownloadFileFromURL(f, url, merkle, owner, start, chunkSize, proofType, ipfsParams, fileSize)
+		size, err := DownloadFileFromURL(f, url, merkle, owner, start, chunkSize, proofType, ipfsParams, source, fileSize)
 		if err != nil {
 			log.Info().Msg(fmt.Sprintf("Couldn't get %x from %s, trying again... | %s", merkle, url, err.Error()))
 			continue
@@ -141,7 +141,7 @@ func DownloadFile(f *file_system.FileSystem, merkle []byte, owner string, start
 //
 // Returns the number of bytes written, or an error if the download or write fails. Timeout and HTTP errors are
 // reported with detailed messages.
-func DownloadFileFromURL(f *file_system.FileSystem, url string, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, fileSize int64) (int, error) {
+func DownloadFileFromURL(f *file_system.FileSystem, url string, merkle []byte, owner string, start int64, chunkSize int64, proofType int64, ipfsParams *ipfslite.AddParams, source string, fileSize int64) (int, error) {
 	log.Info().Msgf("Downloading %x from %s...", merkle, url)
 
 	// Calculate timeout based on file size
@@ -241,76 +241,14 @@ func DownloadFileFromURL(f *file_system.FileSystem, url string, merkle []byte, o
 		// No compression or unsupported; use raw body
 	}
 
-	// Create a temp file for downloading
-	// We need temp file since WriteFile requires seeking capability
-	tempFile, err := os.CreateTemp("", "sequoia_download_*")
+	// the body is hashed and added to IPFS in a single pass, nothing is spooled to disk first
+	size, _, err := f.WriteFile(bodyReader, merkle, owner, start, chunkSize, proofType, ipfsParams, source)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create temp file: %w", err)
-	}
-
-	// Channel to signal completion
-	doneCh := make(chan struct{})
-	errCh := make(chan error, 1)
-
-	// Stream data with timeout monitoring
-	go func() {
-		defer close(doneCh)
-		_, err := io.Copy(tempFile, bodyReader)
-		if err != nil {
-			errCh <- err
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, fmt.Errorf("download timed out after %v: %w", timeout, err)
 		}
-	}()
-
-	// Wait for either completion or timeout
-	select {
-	case <-ctx.Done():
-		// Clean up temp file on timeout
-		//nolint:errcheck
-		tempFile.Close()
-		//nolint:errcheck
-		os.Remove(tempFile.Name())
-		return 0, fmt.Errorf("download timed out after %v", timeout)
-	case err := <-errCh:
-		// Clean up temp file on error
-		//nolint:errcheck
-		tempFile.Close()
-		//nolint:errcheck
-		os.Remove(tempFile.Name())
-		return 0, fmt.Errorf("download error: %w", err)
-	case <-doneCh:
-		// Download completed successfully
-	}
-
-	// Seek back to the beginning of the temporary file
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		//nolint:errcheck
-		tempFile.Close()
-		//nolint:errcheck
-		os.Remove(tempFile.Name())
-		return 0, fmt.Errorf("failed to seek temp file to start: %w", err)
-	}
-
-	// Wrap the file with auto-deleting closer
-	reader := &tempFileReadSeekCloser{File: tempFile}
-	//nolint:errcheck
-	defer reader.Close()
-
-	size, _, err := f.WriteFile(reader, merkle, owner, start, chunkSize, proofType, ipfsParams)
-	if err != nil {
 		return 0, fmt.Errorf("failed to write file data: %w", err)
 	}
 
 	return size, nil
 }
-
-// tempFileReadSeekCloser wraps os.File and deletes it on Close
-type tempFileReadSeekCloser struct {
-	*os.File
-}
-
-func (tfrsc *tempFileReadSeekCloser) Close() error {
-	// nolint:errcheck
-	defer os.Remove(tfrsc.Name()) // Ensure deletion
-	return tfrsc.File.Close()
-}
@@ -0,0 +1,174 @@
+package proofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons a contract fails the audit.
+const (
+	AuditChainQuery      = "chain_query"      // the file or its proof could not be read from the chain
+	AuditMissingTree     = "missing_tree"     // no merkle tree is stored for the contract
+	AuditMissingCID      = "missing_cid"      // no cid is stored for the file, so its data cannot be found
+	AuditUnreadableChunk = "unreadable_chunk" // the chunk to prove could not be read from the blockstore
+	AuditInvalidChunk    = "invalid_chunk"    // the chunk read does not hash into the stored tree
+	AuditRootMismatch    = "root_mismatch"    // the stored tree does not have the merkle root on chain
+)
+
+// AuditFailure is a contract whose next proof would fail.
+type AuditFailure struct {
+	Merkle    []byte
+	Owner     string
+	Start     int64
+	Chunk     int64
+	ProofType int64
+	Reason    string
+	Error     string
+}
+
+// AuditReport is the outcome of auditing every local contract.
+type AuditReport struct {
+	Contracts int            // contracts stored locally
+	Passed    int            // contracts whose proof verified against the chain
+	Skipped   int            // contracts gone from the chain or no longer proven by this provider
+	Failed    []AuditFailure // contracts whose proof would fail
+}
+
+// Audit builds the proof every local contract is due for at its current chunk, the way PostProof
+// does, and verifies it against the merkle root on chain without broadcasting anything.
+func Audit(ctx context.Context, cl types.QueryClient, io FileSystem, address string, chunkSize int) (*AuditReport, error) {
+	contracts := make([]contractID, 0)
+	err := io.ProcessFiles(func(merkle []byte, owner string, start int64) {
+		contracts = append(contracts, contractID{merkle: merkle, owner: owner, start: start})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{
+		Contracts: len(contracts),
+		Failed:    make([]AuditFailure, 0),
+	}
+
+	for _, c := range contracts {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		failure, skipped := audit(ctx, cl, io, address, c, chunkSize)
+		switch {
+		case skipped:
+			report.Skipped++
+		case failure != nil:
+			log.Debug().
+				Hex("merkle", c.merkle).
+				Str("owner", c.owner).
+				Int64("start", c.start).
+				Str("reason", failure.Reason).
+				Msg("Contract failed audit")
+			report.Failed = append(report.Failed, *failure)
+		default:
+			report.Passed++
+		}
+	}
+
+	log.Info().
+		Int("contracts", report.Contracts).
+		Int("passed", report.Passed).
+		Int("skipped", report.Skipped).
+		Int("failed", len(report.Failed)).
+		Msg("Proof audit finished")
+
+	return report, nil
+}
+
+// audit checks a single contract, returning why its proof would fail or whether it was skipped.
+func audit(ctx context.Context, cl types.QueryClient, io FileSystem, address string, c contractID, chunkSize int) (*AuditFailure, bool) {
+	failure := &AuditFailure{
+		Merkle: c.merkle,
+		Owner:  c.owner,
+		Start:  c.start,
+	}
+	fail := func(reason string, err error) (*AuditFailure, bool) {
+		failure.Reason = reason
+		if err != nil {
+			failure.Error = err.Error()
+		}
+		return failure, false
+	}
+
+	res, err := cl.File(ctx, &types.QueryFile{Merkle: c.merkle, Owner: c.owner, Start: c.start})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, true
+		}
+		return fail(AuditChainQuery, err)
+	}
+	file := res.File
+	failure.ProofType = file.ProofType
+
+	if !file.ContainsProver(address) {
+		return nil, true
+	}
+
+	// a contract without a proof yet starts at the first chunk, same as GenerateProof
+	proofRes, err := cl.Proof(ctx, &types.QueryProof{
+		ProviderAddress: address,
+		Merkle:          file.Merkle,
+		Owner:           file.Owner,
+		Start:           file.Start,
+	})
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return fail(AuditChainQuery, err)
+		}
+	} else {
+		failure.Chunk = proofRes.Proof.ChunkToProve
+	}
+
+	found, err := io.CheckTree(c.merkle, c.owner, c.start)
+	if err != nil {
+		return fail(AuditMissingTree, err)
+	}
+	if !found {
+		return fail(AuditMissingTree, nil)
+	}
+
+	_, err = io.GetCIDFromMerkle(c.merkle)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return fail(AuditMissingCID, nil)
+		}
+		return fail(AuditMissingCID, err)
+	}
+
+	proof, item, err := GenProof(io, c.merkle, c.owner, c.start, int(failure.Chunk), chunkSize, file.ProofType)
+	if err != nil {
+		if errors.Is(err, errInvalidTree) {
+			return fail(AuditInvalidChunk, err)
+		}
+		return fail(AuditUnreadableChunk, err)
+	}
+
+	valid, err := VerifyProof(file.Merkle, failure.Chunk, item, proof, file.ProofType)
+	if err != nil {
+		return fail(AuditRootMismatch, err)
+	}
+	if !valid {
+		return fail(AuditRootMismatch, fmt.Errorf("proof does not verify against merkle %x", file.Merkle))
+	}
+
+	return nil, false
+}
+
+// Audit checks every contract of the prover without posting any proofs, see Audit.
+func (p *Prover) Audit(ctx context.Context) (*AuditReport, error) {
+	return Audit(ctx, types.NewQueryClient(p.wallet.Client.GRPCConn), p.io, p.wallet.AccAddress(), p.chunkSize)
+}
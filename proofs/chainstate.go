@@ -0,0 +1,156 @@
+package proofs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/types/query"
+	"github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/rs/zerolog/log"
+)
+
+const proofStatePageSize = 1000
+
+func stateKey(merkle []byte, owner string, start int64) string {
+	return fmt.Sprintf("%x/%s/%d", merkle, owner, start)
+}
+
+// chainState holds the proof state of every contract this provider proves, fetched a page at a
+// time once per cycle instead of with a File and a Proof query for each contract. The terms of a
+// contract never change once it is made, so files are only queried the first time they are seen.
+type chainState struct {
+	cl      types.QueryClient
+	address string
+	observe func(time.Duration, error) // told how each query of a single contract went, may be nil
+
+	lock   sync.RWMutex
+	proofs map[string]types.FileProof   // this provider's proofs as of the last refresh, nil if it failed
+	files  map[string]types.UnifiedFile // terms of contracts with a proof, without their prover list
+}
+
+func newChainState(cl types.QueryClient, address string) *chainState {
+	return &chainState{
+		cl:      cl,
+		address: address,
+		files:   make(map[string]types.UnifiedFile),
+	}
+}
+
+// refresh pages through every proof of the provider. Files of contracts that are no longer proven
+// are dropped, if the refresh fails every contract falls back to being queried on its own.
+func (s *chainState) refresh(ctx context.Context) error {
+	proofs := make(map[string]types.FileProof)
+
+	var key []byte
+	for {
+		chainQueries.WithLabelValues("proofs_by_address").Inc()
+		res, err := s.cl.ProofsByAddress(ctx, &types.QueryProofsByAddress{
+			ProviderAddress: s.address,
+			Pagination:      &query.PageRequest{Key: key, Limit: proofStatePageSize},
+		})
+		if err != nil {
+			s.lock.Lock()
+			s.proofs = nil
+			s.lock.Unlock()
+			return fmt.Errorf("cannot list proofs of %s | %w", s.address, err)
+		}
+
+		for _, p := range res.Proofs {
+			proofs[stateKey(p.Merkle, p.Owner, p.Start)] = p
+		}
+
+		if res.Pagination == nil || len(res.Pagination.NextKey) == 0 {
+			break
+		}
+		key = res.Pagination.NextKey
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.proofs = proofs
+	for k := range s.files {
+		if _, ok := proofs[k]; !ok {
+			delete(s.files, k)
+		}
+	}
+	proofStateContracts.Set(float64(len(proofs)))
+
+	log.Debug().Int("proofs", len(proofs)).Msg("Refreshed proof state from chain")
+	return nil
+}
+
+// proof returns the proof of a contract as of the last refresh, found is false when the provider
+// did not prove the contract then or the refresh failed.
+func (s *chainState) proof(merkle []byte, owner string, start int64) (types.FileProof, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	p, ok := s.proofs[stateKey(merkle, owner, start)]
+	return p, ok
+}
+
+// file returns the terms of a contract, from the cache if it was seen before. The prover list is
+// left out of cached files since it changes, proof tells whether this provider is on it.
+func (s *chainState) file(ctx context.Context, merkle []byte, owner string, start int64) (*types.UnifiedFile, error) {
+	k := stateKey(merkle, owner, start)
+
+	s.lock.RLock()
+	f, ok := s.files[k]
+	s.lock.RUnlock()
+	if ok {
+		return &f, nil
+	}
+
+	res, err := s.queryFile(ctx, merkle, owner, start)
+	if err != nil {
+		return nil, err
+	}
+
+	f = res.File
+	f.Proofs = nil
+	f.Note = ""
+
+	s.lock.Lock()
+	if _, proven := s.proofs[k]; proven {
+		s.files[k] = f
+	}
+	s.lock.Unlock()
+
+	return &f, nil
+}
+
+// queryFile reads a file from the chain with its current prover list.
+func (s *chainState) queryFile(ctx context.Context, merkle []byte, owner string, start int64) (*types.QueryFileResponse, error) {
+	chainQueries.WithLabelValues("file").Inc()
+	t := time.Now()
+	res, err := s.cl.File(ctx, &types.QueryFile{
+		Merkle: merkle,
+		Owner:  owner,
+		Start:  start,
+	})
+	s.report(t, err)
+	return res, err
+}
+
+// queryProof reads the proof of this provider for a contract from the chain.
+func (s *chainState) queryProof(ctx context.Context, merkle []byte, owner string, start int64) (*types.QueryProofResponse, error) {
+	chainQueries.WithLabelValues("proof").Inc()
+	t := time.Now()
+	res, err := s.cl.Proof(ctx, &types.QueryProof{
+		ProviderAddress: s.address,
+		Merkle:          merkle,
+		Owner:           owner,
+		Start:           start,
+	})
+	s.report(t, err)
+	return res, err
+}
+
+func (s *chainState) report(started time.Time, err error) {
+	if s.observe != nil {
+		s.observe(time.Since(started), err)
+	}
+}
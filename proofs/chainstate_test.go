@@ -0,0 +1,126 @@
+package proofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/types/query"
+	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeChain answers the proof state queries from memory and counts them.
+type fakeChain struct {
+	storageTypes.QueryClient
+	proofs   []storageTypes.FileProof
+	fail     bool
+	proofErr error
+	pages    int
+	queried  int
+}
+
+func (c *fakeChain) ProofsByAddress(_ context.Context, in *storageTypes.QueryProofsByAddress, _ ...grpc.CallOption) (*storageTypes.QueryProofsByAddressResponse, error) {
+	if c.fail {
+		return nil, errors.New("node unavailable")
+	}
+	c.pages++
+
+	offset := 0
+	if len(in.Pagination.Key) > 0 {
+		_, err := fmt.Sscanf(string(in.Pagination.Key), "%d", &offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	end := min(offset+int(in.Pagination.Limit), len(c.proofs))
+
+	res := &storageTypes.QueryProofsByAddressResponse{
+		Proofs:     c.proofs[offset:end],
+		Pagination: &query.PageResponse{},
+	}
+	if end < len(c.proofs) {
+		res.Pagination.NextKey = []byte(fmt.Sprintf("%d", end))
+	}
+	return res, nil
+}
+
+func (c *fakeChain) File(_ context.Context, in *storageTypes.QueryFile, _ ...grpc.CallOption) (*storageTypes.QueryFileResponse, error) {
+	c.queried++
+	return &storageTypes.QueryFileResponse{File: storageTypes.UnifiedFile{
+		Merkle:        in.Merkle,
+		Owner:         in.Owner,
+		Start:         in.Start,
+		ProofInterval: 100,
+		Proofs:        []string{string(storageTypes.ProofKey("prover", in.Merkle, in.Owner, in.Start))},
+		Note:          "{}",
+	}}, nil
+}
+
+func (c *fakeChain) Proof(_ context.Context, _ *storageTypes.QueryProof, _ ...grpc.CallOption) (*storageTypes.QueryProofResponse, error) {
+	return nil, c.proofErr
+}
+
+func TestAuditProofQuery(t *testing.T) {
+	r := require.New(t)
+
+	// a proof that cannot be read says nothing about which chunk is due
+	chain := &fakeChain{proofErr: status.Error(codes.Unavailable, "node unavailable")}
+	failure, skipped := audit(context.Background(), chain, nil, "prover", contractID{merkle: []byte("m"), owner: "owner"}, 1024)
+	r.False(skipped)
+	r.NotNil(failure)
+	r.Equal(AuditChainQuery, failure.Reason)
+}
+
+func TestChainState(t *testing.T) {
+	r := require.New(t)
+
+	chain := &fakeChain{}
+	for i := 0; i < proofStatePageSize*2+5; i++ {
+		chain.proofs = append(chain.proofs, storageTypes.FileProof{
+			Merkle:       []byte(fmt.Sprintf("m%d", i)),
+			Owner:        "owner",
+			Start:        int64(i),
+			ChunkToProve: int64(i % 7),
+		})
+	}
+
+	s := newChainState(chain, "prover")
+	ctx := context.Background()
+
+	_, found := s.proof([]byte("m1"), "owner", 1)
+	r.False(found)
+
+	r.NoError(s.refresh(ctx))
+	r.Equal(3, chain.pages)
+
+	p, found := s.proof([]byte("m10"), "owner", 10)
+	r.True(found)
+	r.Equal(int64(3), p.ChunkToProve)
+
+	// the terms of a contract are only queried once
+	for i := 0; i < 3; i++ {
+		f, err := s.file(ctx, []byte("m10"), "owner", 10)
+		r.NoError(err)
+		r.Equal(int64(100), f.ProofInterval)
+		r.Empty(f.Proofs)
+	}
+	r.Equal(1, chain.queried)
+
+	// contracts that are no longer proven are forgotten on the next refresh
+	chain.proofs = chain.proofs[:5]
+	r.NoError(s.refresh(ctx))
+	_, found = s.proof([]byte("m10"), "owner", 10)
+	r.False(found)
+	r.Empty(s.files)
+
+	// a failed refresh leaves every contract to be queried on its own
+	chain.fail = true
+	r.Error(s.refresh(ctx))
+	_, found = s.proof([]byte("m1"), "owner", 1)
+	r.False(found)
+}
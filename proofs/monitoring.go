@@ -9,3 +9,28 @@ var filesProving = promauto.NewGauge(prometheus.GaugeOpts{
 	Name: "sequoia_current_proofs_processing",
 	Help: "The number of files currently being proven",
 })
+
+var proofsDue = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_proofs_due",
+	Help: "The number of files dispatched for proving in the last proof cycle",
+})
+
+var proofAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sequoia_proof_attempts_total",
+	Help: "The number of proof attempts by their result",
+}, []string{"result"})
+
+var chainQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sequoia_proof_chain_queries_total",
+	Help: "The number of chain queries made by the prover by query",
+}, []string{"query"})
+
+var proofStateContracts = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_proof_state_contracts",
+	Help: "The number of contracts in the proof state fetched from the chain at the start of the last cycle",
+})
+
+var proofConcurrency = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sequoia_proof_concurrency",
+	Help: "The number of proofs the prover currently allows in flight at once",
+})
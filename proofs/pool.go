@@ -0,0 +1,155 @@
+package proofs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	initialProofWorkers = 16
+	// proofLatencyTarget is how long a chain query may take before the node counts as overloaded.
+	proofLatencyTarget = 2 * time.Second
+	// proofErrorRate is the share of failed proofs in a window above which concurrency stops growing.
+	proofErrorRate = 0.1
+	// proofBackoffCooldown keeps the proofs in flight during an overload from cutting the limit over and over.
+	proofBackoffCooldown = 5 * time.Second
+)
+
+// congested reports whether err means the chain node is overloaded.
+func congested(err error) bool {
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.ResourceExhausted
+}
+
+// workerPool runs proofs with a concurrency limit that adapts to the chain node the way TCP
+// congestion control does. The limit doubles up to a threshold and then grows by one for every
+// window of as many proofs as it allows, as long as queries stay fast and few proofs fail. It is
+// halved whenever the node reports being overloaded or answers slower than proofLatencyTarget.
+type workerPool struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	active    int
+	limit     int
+	max       int
+	threshold int // the limit doubles up to here and grows by one after
+
+	completed int // proofs finished in the current window
+	failed    int
+	backedOff time.Time
+}
+
+func newWorkerPool(maxWorkers int) *workerPool {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+
+	w := &workerPool{
+		limit:     min(initialProofWorkers, maxWorkers),
+		max:       maxWorkers,
+		threshold: maxWorkers,
+	}
+	w.cond = sync.NewCond(&w.lock)
+	proofConcurrency.Set(float64(w.limit))
+
+	return w
+}
+
+// submit runs job once fewer proofs than the limit are in flight, returning early if ctx is done.
+func (w *workerPool) submit(ctx context.Context, job func() error) error {
+	stop := context.AfterFunc(ctx, func() {
+		w.lock.Lock()
+		w.cond.Broadcast()
+		w.lock.Unlock()
+	})
+	defer stop()
+
+	w.lock.Lock()
+	for w.active >= w.limit && ctx.Err() == nil {
+		w.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		w.lock.Unlock()
+		return ctx.Err()
+	}
+	w.active++
+	w.lock.Unlock()
+
+	go func() {
+		err := job()
+		w.done(err)
+	}()
+
+	return nil
+}
+
+func (w *workerPool) done(err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	defer w.cond.Broadcast()
+
+	w.active--
+
+	if congested(err) {
+		w.backoff()
+		return
+	}
+
+	w.completed++
+	if err != nil {
+		w.failed++
+	}
+	if w.completed < w.limit {
+		return
+	}
+
+	if float64(w.failed)/float64(w.completed) <= proofErrorRate {
+		w.grow()
+	}
+	w.completed = 0
+	w.failed = 0
+}
+
+// observe takes the latency and outcome of a chain query made by a proof.
+func (w *workerPool) observe(latency time.Duration, err error) {
+	if latency <= proofLatencyTarget && !congested(err) {
+		return
+	}
+
+	w.lock.Lock()
+	w.backoff()
+	w.lock.Unlock()
+}
+
+func (w *workerPool) grow() {
+	if w.limit < w.threshold {
+		w.limit = min(w.limit*2, w.threshold)
+	} else {
+		w.limit = min(w.limit+1, w.max)
+	}
+	proofConcurrency.Set(float64(w.limit))
+}
+
+func (w *workerPool) backoff() {
+	if time.Since(w.backedOff) < proofBackoffCooldown {
+		return
+	}
+
+	w.limit = max(w.limit/2, 1)
+	w.threshold = w.limit
+	w.completed = 0
+	w.failed = 0
+	w.backedOff = time.Now()
+	proofConcurrency.Set(float64(w.limit))
+}
+
+// level returns the current concurrency limit and the proofs in flight.
+func (w *workerPool) level() (int, int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.limit, w.active
+}
@@ -0,0 +1,96 @@
+package proofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWorkerPool(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	w := newWorkerPool(100)
+	limit, _ := w.level()
+	r.Equal(initialProofWorkers, limit)
+
+	run := func(n int, err error) {
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			r.NoError(w.submit(ctx, func() error {
+				defer wg.Done()
+				return err
+			}))
+		}
+		wg.Wait()
+		// done runs after the job returns
+		r.Eventually(func() bool {
+			_, active := w.level()
+			return active == 0
+		}, time.Second, time.Millisecond)
+	}
+
+	// a healthy window doubles the limit until the maximum
+	run(initialProofWorkers, nil)
+	limit, _ = w.level()
+	r.Equal(initialProofWorkers*2, limit)
+
+	run(200, nil)
+	limit, _ = w.level()
+	r.Equal(100, limit)
+
+	// an overloaded node halves it once, the rest of the failures in flight are ignored
+	run(10, status.Error(codes.Unavailable, "node overloaded"))
+	limit, _ = w.level()
+	r.Equal(50, limit)
+
+	// past an overload the limit only grows by one per window
+	w.backedOff = time.Time{}
+	run(50, nil)
+	limit, _ = w.level()
+	r.Equal(51, limit)
+
+	// too many failed proofs keep it where it is
+	run(51, errors.New("proof rejected"))
+	limit, _ = w.level()
+	r.Equal(51, limit)
+
+	// slow queries and wrapped overload errors back off as well
+	w.observe(proofLatencyTarget*2, nil)
+	limit, _ = w.level()
+	r.Equal(25, limit)
+
+	w.backedOff = time.Time{}
+	w.observe(time.Millisecond, fmt.Errorf("cannot query | %w", status.Error(codes.ResourceExhausted, "rate limited")))
+	limit, _ = w.level()
+	r.Equal(12, limit)
+}
+
+func TestWorkerPoolLimit(t *testing.T) {
+	r := require.New(t)
+
+	w := newWorkerPool(2)
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		r.NoError(w.submit(context.Background(), func() error {
+			<-release
+			return nil
+		}))
+	}
+
+	// a full pool blocks until a worker frees up or the prover stops
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r.ErrorIs(w.submit(ctx, func() error { return nil }), context.DeadlineExceeded)
+
+	close(release)
+	r.NoError(w.submit(context.Background(), func() error { return nil }))
+}
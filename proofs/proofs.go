@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/JackalLabs/sequoia/config"
 	sequoiaTypes "github.com/JackalLabs/sequoia/types"
 	treeblake3 "github.com/wealdtech/go-merkletree/v2/blake3"
 	"github.com/zeebo/blake3"
@@ -37,9 +38,15 @@ const (
 	ErrNotReady = "not ready yet"
 )
 
-func GenerateMerkleProof(tree *merkletree.MerkleTree, index int, item []byte, proofType int64) (bool, *merkletree.Proof, error) {
-	log.Debug().Msg(fmt.Sprintf("Generating Merkle proof for %d", index))
+var (
+	// errGenerate marks proofs that failed to build from the stored data rather than from the chain.
+	errGenerate = errors.New("could not gen proof")
+	// errInvalidTree means the chunk read from disk does not hash into the stored tree.
+	errInvalidTree = errors.New("tree not valid")
+)
 
+// leafHash hashes a chunk into the leaf the chain expects at index.
+func leafHash(index int64, item []byte, proofType int64) ([]byte, error) {
 	h := sha256.New()
 	if proofType == sequoiaTypes.ProofTypeBlake3 {
 		h = blake3.New()
@@ -47,20 +54,50 @@ func GenerateMerkleProof(tree *merkletree.MerkleTree, index int, item []byte, pr
 
 	_, err := fmt.Fprintf(h, "%d%x", index, item)
 	if err != nil {
-		return false, nil, err
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// treeHash returns the hash the merkle tree of proofType is built with.
+func treeHash(proofType int64) merkletree.HashType {
+	if proofType == sequoiaTypes.ProofTypeBlake3 {
+		return treeblake3.New256()
+	}
+	return sha3.New512()
+}
+
+// VerifyProof checks a proof made by GenProof the way the chain does, against the merkle root of
+// the contract rather than the tree it was generated from.
+func VerifyProof(merkle []byte, index int64, item []byte, jproof []byte, proofType int64) (bool, error) {
+	var proof merkletree.Proof
+	err := json.Unmarshal(jproof, &proof)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse proof | %w", err)
 	}
 
-	proof, err := tree.GenerateProof(h.Sum(nil), 0)
+	leaf, err := leafHash(index, item, proofType)
+	if err != nil {
+		return false, err
+	}
+
+	return merkletree.VerifyProofUsing(leaf, false, &proof, [][]byte{merkle}, treeHash(proofType))
+}
+
+func GenerateMerkleProof(tree sequoiaTypes.ProofTree, index int, item []byte, proofType int64) (bool, *merkletree.Proof, error) {
+	log.Debug().Msg(fmt.Sprintf("Generating Merkle proof for %d", index))
+
+	leaf, err := leafHash(int64(index), item, proofType)
 	if err != nil {
 		return false, nil, err
 	}
 
-	var treeHash merkletree.HashType = sha3.New512()
-	if proofType == sequoiaTypes.ProofTypeBlake3 {
-		treeHash = treeblake3.New256()
+	proof, err := tree.GenerateProofWithIndex(uint64(index), 0)
+	if err != nil {
+		return false, nil, err
 	}
 
-	valid, err := merkletree.VerifyProofUsing(h.Sum(nil), false, proof, [][]byte{tree.Root()}, treeHash)
+	valid, err := merkletree.VerifyProofUsing(leaf, false, proof, [][]byte{tree.Root()}, treeHash(proofType))
 	if err != nil {
 		return false, nil, err
 	}
@@ -85,9 +122,8 @@ func GenProof(io FileSystem, merkle []byte, owner string, start int64, block int
 		return nil, nil, err
 	}
 	if !valid {
-		e := errors.New("tree not valid")
-		log.Error().Err(fmt.Errorf("tree not valid for %x %w", merkle, e))
-		return nil, nil, e
+		log.Error().Err(fmt.Errorf("tree not valid for %x %w", merkle, errInvalidTree))
+		return nil, nil, errInvalidTree
 	}
 
 	jproof, err := json.Marshal(*proof)
@@ -100,51 +136,77 @@ func GenProof(io FileSystem, merkle []byte, owner string, start int64, block int
 	return jproof, chunk, nil
 }
 
-func (p *Prover) GenerateProof(merkle []byte, owner string, start int64, blockHeight int64, startedAt time.Time) ([]byte, []byte, int64, error) {
-	log.Debug().Msg(fmt.Sprintf("Generating proof for %x", merkle))
-	queryParams := &types.QueryFile{
-		Merkle: merkle,
-		Owner:  owner,
-		Start:  start,
+// nextDueHeight returns the first height of the proof window after the one containing height,
+// which is when a contract proven at height has to be proven again.
+func nextDueHeight(file *types.UnifiedFile, height int64) int64 {
+	if file.ProofInterval <= 0 {
+		return height
 	}
+	k := height - file.Start
+	return height - k%file.ProofInterval + file.ProofInterval
+}
 
-	cl := types.NewQueryClient(p.wallet.Client.GRPCConn)
-
-	res, err := cl.File(context.Background(), queryParams)
+// schedule records when a contract is next due, failures only cost an extra check on the next cycle.
+func (p *Prover) schedule(merkle []byte, owner string, start int64, due int64) {
+	err := p.io.ScheduleProof(merkle, owner, start, due)
 	if err != nil {
-		return nil, nil, 0, err
+		log.Warn().
+			Err(err).
+			Hex("merkle", merkle).
+			Str("owner", owner).
+			Int64("start", start).
+			Int64("due", due).
+			Msg("could not update proof schedule")
 	}
+}
 
-	file := res.File
-
-	proofQuery := &types.QueryProof{
-		ProviderAddress: p.wallet.AccAddress(),
-		Merkle:          file.Merkle,
-		Owner:           file.Owner,
-		Start:           file.Start,
-	}
+// GenerateProof builds the proof a contract is due at blockHeight, returning the proof, the chunk
+// it covers, the index of that chunk and the proof interval of the contract. The proof is nil when
+// there is nothing to prove.
+func (p *Prover) GenerateProof(merkle []byte, owner string, start int64, blockHeight int64, startedAt time.Time) ([]byte, []byte, int64, int64, error) {
+	log.Debug().Msg(fmt.Sprintf("Generating proof for %x", merkle))
+	ctx := context.Background()
 
 	newProof := types.FileProof{ // defining a new proof model
 		Prover:       p.wallet.AccAddress(),
-		Merkle:       file.Merkle,
-		Owner:        file.Owner,
-		Start:        file.Start,
+		Merkle:       merkle,
+		Owner:        owner,
+		Start:        start,
 		LastProven:   0,
 		ChunkToProve: 0,
 	}
 
-	if file.ContainsProver(p.wallet.AccAddress()) {
-		// file is ours
-		proofRes, err := cl.Proof(context.Background(), proofQuery)
-		if err == nil {
-			newProof = proofRes.Proof // found the proof, we're good to go
+	var file *types.UnifiedFile
+	if proof, ok := p.state.proof(merkle, owner, start); ok {
+		// file is ours and its proof was fetched with the rest at the start of the cycle
+		f, err := p.state.file(ctx, merkle, owner, start)
+		if err != nil {
+			return nil, nil, 0, 0, err
 		}
+		file = f
+		newProof = proof
 	} else {
-		// file is not ours, we need to figure out what to do with it
-		if len(file.Proofs) == int(file.MaxProofs) {
-			// disable not ours check
-			// return nil, nil, 0, errors.New(ErrNotOurs) // there is no more room on this file anyway, ignore it
-			return nil, nil, 0, nil // there is no more room on this file anyway, ignore it
+		res, err := p.state.queryFile(ctx, merkle, owner, start)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+		file = &res.File
+
+		if file.ContainsProver(p.wallet.AccAddress()) {
+			// file is ours
+			proofRes, err := p.state.queryProof(ctx, merkle, owner, start)
+			if err == nil {
+				newProof = proofRes.Proof // found the proof, we're good to go
+			}
+		} else {
+			// file is not ours, we need to figure out what to do with it
+			if len(file.Proofs) == int(file.MaxProofs) {
+				// disable not ours check
+				// return nil, nil, 0, errors.New(ErrNotOurs) // there is no more room on this file anyway, ignore it
+				// check again next window in case room opens up
+				p.schedule(merkle, owner, start, nextDueHeight(file, blockHeight))
+				return nil, nil, 0, file.ProofInterval, nil // there is no more room on this file anyway, ignore it
+			}
 		}
 	}
 
@@ -155,7 +217,8 @@ func (p *Prover) GenerateProof(merkle []byte, owner string, start int64, blockHe
 	proven := file.ProvenThisBlock(blockHeight+int64(t.Seconds()/6.0), newProof.LastProven)
 	if proven {
 		log.Debug().Msg(fmt.Sprintf("%x was already proven at %d, height is now %d", file.Merkle, newProof.LastProven, blockHeight))
-		return nil, nil, 0, nil
+		p.schedule(merkle, owner, start, nextDueHeight(file, newProof.LastProven))
+		return nil, nil, 0, file.ProofInterval, nil
 	}
 	log.Debug().Msg(fmt.Sprintf("%x was not yet proven at %d, height is now %d", file.Merkle, newProof.LastProven, blockHeight))
 
@@ -165,16 +228,44 @@ func (p *Prover) GenerateProof(merkle []byte, owner string, start int64, blockHe
 
 	proof, item, err := GenProof(p.io, merkle, owner, start, block, p.chunkSize, file.ProofType)
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("could not gen proof: %w", err)
+		return nil, nil, newProof.ChunkToProve, file.ProofInterval, fmt.Errorf("%w: %w", errGenerate, err)
 	}
 
-	return proof, item, newProof.ChunkToProve, err
+	// assume the proof lands in this window, PostProof puts the contract back in line if it does not
+	p.schedule(merkle, owner, start, nextDueHeight(file, blockHeight))
+
+	return proof, item, newProof.ChunkToProve, file.ProofInterval, err
+}
+
+// record adds the outcome of an attempt to the proof history of a contract.
+func (p *Prover) record(merkle []byte, owner string, start int64, attempt *sequoiaTypes.ProofAttempt, result string, err error) {
+	attempt.Result = result
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	proofAttempts.WithLabelValues(result).Inc()
+
+	recordErr := p.io.RecordProofAttempt(merkle, owner, start, attempt)
+	if recordErr != nil {
+		log.Warn().
+			Err(recordErr).
+			Hex("merkle", merkle).
+			Str("owner", owner).
+			Int64("start", start).
+			Msg("could not record proof attempt")
+	}
 }
 
 func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight int64, startedAt time.Time) error {
-	proof, item, index, err := p.GenerateProof(merkle, owner, start, blockHeight, startedAt)
-	p.Dec()
-	filesProving.Dec()
+	proof, item, index, window, err := p.GenerateProof(merkle, owner, start, blockHeight, startedAt)
+
+	attempt := &sequoiaTypes.ProofAttempt{
+		Time:   time.Now(),
+		Height: blockHeight,
+		Window: window,
+		Chunk:  index,
+	}
+
 	if err != nil {
 		log.Error().
 			Hex("merkle", merkle).
@@ -183,6 +274,12 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Err(err).
 			Msg("Proof generation failed")
 
+		if errors.Is(err, errGenerate) {
+			p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultGeneration, err)
+		} else {
+			p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultChain, err)
+		}
+
 		if errors.Is(err, badger.ErrKeyNotFound) {
 			if removeErr := p.io.DeleteFile(merkle, owner, start); removeErr != nil { // delete the key upon failure?
 				log.Error().
@@ -217,6 +314,8 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Int64("start", start).
 			Err(m.Error()).
 			Msg("Proof posting failed, will try again")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultBroadcast, m.Error())
 		return m.Error()
 	}
 
@@ -226,9 +325,14 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Str("owner", owner).
 			Int64("start", start).
 			Msg("Message response was nil")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultResponse, errors.New("message response was nil"))
 		return nil
 	}
 
+	attempt.TxHash = m.Res().TxHash
+	attempt.Code = m.Res().Code
+
 	if m.Res().Code != 0 {
 		log.Warn().
 			Hex("merkle", merkle).
@@ -236,6 +340,8 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Uint32("code", m.Res().Code).
 			Int64("start", start).
 			Msgf("response was %s", m.Res().RawLog)
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultTx, errors.New(m.Res().RawLog))
 		return nil
 	}
 
@@ -248,6 +354,8 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Int64("start", start).
 			Err(err).
 			Msg("Could not decode response body")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultResponse, err)
 		return err
 	}
 
@@ -261,6 +369,8 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Int64("start", start).
 			Err(err).
 			Msg("Could not parse response body")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultResponse, err)
 
 		return err
 
@@ -272,6 +382,8 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Str("owner", owner).
 			Int64("start", start).
 			Msg("No response data")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultResponse, errors.New("no response data"))
 		return nil
 	}
 
@@ -283,6 +395,8 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Int64("start", start).
 			Err(err).
 			Msg("Could not unmarshal response body")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultResponse, err)
 
 		return err
 	}
@@ -294,8 +408,12 @@ func (p *Prover) PostProof(merkle []byte, owner string, start int64, blockHeight
 			Int64("start", start).
 			Err(errors.New(postRes.ErrorMessage)).
 			Msg("Failed to prove file")
+		p.schedule(merkle, owner, start, blockHeight)
+		p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultRejected, errors.New(postRes.ErrorMessage))
+		return nil
 	}
 
+	p.record(merkle, owner, start, attempt, sequoiaTypes.ProofResultProven, nil)
 	return nil
 }
 
@@ -343,33 +461,69 @@ func (p *Prover) Start() {
 			continue
 		}
 
-		var count int // reset last count here
+		// one paged query for the proofs of every contract instead of two queries for each
+		err = p.state.refresh(c)
+		if err != nil {
+			log.Warn().Err(err).Msg("could not fetch proof state, querying every contract on its own")
+		}
+
 		t := time.Now()
 
-		err = p.io.ProcessFiles(func(merkle []byte, owner string, start int64) {
-			for p.Full() {
-				log.Debug().Msg("Proving queue is full, waiting...")
+		// contracts are listed first so the database is not held open while waiting on workers
+		contracts := make([]contractID, 0)
+		prove := func(merkle []byte, owner string, start int64) {
+			contracts = append(contracts, contractID{merkle: merkle, owner: owner, start: start})
+		}
 
-				time.Sleep(time.Second * 5)
-			}
-			log.Debug().Msg(fmt.Sprintf("proving: %x", merkle))
-			filesProving.Inc()
-			p.Inc()
-			count++
-			go p.wrapPostProof(merkle, owner, start, height, t)
-		})
+		// only contracts that are due get checked, a full scan every so often picks up
+		// anything the schedule missed and brings every entry back in line with the chain
+		rescan := p.rescanned.Add(time.Second * time.Duration(p.rescanInterval)).Before(time.Now())
+		if rescan {
+			log.Info().Msg("Reconciling proof schedule against every stored file...")
+			err = p.io.ProcessFiles(prove)
+			p.rescanned = time.Now()
+		} else {
+			err = p.io.ProcessDueFiles(height, prove)
+		}
 		if err != nil {
 			log.Error().Err(err)
 		}
 
-		p.lastCount = count
+		var count int // reset last count here
+		for _, contract := range contracts {
+			log.Debug().Msg(fmt.Sprintf("proving: %x", contract.merkle))
+			err = p.pool.submit(p.ctx, func() error {
+				filesProving.Inc()
+				defer filesProving.Dec()
+				return p.wrapPostProof(contract.merkle, contract.owner, contract.start, height, t)
+			})
+			if err != nil { // the prover is stopping
+				break
+			}
+			count++
+		}
+
+		workers, active := p.pool.level()
+		proofsDue.Set(float64(count))
+		log.Debug().
+			Int("count", count).
+			Bool("rescan", rescan).
+			Int("workers", workers).
+			Int("active", active).
+			Msg("Proof cycle dispatched")
+
+		if rescan {
+			p.lastCount = count
+		}
 
 		p.processed = time.Now()
 	}
 	log.Info().Msg("Prover module stopped")
 }
 
-func (p *Prover) wrapPostProof(merkle []byte, owner string, start int64, height int64, startedAt time.Time) {
+// wrapPostProof proves a contract and deals with the outcome, the error is passed on so the
+// worker pool can tell how the chain node is holding up.
+func (p *Prover) wrapPostProof(merkle []byte, owner string, start int64, height int64, startedAt time.Time) error {
 	err := p.PostProof(merkle, owner, start, height, startedAt)
 	if err != nil {
 		log.Warn().
@@ -389,7 +543,7 @@ func (p *Prover) wrapPostProof(merkle []byte, owner string, start int64, height
 				Time("startedAt", startedAt).
 				Err(err).
 				Msg("problem with rpc node")
-			return
+			return err
 		}
 		if code := status.Code(err); code == codes.NotFound {
 			log.Debug().
@@ -424,23 +578,37 @@ func (p *Prover) wrapPostProof(merkle []byte, owner string, start int64, height
 		//	}
 		//}
 	}
+	return err
 }
 
 func (p *Prover) Stop() {
 	p.running = false
+	p.cancel()
 }
 
-func NewProver(wallet *wallet.Wallet, q *queue.Queue, io FileSystem, interval uint64, threads int16, chunkSize int) *Prover {
+func NewProver(wallet *wallet.Wallet, q *queue.Queue, io FileSystem, interval uint64, rescanInterval uint64, threads int16, chunkSize int) *Prover {
+	if rescanInterval == 0 {
+		rescanInterval = config.DefaultProofRescan()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	p := Prover{
-		running:   false,
-		wallet:    wallet,
-		q:         q,
-		processed: time.Time{},
-		interval:  interval,
-		io:        io,
-		threads:   threads,
-		chunkSize: chunkSize,
+		running:        false,
+		wallet:         wallet,
+		q:              q,
+		processed:      time.Time{},
+		interval:       interval,
+		rescanInterval: rescanInterval,
+		rescanned:      time.Time{}, // the first cycle always scans everything to seed the schedule
+		io:             io,
+		state:          newChainState(types.NewQueryClient(wallet.Client.GRPCConn), wallet.AccAddress()),
+		pool:           newWorkerPool(int(threads)),
+		ctx:            ctx,
+		cancel:         cancel,
+		chunkSize:      chunkSize,
 	}
+	p.state.observe = p.pool.observe
 
 	return &p
 }
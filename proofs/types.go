@@ -1,9 +1,10 @@
 package proofs
 
 import (
+	"context"
 	"time"
 
-	merkletree "github.com/wealdtech/go-merkletree/v2"
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
 
 	"github.com/JackalLabs/sequoia/queue"
 	"github.com/desmos-labs/cosmos-go-wallet/wallet"
@@ -15,27 +16,31 @@ type Prover struct {
 	q              *queue.Queue
 	processed      time.Time
 	interval       uint64
+	rescanInterval uint64
+	rescanned      time.Time
 	io             FileSystem
-	threads        int16
-	currentThreads int16
+	state          *chainState
+	pool           *workerPool
+	ctx            context.Context
+	cancel         context.CancelFunc
 	chunkSize      int
 	lastCount      int
 }
 
+// contractID identifies a storage contract.
+type contractID struct {
+	merkle []byte
+	owner  string
+	start  int64
+}
+
 type FileSystem interface {
 	DeleteFile([]byte, string, int64) error
 	ProcessFiles(func([]byte, string, int64)) error
-	GetFileTreeByChunk([]byte, string, int64, int, int, int64) (*merkletree.MerkleTree, []byte, error)
-}
-
-func (p *Prover) Inc() {
-	p.currentThreads++
-}
-
-func (p *Prover) Dec() {
-	p.currentThreads--
-}
-
-func (p *Prover) Full() bool {
-	return p.threads <= p.currentThreads
+	ProcessDueFiles(int64, func([]byte, string, int64)) error
+	ScheduleProof([]byte, string, int64, int64) error
+	GetFileTreeByChunk([]byte, string, int64, int, int, int64) (sequoiaTypes.ProofTree, []byte, error)
+	RecordProofAttempt([]byte, string, int64, *sequoiaTypes.ProofAttempt) error
+	CheckTree([]byte, string, int64) (bool, error)
+	GetCIDFromMerkle([]byte) (string, error)
 }
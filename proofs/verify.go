@@ -0,0 +1,138 @@
+package proofs
+
+import (
+	"bytes"
+	"fmt"
+
+	sequoiaTypes "github.com/JackalLabs/sequoia/types"
+	merkletree "github.com/wealdtech/go-merkletree/v2"
+)
+
+// ProofStep is one step of checking a proof, Detail says what was checked or why it failed.
+type ProofStep struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// ProofCheck walks through a proof the way the chain verifies it, so a failing proof can be traced
+// to the step that breaks.
+type ProofCheck struct {
+	Valid bool
+	Leaf  []byte // hash of the chunk index and item
+	Root  []byte // root the sibling hashes lead to from the leaf
+	Steps []ProofStep
+	Hints []string // what most likely went wrong when the proof does not verify
+}
+
+func (c *ProofCheck) step(name string, ok bool, format string, args ...any) {
+	c.Steps = append(c.Steps, ProofStep{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+}
+
+func hashNames(proofType int64) (string, string) {
+	if proofType == sequoiaTypes.ProofTypeBlake3 {
+		return "blake3", "blake3"
+	}
+	return "sha256", "sha3-512"
+}
+
+// proofRoot folds the sibling hashes of proof into a root starting from leaf, the same way the
+// merkletree package does when verifying.
+func proofRoot(leaf []byte, proof *merkletree.Proof, hashType merkletree.HashType) []byte {
+	h := hashType.Hash(leaf)
+	index := proof.Index + (1 << uint(len(proof.Hashes)))
+	for _, sibling := range proof.Hashes {
+		if index%2 == 0 {
+			h = hashType.Hash(h, sibling)
+		} else {
+			h = hashType.Hash(sibling, h)
+		}
+		index >>= 1
+	}
+	return h
+}
+
+// CheckProof verifies the payload of a MsgPostProof against the merkle root of its contract and
+// explains every step: parsing the hash list, the sizes of the root and sibling hashes, the chunk
+// index the hash list was made for, the leaf hash and the root it leads to.
+func CheckProof(merkle []byte, index int64, item []byte, jproof []byte, proofType int64) *ProofCheck {
+	c := &ProofCheck{}
+	leafName, treeName := hashNames(proofType)
+	hashType := treeHash(proofType)
+	size := hashType.HashLength()
+
+	var proof merkletree.Proof
+	err := json.Unmarshal(jproof, &proof)
+	if err != nil {
+		c.step("parse hash list", false, "not a hash list made by GenProof: %s", err)
+		return c
+	}
+	c.step("parse hash list", true, "%d sibling hashes, proof for chunk %d", len(proof.Hashes), proof.Index)
+
+	if len(merkle) != size {
+		c.step("merkle root", false, "root is %d bytes but %s roots are %d bytes, is proof type %d right?", len(merkle), treeName, size, proofType)
+	} else {
+		c.step("merkle root", true, "%d byte %s root", size, treeName)
+	}
+
+	sizesOK := true
+	for i, sibling := range proof.Hashes {
+		if len(sibling) != size {
+			c.step("sibling hashes", false, "hash %d is %d bytes but %s hashes are %d bytes", i, len(sibling), treeName, size)
+			sizesOK = false
+			break
+		}
+	}
+	if sizesOK {
+		c.step("sibling hashes", true, "all %d bytes", size)
+	}
+
+	if index < 0 || uint64(index) != proof.Index {
+		c.step("chunk index", false, "the hash list proves chunk %d but the item is hashed as chunk %d", proof.Index, index)
+	} else if proof.Index >= 1<<uint(len(proof.Hashes)) {
+		c.step("chunk index", false, "chunk %d is past the end of a tree %d levels deep", proof.Index, len(proof.Hashes))
+	} else {
+		c.step("chunk index", true, "chunk %d", index)
+	}
+
+	c.Leaf, err = leafHash(index, item, proofType)
+	if err != nil {
+		c.step("leaf hash", false, "%s", err)
+		return c
+	}
+	c.step("leaf hash", true, "%s of chunk %d and its %d byte item is %x", leafName, index, len(item), c.Leaf)
+
+	c.Root = proofRoot(c.Leaf, &proof, hashType)
+	c.Valid = bytes.Equal(c.Root, merkle)
+	if !c.Valid {
+		c.step("root", false, "the hash list leads to %x, not %x", c.Root, merkle)
+		c.hint(merkle, index, item, &proof, proofType)
+		return c
+	}
+	c.step("root", true, "the hash list leads to the merkle root")
+
+	return c
+}
+
+// hint looks for a reading of a failed payload that verifies, which usually points at the mistake.
+func (c *ProofCheck) hint(merkle []byte, index int64, item []byte, proof *merkletree.Proof, proofType int64) {
+	if uint64(index) != proof.Index {
+		leaf, err := leafHash(int64(proof.Index), item, proofType)
+		if err == nil && bytes.Equal(proofRoot(leaf, proof, treeHash(proofType)), merkle) {
+			c.Hints = append(c.Hints, fmt.Sprintf("the payload verifies as chunk %d", proof.Index))
+		}
+	}
+
+	other := int64(sequoiaTypes.ProofTypeBlake3)
+	if proofType == sequoiaTypes.ProofTypeBlake3 {
+		other = sequoiaTypes.ProofTypeDefault
+	}
+	leaf, err := leafHash(index, item, other)
+	if err == nil && bytes.Equal(proofRoot(leaf, proof, treeHash(other)), merkle) {
+		c.Hints = append(c.Hints, fmt.Sprintf("the payload verifies as proof type %d", other))
+	}
+
+	if len(c.Hints) == 0 {
+		c.Hints = append(c.Hints, "the item or a sibling hash differs from the ones the merkle root was built from")
+	}
+}
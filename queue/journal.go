@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// journalPrefix is the badger key prefix for messages waiting to be broadcast.
+// Keys are zero padded so iterating the prefix returns messages in insertion order.
+const journalPrefix = "queue/"
+
+// attemptsPrefix holds how many broadcasts of a journaled message ended without a known result.
+const attemptsPrefix = "queue-attempts/"
+
+func journalKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", journalPrefix, id))
+}
+
+func attemptsKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", attemptsPrefix, id))
+}
+
+// journal persists a message so it survives restarts until its broadcast result is known.
+func (q *Queue) journal(m *Message) error {
+	if q.db == nil {
+		return nil
+	}
+
+	data, err := q.cdc.MarshalInterface(m.msg)
+	if err != nil {
+		return fmt.Errorf("cannot encode queue message | %w", err)
+	}
+
+	return q.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(journalKey(m.id), data)
+	})
+}
+
+// saveAttempts persists the attempt counts of messages so a message that never goes through is
+// eventually given up on, even across restarts.
+func (q *Queue) saveAttempts(messages []*Message) {
+	if q.db == nil {
+		return
+	}
+
+	wb := q.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, m := range messages {
+		if m.id == 0 {
+			continue
+		}
+		err := wb.Set(attemptsKey(m.id), binary.BigEndian.AppendUint32(nil, uint32(m.attempts)))
+		if err != nil {
+			log.Warn().Err(err).Uint64("id", m.id).Msg("could not record queue message attempts")
+		}
+	}
+
+	err := wb.Flush()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not flush queue journal")
+	}
+}
+
+func getAttempts(txn *badger.Txn, id uint64) (int, error) {
+	item, err := txn.Get(attemptsKey(id))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var attempts int
+	err = item.Value(func(val []byte) error {
+		if len(val) != 4 {
+			return fmt.Errorf("invalid attempt count for queue message %d", id)
+		}
+		attempts = int(binary.BigEndian.Uint32(val))
+		return nil
+	})
+	return attempts, err
+}
+
+// forget removes the journal entries of messages that no longer need to be broadcast.
+func (q *Queue) forget(messages []*Message) {
+	if q.db == nil {
+		return
+	}
+
+	wb := q.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, m := range messages {
+		if m.id == 0 {
+			continue
+		}
+		err := wb.Delete(journalKey(m.id))
+		if err == nil {
+			err = wb.Delete(attemptsKey(m.id))
+		}
+		if err != nil {
+			log.Warn().Err(err).Uint64("id", m.id).Msg("could not remove message from queue journal")
+		}
+	}
+
+	err := wb.Flush()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not flush queue journal")
+	}
+}
+
+// loadJournal restores every unconfirmed message from the database into the in-memory queue.
+// Nobody waits on restored messages, so each gets its own WaitGroup to keep Done safe.
+func (q *Queue) loadJournal() error {
+	if q.db == nil {
+		return nil
+	}
+
+	prefix := []byte(journalPrefix)
+
+	return q.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			k := item.Key()
+
+			id, err := strconv.ParseUint(string(bytes.TrimPrefix(k, prefix)), 10, 64)
+			if err != nil {
+				log.Warn().Err(err).Str("key", string(k)).Msg("skipping malformed queue journal key")
+				continue
+			}
+
+			attempts, err := getAttempts(txn, id)
+			if err != nil {
+				log.Warn().Err(err).Uint64("id", id).Msg("could not read queue message attempts")
+			}
+
+			err = item.Value(func(val []byte) error {
+				var msg types.Msg
+				err := q.cdc.UnmarshalInterface(val, &msg)
+				if err != nil {
+					return err
+				}
+
+				var wg sync.WaitGroup
+				wg.Add(1)
+				q.messages = append(q.messages, &Message{
+					id:       id,
+					msg:      msg,
+					wg:       &wg,
+					attempts: attempts,
+				})
+
+				return nil
+			})
+			if err != nil {
+				log.Warn().Err(err).Uint64("id", id).Msg("skipping unreadable queue journal entry")
+			}
+
+			if id >= q.nextId {
+				q.nextId = id + 1
+			}
+		}
+
+		return nil
+	})
+}
@@ -12,17 +12,23 @@ import (
 	"time"
 
 	"github.com/JackalLabs/sequoia/config"
+	canine "github.com/jackalLabs/canine-chain/v5/app"
 	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
 
 	"github.com/cosmos/cosmos-sdk/types"
 	walletTypes "github.com/desmos-labs/cosmos-go-wallet/types"
 	"github.com/desmos-labs/cosmos-go-wallet/wallet"
+	"github.com/dgraph-io/badger/v4"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/time/rate"
 )
 
 // Rate limiter defaults are provided by config.DefaultRateLimitPerTokenMs and config.DefaultRateLimitBurst
 
+// maxBroadcastAttempts is how many batches a message can be part of without a known result before
+// it is failed, so a message that never goes through does not hold up the ones behind it.
+const maxBroadcastAttempts = 5
+
 // extractExpectedSequence extracts the expected sequence number from an account sequence mismatch error message.
 // It looks for the pattern "expected <number>" in the error message, allowing for optional whitespace.
 // Returns the expected sequence number and true if found, or 0 and false if not found.
@@ -71,7 +77,9 @@ func (m *Message) Done() {
 	m.wg.Done()
 }
 
-func NewQueue(w *wallet.Wallet, interval uint64, maxSizeBytes int64, domain string, rlCfg config.RateLimitConfig) *Queue {
+// NewQueue creates a transaction queue that journals pending messages to db and
+// restores any messages that were still unconfirmed when the provider last stopped.
+func NewQueue(w *wallet.Wallet, db *badger.DB, interval uint64, maxSizeBytes int64, domain string, rlCfg config.RateLimitConfig) *Queue {
 	if maxSizeBytes == 0 {
 		maxSizeBytes = config.DefaultMaxSizeBytes()
 	}
@@ -82,8 +90,11 @@ func NewQueue(w *wallet.Wallet, interval uint64, maxSizeBytes int64, domain stri
 		rlCfg.Burst = config.DefaultRateLimitConfig().Burst
 	}
 	q := &Queue{
-		wallet:       w,
+		chain:        walletChain{w: w},
+		db:           db,
+		cdc:          canine.MakeEncodingConfig().Marshaler,
 		messages:     make([]*Message, 0),
+		nextId:       1,
 		processed:    time.Now(),
 		running:      false,
 		interval:     interval,
@@ -91,6 +102,15 @@ func NewQueue(w *wallet.Wallet, interval uint64, maxSizeBytes int64, domain stri
 		domain:       domain,
 		limiter:      rate.NewLimiter(rate.Every(time.Duration(rlCfg.PerTokenMs)*time.Millisecond), rlCfg.Burst),
 	}
+
+	err := q.loadJournal()
+	if err != nil {
+		log.Error().Err(err).Msg("could not restore queue journal")
+	}
+	if len(q.messages) > 0 {
+		log.Info().Msgf("Restored %d unconfirmed messages to the queue", len(q.messages))
+	}
+
 	return q
 }
 
@@ -103,6 +123,9 @@ func (q *Queue) Add(msg types.Msg) (*Message, *sync.WaitGroup) {
 		err: nil,
 	}
 
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
 	proofMessage, ok := msg.(*storageTypes.MsgPostProof)
 	if ok {
 		for _, message := range q.messages {
@@ -126,6 +149,15 @@ func (q *Queue) Add(msg types.Msg) (*Message, *sync.WaitGroup) {
 		}
 	}
 
+	m.id = q.nextId
+	q.nextId++
+
+	err := q.journal(m)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not journal queue message, it will not survive a restart")
+		m.id = 0
+	}
+
 	wg.Add(1)
 
 	q.messages = append(q.messages, m) // adding the message to the end of the list
@@ -149,7 +181,7 @@ func (q *Queue) Listen() {
 		}
 
 		// Update gauge and attempt a broadcast cycle
-		total := len(q.messages)
+		total := q.Count()
 		queueSize.Set(float64(total))
 
 		if total == 0 { // skipping this queue cycle if there is no messages to be pushed
@@ -167,19 +199,24 @@ func (q *Queue) Listen() {
 }
 
 // BroadcastPending selects a batch that fits within max size, broadcasts it,
-// updates per-message results, and returns the number of messages processed
-// along with a terminal error if the broadcast attempts all failed.
+// updates per-message results, and returns the number of messages processed.
+// If the broadcast attempts all fail the batch is requeued and the error returned, messages that
+// were requeued maxBroadcastAttempts times or were rejected by the chain are failed instead.
 func (q *Queue) BroadcastPending() (int, error) {
-	total := len(q.messages)
+	q.lock.Lock()
+	pending := make([]*Message, len(q.messages))
+	copy(pending, q.messages)
+	q.lock.Unlock()
+
+	total := len(pending)
 	log.Info().Msg(fmt.Sprintf("Queue: %d messages waiting to be put on-chain...", total))
 
-	limit := 5000
-	unconfirmedTxs, err := q.wallet.Client.RPCClient.UnconfirmedTxs(context.Background(), &limit)
+	mempool, err := q.chain.MempoolSize(context.Background())
 	if err != nil {
 		log.Error().Err(err).Msg("could not get mempool status")
 		return 0, err
 	}
-	if unconfirmedTxs.Total > 2000 {
+	if mempool > 2000 {
 		log.Error().Msg("Cannot post messages when mempool is too large, waiting 30 minutes")
 		time.Sleep(time.Minute * 30)
 		return 0, nil
@@ -188,7 +225,7 @@ func (q *Queue) BroadcastPending() (int, error) {
 	msgs := make([]types.Msg, 0)
 	cutoff := 0
 	for i := 0; i < total; i++ {
-		msgs = append(msgs, q.messages[i].msg)
+		msgs = append(msgs, pending[i].msg)
 
 		size, err := calculateTransactionSize(msgs)
 		if err != nil {
@@ -214,8 +251,10 @@ func (q *Queue) BroadcastPending() (int, error) {
 
 	log.Info().Msg(fmt.Sprintf("Queue: Posting %d messages to chain...", cutoff))
 
-	toProcess := q.messages[:cutoff]
+	toProcess := pending[:cutoff]
+	q.lock.Lock()
 	q.messages = q.messages[cutoff:]
+	q.lock.Unlock()
 
 	allMsgs := make([]types.Msg, len(toProcess))
 	for i, process := range toProcess {
@@ -231,16 +270,16 @@ func (q *Queue) BroadcastPending() (int, error) {
 	var i int
 	for !complete && i < 10 {
 		i++
-		res, err = q.wallet.BroadcastTxSync(data)
+		res, err = q.chain.BroadcastTxSync(data)
 		if err != nil {
 			if strings.Contains(err.Error(), "tx already exists in cache") {
 				log.Info().Msg("TX already exists in mempool, we're going to skip it.")
 				continue
 			}
 			if strings.Contains(err.Error(), "mempool is full") {
-				log.Info().Msg("Mempool is full, waiting for 30 minutes before trying again and resetting queue")
+				log.Info().Msg("Mempool is full, waiting for 30 minutes before trying again")
 				time.Sleep(time.Minute * 30)
-				q.messages = make([]*Message, 0)
+				q.requeue(toProcess)
 				return 0, nil
 			}
 			if strings.Contains(err.Error(), "account sequence mismatch") {
@@ -254,6 +293,12 @@ func (q *Queue) BroadcastPending() (int, error) {
 					continue
 				}
 			}
+			if rejected(err) {
+				// the chain refused the messages, sending them again will not change that
+				log.Warn().Err(err).Msg("tx was rejected in simulation, failing messages")
+				q.fail(toProcess, err)
+				return 0, err
+			}
 			log.Warn().Err(err).Msg("tx broadcast failed from queue")
 			continue
 		}
@@ -280,9 +325,33 @@ func (q *Queue) BroadcastPending() (int, error) {
 	}
 
 	if !complete {
-		err = errors.New("could not complete broadcast in 10 loops")
+		// nothing is known about whether the messages landed, keep them journaled and try again
+		// until they ran out of attempts
+		if err == nil {
+			err = errors.New("could not complete broadcast in 10 loops")
+		}
+
+		retry := make([]*Message, 0, len(toProcess))
+		failed := make([]*Message, 0)
+		for _, process := range toProcess {
+			process.attempts++
+			if process.attempts >= maxBroadcastAttempts {
+				failed = append(failed, process)
+				continue
+			}
+			retry = append(retry, process)
+		}
+		q.saveAttempts(retry)
+		q.requeue(retry)
+
+		log.Warn().Err(err).Int("requeued", len(retry)).Int("failed", len(failed)).Msg("could not complete broadcast in 10 loops")
+		q.fail(failed, err)
+		return 0, err
 	}
 
+	// the broadcast result is known at this point
+	q.forget(toProcess)
+
 	for i, process := range toProcess {
 		process.err = err
 		process.res = res
@@ -293,6 +362,43 @@ func (q *Queue) BroadcastPending() (int, error) {
 	return cutoff, err
 }
 
+// rejected reports whether a broadcast error came from the chain refusing the transaction in
+// simulation, rather than from the node not being reachable.
+func rejected(err error) bool {
+	msg := err.Error()
+	if !strings.Contains(msg, "error while simulating tx") {
+		return false
+	}
+	for _, code := range []string{"code = Unavailable", "code = DeadlineExceeded", "code = Canceled"} {
+		if strings.Contains(msg, code) {
+			return false
+		}
+	}
+	return true
+}
+
+// fail gives up on messages, their callers are told err and they are removed from the journal.
+func (q *Queue) fail(messages []*Message, err error) {
+	q.forget(messages)
+
+	for _, process := range messages {
+		process.err = err
+		process.Done()
+	}
+}
+
+// requeue puts messages back at the front of the queue so they are retried in order.
+// Their journal entries are kept, and callers waiting on them keep waiting.
+func (q *Queue) requeue(messages []*Message) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.messages = append(append(make([]*Message, 0, len(messages)+len(q.messages)), messages...), q.messages...)
+}
+
 func (q *Queue) Count() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
 	return len(q.messages)
 }
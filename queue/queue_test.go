@@ -1,8 +1,15 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"testing"
 
+	"github.com/JackalLabs/sequoia/config"
+	"github.com/cosmos/cosmos-sdk/types"
+	walletTypes "github.com/desmos-labs/cosmos-go-wallet/types"
+	"github.com/dgraph-io/badger/v4"
+	storageTypes "github.com/jackalLabs/canine-chain/v5/x/storage/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -129,3 +136,108 @@ func TestExtractExpectedSequence_EdgeCases(t *testing.T) {
 		assert.Equal(t, uint64(1471614), seq)
 	})
 }
+
+func TestQueueJournal(t *testing.T) {
+	r := require.New(t)
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	q := NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{})
+	r.Equal(0, q.Count())
+
+	proof := storageTypes.NewMsgPostProof("jkl1creator", []byte("merkle"), "jkl1owner", 10, []byte("item"), []byte("[]"), 0)
+	claimer := storageTypes.NewMsgAddClaimer("jkl1creator", "jkl1claimer")
+
+	q.Add(proof)
+	q.Add(claimer)
+	m, _ := q.Add(proof) // duplicate proofs are skipped and not journaled
+	r.Equal(-1, m.Index())
+	r.Equal(2, q.Count())
+
+	restored := NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{})
+	r.Equal(2, restored.Count())
+
+	restoredProof, ok := restored.messages[0].msg.(*storageTypes.MsgPostProof)
+	r.True(ok)
+	r.Equal(proof.Merkle, restoredProof.Merkle)
+	r.Equal(proof.Start, restoredProof.Start)
+
+	_, ok = restored.messages[1].msg.(*storageTypes.MsgAddClaimer)
+	r.True(ok)
+
+	// new messages must not reuse journal keys of restored ones
+	m, _ = restored.Add(storageTypes.NewMsgAddClaimer("jkl1creator", "jkl1other"))
+	r.Equal(uint64(3), m.id)
+
+	restored.forget(restored.messages[:2])
+
+	empty := NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{})
+	r.Equal(1, empty.Count())
+}
+
+// failingChain never gets a transaction through.
+type failingChain struct {
+	err   error
+	calls int
+}
+
+func (c *failingChain) BroadcastTxSync(*walletTypes.TransactionData) (*types.TxResponse, error) {
+	c.calls++
+	return nil, c.err
+}
+
+func (c *failingChain) MempoolSize(context.Context) (int, error) {
+	return 0, nil
+}
+
+func TestBroadcastFailures(t *testing.T) {
+	r := require.New(t)
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	r.NoError(err)
+	//nolint:errcheck
+	defer db.Close()
+
+	broken := &failingChain{err: errors.New("post failed: connection refused")}
+	q := NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{})
+	q.chain = broken
+
+	proof := storageTypes.NewMsgPostProof("jkl1creator", []byte("merkle"), "jkl1owner", 10, []byte("item"), []byte("[]"), 0)
+	q.Add(proof)
+
+	// an unknown outcome is retried and the attempts survive a restart
+	for i := 1; i < maxBroadcastAttempts; i++ {
+		_, err = q.BroadcastPending()
+		r.Error(err)
+		r.Equal(1, q.Count())
+	}
+	r.Equal(10*(maxBroadcastAttempts-1), broken.calls)
+
+	q = NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{})
+	q.chain = broken
+	r.Equal(1, q.Count())
+	m := q.messages[0]
+	r.Equal(maxBroadcastAttempts-1, m.attempts)
+
+	// the last attempt fails the message so whoever waits on it is released
+	_, err = q.BroadcastPending()
+	r.Error(err)
+	m.wg.Wait()
+	r.Error(m.Error())
+	r.Equal(0, q.Count())
+	r.Equal(0, NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{}).Count())
+
+	// a transaction the chain refuses is failed right away
+	broken.err = errors.New("error while simulating tx: rpc error: code = Unknown desc = proof is invalid")
+	broken.calls = 0
+	m, wg := q.Add(proof)
+	_, err = q.BroadcastPending()
+	r.Error(err)
+	wg.Wait()
+	r.Equal(broken.err, m.Error())
+	r.Equal(1, broken.calls)
+	r.Equal(0, NewQueue(nil, db, 1, 0, "", config.RateLimitConfig{}).Count())
+}
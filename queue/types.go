@@ -1,17 +1,49 @@
 package queue
 
 import (
+	"context"
 	"sync"
 	"time"
 
+	"github.com/cosmos/cosmos-sdk/codec"
 	"github.com/cosmos/cosmos-sdk/types"
+	walletTypes "github.com/desmos-labs/cosmos-go-wallet/types"
 	"github.com/desmos-labs/cosmos-go-wallet/wallet"
+	"github.com/dgraph-io/badger/v4"
 	"golang.org/x/time/rate"
 )
 
+// chain is the part of the wallet the queue broadcasts through.
+type chain interface {
+	BroadcastTxSync(data *walletTypes.TransactionData) (*types.TxResponse, error)
+	MempoolSize(ctx context.Context) (int, error)
+}
+
+// walletChain broadcasts with the provider wallet.
+type walletChain struct {
+	w *wallet.Wallet
+}
+
+func (c walletChain) BroadcastTxSync(data *walletTypes.TransactionData) (*types.TxResponse, error) {
+	return c.w.BroadcastTxSync(data)
+}
+
+func (c walletChain) MempoolSize(ctx context.Context) (int, error) {
+	limit := 5000
+	res, err := c.w.Client.RPCClient.UnconfirmedTxs(ctx, &limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.Total, nil
+}
+
 type Queue struct {
-	wallet       *wallet.Wallet
+	chain        chain
+	db           *badger.DB
+	cdc          codec.Codec
+	lock         sync.Mutex
 	messages     []*Message
+	nextId       uint64
 	processed    time.Time
 	running      bool
 	interval     uint64
@@ -22,11 +54,13 @@ type Queue struct {
 }
 
 type Message struct {
+	id       uint64
 	msg      types.Msg
 	wg       *sync.WaitGroup
 	err      error
 	res      *types.TxResponse
 	msgIndex int
+	attempts int // broadcasts that ended without a known result
 }
 
 func (m *Message) Error() error {
@@ -47,8 +47,24 @@ func (h *Hand) Start(f *file_system.FileSystem, wallet *wallet.Wallet, q *queue.
 			h.stray = nil
 			continue
 		}
+		if !hasTree { // reuse the file if another contract already stored it
+			hasTree, err = f.CopyContract(merkle, signee, start)
+			if err != nil {
+				log.Warn().Err(err).Hex("merkle", merkle).Str("owner", signee).Int64("start", start).Msg("could not reuse stored file for stray")
+				h.stray = nil
+				continue
+			}
+		}
 		if !hasTree { // only download if we don't have it
-			err := network.DownloadFile(f, merkle, signee, start, wallet, h.stray.FileSize, myUrl, chunkSize, proofType, utils.GetIPFSParams(h.stray))
+			release, err := f.ReserveSpace(h.stray.FileSize)
+			if err != nil {
+				// leave the stray for providers with room to spare
+				log.Warn().Err(err).Hex("merkle", merkle).Msg("not claiming stray")
+				h.stray = nil
+				continue
+			}
+			err = network.DownloadFile(f, merkle, signee, start, wallet, h.stray.FileSize, myUrl, chunkSize, proofType, utils.GetIPFSParams(h.stray), file_system.SourceStray)
+			release()
 			if err != nil {
 				log.Error().Err(err)
 				h.stray = nil
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// Outcomes of a proof attempt, everything but ProofResultProven is a class of failure.
+const (
+	ProofResultProven     = "proven"
+	ProofResultChain      = "chain_query" // the file or its proof could not be read from the chain
+	ProofResultGeneration = "generation"  // the proof could not be built from the stored data
+	ProofResultBroadcast  = "broadcast"   // the transaction carrying the proof was never accepted
+	ProofResultTx         = "tx_failed"   // the transaction was included with a non-zero code
+	ProofResultRejected   = "rejected"    // the chain ran the proof and found it invalid
+	ProofResultResponse   = "response"    // the transaction went through but its response was unreadable
+)
+
+// ProofAttempt is the record of a single try at proving a contract.
+type ProofAttempt struct {
+	Time   time.Time `json:"time"`
+	Height int64     `json:"height"`
+	Window int64     `json:"window"` // proof interval of the contract in blocks, 0 if it was never read
+	Chunk  int64     `json:"chunk"`
+	TxHash string    `json:"tx_hash,omitempty"`
+	Code   uint32    `json:"code"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Proven reports whether the attempt landed a valid proof on chain.
+func (a *ProofAttempt) Proven() bool {
+	return a.Result == ProofResultProven
+}
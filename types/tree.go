@@ -0,0 +1,10 @@
+package types
+
+import merkletree "github.com/wealdtech/go-merkletree/v2"
+
+// ProofTree is the part of a merkle tree needed to prove a single chunk. It is implemented by
+// go-merkletree's MerkleTree and by the compact trees read from disk.
+type ProofTree interface {
+	Root() []byte
+	GenerateProofWithIndex(index uint64, height int) (*merkletree.Proof, error)
+}